@@ -0,0 +1,151 @@
+package api
+
+import (
+	"encoding/json"
+	"goplay/database"
+	"goplay/logging"
+	"goplay/model"
+	"goplay/validation"
+	"net/http"
+	"strings"
+)
+
+// GetAccountHandler returns the requester's profile and preferences
+func GetAccountHandler(w http.ResponseWriter, r *http.Request) {
+	owner, _, _ := getUserFromAuthToken(r)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(accountOf(owner))
+}
+
+// UpdateAccountHandler changes the profile fields and preferences present in the body.
+// Changing the email takes the current password, and a code when two-factor authentication
+// is on. A new email address is sent a verification link.
+func (a *Auth) UpdateAccountHandler(w http.ResponseWriter, r *http.Request) {
+	var res model.ResponseResult
+	w.Header().Set("Content-Type", "application/json")
+
+	var update model.AccountUpdate
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res.Error = "Invalid body"
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	owner, _, _ := getUserFromAuthToken(r)
+	if update.Email != nil && strings.EqualFold(*update.Email, owner.Email) {
+		// Unchanged but maybe for case, which addresses are looked up without, so it stays verified
+		update.Email = nil
+	}
+	if err := validation.AccountUpdate(r.Context(), update, owner.OID); err != nil {
+		writeInvalid(w, r, err)
+		return
+	}
+	// Reset links go to the email, so with only a token it would be a way to take the account over
+	if update.Email != nil && !a.confirmed(w, r, owner, update.CurrentPassword, update.Code, "Error while updating the account, Try again") {
+		return
+	}
+
+	// The unique index decides when another account takes the email at the same time
+	err := database.UpdateAccount(r.Context(), owner.OID, update)
+	if err == database.ErrEmailTaken {
+		writeInvalid(w, r, validation.EmailTaken)
+		return
+	}
+	if err == nil {
+		owner, err = database.GetUser(r.Context(), owner.OID)
+	}
+	if err != nil {
+		logging.FromContext(r.Context()).Error("updating account", "error", err)
+		w.WriteHeader(errorStatus(err))
+		res.Error = "Error while updating the account, Try again"
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	if update.Email != nil && len(owner.Email) > 0 {
+		if err := a.sendVerification(r, owner); err != nil {
+			logging.FromContext(r.Context()).Error("sending verification link", "error", err)
+		}
+	}
+
+	json.NewEncoder(w).Encode(accountOf(owner))
+}
+
+// DeleteAccountHandler removes the requester with their identities, habits and logs once they
+// confirm with their password, and a code too when two-factor authentication is on
+func (a *Auth) DeleteAccountHandler(w http.ResponseWriter, r *http.Request) {
+	var res model.ResponseResult
+	w.Header().Set("Content-Type", "application/json")
+
+	var deletion model.AccountDeletion
+	if err := json.NewDecoder(r.Body).Decode(&deletion); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res.Error = "Invalid body"
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	owner, _, _ := getUserFromAuthToken(r)
+	if !a.confirmed(w, r, owner, deletion.Password, deletion.Code, "Error while deleting the account, Try again") {
+		return
+	}
+
+	if err := database.DeleteAccount(r.Context(), owner.OID); err != nil {
+		logging.FromContext(r.Context()).Error("deleting account", "error", err)
+		w.WriteHeader(errorStatus(err))
+		res.Error = "Error while deleting the account, Try again"
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	logging.FromContext(r.Context()).Info("account deleted", "username", owner.Username)
+	res.Result = "Account deleted"
+	json.NewEncoder(w).Encode(res)
+}
+
+// confirmed checks the password of owner, and a code too when two-factor authentication
+// is on, before a change a stolen token shouldn't be enough for. Otherwise it answers
+// with 403, or with failure when the code couldn't be checked.
+func (a *Auth) confirmed(w http.ResponseWriter, r *http.Request, owner model.User, password string, code string, failure string) bool {
+	var res model.ResponseResult
+
+	if match, _, _ := a.hasher.Verify(password, owner.Password); !match {
+		w.WriteHeader(http.StatusForbidden)
+		res.Error = "The password is wrong"
+		json.NewEncoder(w).Encode(res)
+		return false
+	}
+
+	if len(owner.TOTPSecret) > 0 {
+		ok, err := useCode(r.Context(), owner, code)
+		if err != nil {
+			logging.FromContext(r.Context()).Error("checking two-factor code", "error", err)
+			w.WriteHeader(errorStatus(err))
+			res.Error = failure
+			json.NewEncoder(w).Encode(res)
+			return false
+		}
+		if !ok {
+			w.WriteHeader(http.StatusForbidden)
+			res.Error = wrongCode
+			json.NewEncoder(w).Encode(res)
+			return false
+		}
+	}
+	return true
+}
+
+// accountOf is what a user is shown of their own account
+func accountOf(user model.User) model.Account {
+	return model.Account{
+		ID:               user.OID,
+		Username:         user.Username,
+		FirstName:        user.FirstName,
+		LastName:         user.LastName,
+		Email:            user.Email,
+		EmailVerified:    user.EmailVerified,
+		Preferences:      user.Preferences,
+		TwoFactorEnabled: len(user.TOTPSecret) > 0,
+	}
+}
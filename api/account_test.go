@@ -0,0 +1,58 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"goplay/database"
+	"goplay/mail"
+	"goplay/model"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// sentMail keeps the messages instead of sending them
+type sentMail []mail.Message
+
+func (s *sentMail) Send(ctx context.Context, msg mail.Message) error {
+	*s = append(*s, msg)
+	return nil
+}
+
+func TestEmailCaseChangeStaysVerified(t *testing.T) {
+	openDatabase(t)
+	ctx := context.Background()
+
+	userID, err := database.CreateUser(ctx, model.User{Username: "casey", Password: "hash", Email: "Casey@Example.com", EmailVerified: true})
+	if err != nil {
+		t.Fatal("creating user:", err)
+	}
+	owner, err := database.GetUser(ctx, userID)
+	if err != nil {
+		t.Fatal("getting user:", err)
+	}
+
+	var sent sentMail
+	a := &Auth{mailer: &sent}
+
+	// No current_password, which only a change of the address takes
+	r := httptest.NewRequest(http.MethodPatch, "/api/account", strings.NewReader(`{"email": "casey@example.COM"}`))
+	r = r.WithContext(context.WithValue(r.Context(), userKey{}, owner))
+	w := httptest.NewRecorder()
+	a.UpdateAccountHandler(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("changing the case of the email answered %d: %s", w.Code, w.Body)
+	}
+	var account model.Account
+	if err := json.NewDecoder(w.Body).Decode(&account); err != nil {
+		t.Fatal("decoding account:", err)
+	}
+	if !account.EmailVerified || account.Email != owner.Email {
+		t.Errorf("after changing the case the email is %q, verified %t, want %q still verified", account.Email, account.EmailVerified, owner.Email)
+	}
+	if len(sent) > 0 {
+		t.Errorf("changing the case sent %d verification links", len(sent))
+	}
+}
@@ -1,21 +1,19 @@
 package api
 
 import (
-	"context"
 	"encoding/json"
+	"errors"
 	"goplay/database"
+	"goplay/logging"
 	"goplay/model"
-	"io/ioutil"
+	"goplay/validation"
 	"log"
 	"net/http"
 	"strings"
 
-	"github.com/dgrijalva/jwt-go"
 	"github.com/gorilla/mux"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo/options"
-	"golang.org/x/crypto/bcrypt"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 // CreateLogHandler creates a log owned by the requester
@@ -23,16 +21,27 @@ func CreateLogHandler(w http.ResponseWriter, r *http.Request) {
 	var logEntry model.Log
 	owner, _, _ := getUserFromAuthToken(r)
 
-	logEntry.UserID = owner.OID
-
 	err := json.NewDecoder(r.Body).Decode(&logEntry)
 	if err != nil {
-		log.Fatal("Invalid params", err)
+		writeInvalidBody(w)
+		return
 	}
+	// Set after decoding, so an id or user_id in the body can't choose the id or the account
+	logEntry.ID = nil
+	logEntry.UserID = owner.OID
 
-	createLogResult := database.CreateLog(logEntry)
+	if err := validation.Log(logEntry); err != nil {
+		writeInvalid(w, r, err)
+		return
+	}
 
-	resultJSON, err := json.Marshal(createLogResult)
+	id, err := database.CreateLog(r.Context(), logEntry)
+	if err != nil {
+		writeError(w, r, err, "creating log", "Error while creating the log, Try again")
+		return
+	}
+
+	resultJSON, err := json.Marshal(mongo.InsertOneResult{InsertedID: id})
 	w.Header().Set("Content-Type", "application/json")
 	w.Write(resultJSON)
 }
@@ -42,16 +51,31 @@ func CreateHabitHandler(w http.ResponseWriter, r *http.Request) {
 	var habit model.Habit
 	owner, _, _ := getUserFromAuthToken(r)
 
-	habit.UserID = owner.OID
-
 	err := json.NewDecoder(r.Body).Decode(&habit)
 	if err != nil {
-		log.Fatal("Invalid params", err)
+		writeInvalidBody(w)
+		return
+	}
+	// Set after decoding, so an id or user_id in the body can't choose the id or the account
+	habit.ID = nil
+	habit.UserID = owner.OID
+
+	if err := validation.Habit(r.Context(), habit, owner.OID, nil); err != nil {
+		writeInvalid(w, r, err)
+		return
 	}
 
-	result := database.CreateHabit(habit)
+	id, err := database.CreateHabit(r.Context(), habit)
+	if err == database.ErrHabitNameTaken {
+		writeInvalid(w, r, validation.HabitNameTaken)
+		return
+	}
+	if err != nil {
+		writeError(w, r, err, "creating habit", "Error while creating the habit, Try again")
+		return
+	}
 
-	json, err := json.Marshal(result)
+	json, err := json.Marshal(mongo.InsertOneResult{InsertedID: id})
 	w.Header().Set("Content-Type", "application/json")
 	w.Write(json)
 }
@@ -61,16 +85,27 @@ func CreateIdentityHandler(w http.ResponseWriter, r *http.Request) {
 	var identity model.Identity
 	owner, _, _ := getUserFromAuthToken(r)
 
-	identity.UserID = owner.OID
-
 	err := json.NewDecoder(r.Body).Decode(&identity)
 	if err != nil {
-		log.Fatal("Invalid params", err)
+		writeInvalidBody(w)
+		return
 	}
+	// Set after decoding, so an id or user_id in the body can't choose the id or the account
+	identity.ID = nil
+	identity.UserID = owner.OID
 
-	result := database.CreateIdentity(identity)
+	if err := validation.Identity(identity); err != nil {
+		writeInvalid(w, r, err)
+		return
+	}
 
-	json, err := json.Marshal(result)
+	id, err := database.CreateIdentity(r.Context(), identity)
+	if err != nil {
+		writeError(w, r, err, "creating identity", "Error while creating the identity, Try again")
+		return
+	}
+
+	json, err := json.Marshal(mongo.InsertOneResult{InsertedID: id})
 	w.Header().Set("Content-Type", "application/json")
 	w.Write(json)
 }
@@ -79,8 +114,13 @@ func CreateIdentityHandler(w http.ResponseWriter, r *http.Request) {
 func GetIdentitiesHandler(w http.ResponseWriter, r *http.Request) {
 	owner, _, _ := getUserFromAuthToken(r)
 
-	identitiesJSON, err := json.Marshal(database.GetIdentities(owner.OID))
+	identities, err := database.GetIdentities(r.Context(), owner.OID)
+	if err != nil {
+		writeError(w, r, err, "listing identities", "Error while listing the identities, Try again")
+		return
+	}
 
+	identitiesJSON, err := json.Marshal(identities)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -93,17 +133,18 @@ func GetIdentitiesHandler(w http.ResponseWriter, r *http.Request) {
 func DeleteIdentityHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	objID, _ := primitive.ObjectIDFromHex(vars["_id"])
-	filter := bson.D{{"_id", objID}}
+	owner, _, _ := getUserFromAuthToken(r)
 
-	if ensureIdentityOwner(w, r, filter) == false {
+	deleted, err := database.DeleteIdentity(r.Context(), objID, owner.OID)
+	if err != nil {
+		writeError(w, r, err, "deleting identity", "Error while deleting the identity, Try again")
 		return
 	}
-
-	deleteResult, err := database.Identities.DeleteOne(context.TODO(), filter)
-	if err != nil {
-		log.Fatal(err)
+	if !deleted {
+		writeNotFound(w, "Identity")
+		return
 	}
-	resultJSON, _ := json.Marshal(deleteResult)
+	resultJSON, _ := json.Marshal(mongo.DeleteResult{DeletedCount: 1})
 
 	w.Header().Set("Content-Type", "application/json")
 	w.Write(resultJSON)
@@ -113,17 +154,18 @@ func DeleteIdentityHandler(w http.ResponseWriter, r *http.Request) {
 func DeleteLogHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	objID, _ := primitive.ObjectIDFromHex(vars["_id"])
-	filter := bson.D{{"_id", objID}}
+	owner, _, _ := getUserFromAuthToken(r)
 
-	if ensureLogOwner(w, r, filter) == false {
+	deleted, err := database.DeleteLog(r.Context(), objID, owner.OID)
+	if err != nil {
+		writeError(w, r, err, "deleting log", "Error while deleting the log, Try again")
 		return
 	}
-
-	deleteResult, err := database.Logs.DeleteOne(context.TODO(), filter)
-	if err != nil {
-		log.Fatal(err)
+	if !deleted {
+		writeNotFound(w, "Log")
+		return
 	}
-	resultJSON, _ := json.Marshal(deleteResult)
+	resultJSON, _ := json.Marshal(mongo.DeleteResult{DeletedCount: 1})
 
 	w.Header().Set("Content-Type", "application/json")
 	w.Write(resultJSON)
@@ -132,105 +174,55 @@ func DeleteLogHandler(w http.ResponseWriter, r *http.Request) {
 func DeleteHabitHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	objID, _ := primitive.ObjectIDFromHex(vars["_id"])
-	filter := bson.D{{"_id", objID}}
+	owner, _, _ := getUserFromAuthToken(r)
 
-	if ensureHabitOwner(w, r, filter) == false {
+	deleted, err := database.DeleteHabit(r.Context(), objID, owner.OID)
+	if err != nil {
+		writeError(w, r, err, "deleting habit", "Error while deleting the habit, Try again")
 		return
 	}
-
-	deleteResult, err := database.Habits.DeleteOne(context.TODO(), filter)
-	if err != nil {
-		log.Fatal(err)
+	if !deleted {
+		writeNotFound(w, "Habit")
+		return
 	}
-	resultJSON, _ := json.Marshal(deleteResult)
+	resultJSON, _ := json.Marshal(mongo.DeleteResult{DeletedCount: 1})
 
 	w.Header().Set("Content-Type", "application/json")
 	w.Write(resultJSON)
 }
 
-func ensureLogOwner(w http.ResponseWriter, r *http.Request, filter bson.D) bool {
-	var logEntry model.Log
-
-	// Make sure the log is owned by the same user
-	owner, _, _ := getUserFromAuthToken(r)
-	findErr := database.Logs.FindOne(context.TODO(), filter).Decode(&logEntry)
-	if findErr != nil {
-		log.Fatal("Log not found", findErr)
-	}
-
-	if logEntry.UserID != owner.OID {
-		w.WriteHeader(http.StatusForbidden)
-		notFound, _ := json.Marshal("Computer says no")
-		w.Write(notFound)
-		return false
-	}
-	return true
-}
-
-func ensureHabitOwner(w http.ResponseWriter, r *http.Request, filter bson.D) bool {
-	var habit model.Habit
-
-	// Make sure the habit is owned by the same user
-	owner, _, _ := getUserFromAuthToken(r)
-	findErr := database.Habits.FindOne(context.TODO(), filter).Decode(&habit)
-	if findErr != nil {
-		log.Fatal("Habit not found", findErr)
-	}
-
-	if habit.UserID != owner.OID {
-		w.WriteHeader(http.StatusForbidden)
-		notFound, _ := json.Marshal("Computer says no")
-		w.Write(notFound)
-		return false
-	}
-	return true
-}
-
-func ensureIdentityOwner(w http.ResponseWriter, r *http.Request, filter bson.D) bool {
-	var identity model.Identity
-
-	// Make sure the identity is owned by the same user
-	owner, _, _ := getUserFromAuthToken(r)
-	findErr := database.Identities.FindOne(context.TODO(), filter).Decode(&identity)
-	if findErr != nil {
-		log.Fatal("Identity not found", findErr)
-	}
-
-	if identity.UserID != owner.OID {
-		w.WriteHeader(http.StatusForbidden)
-		notFound, _ := json.Marshal("Computer says no")
-		w.Write(notFound)
-		return false
-	}
-	return true
-}
-
 // UpdateLogHandler updates a log if the requester is the owner
 func UpdateLogHandler(w http.ResponseWriter, r *http.Request) {
 	var logEntry model.Log
 	vars := mux.Vars(r)
 	objID, _ := primitive.ObjectIDFromHex(vars["_id"])
-	filter := bson.D{{"_id", objID}}
-
-	if ensureHabitOwner(w, r, filter) == false {
+	err := json.NewDecoder(r.Body).Decode(&logEntry)
+	if err != nil {
+		writeInvalidBody(w)
 		return
 	}
 
-	err := json.NewDecoder(r.Body).Decode(&logEntry)
-	if err != nil {
-		log.Fatal("Invalid params", err)
+	if err := validation.Log(logEntry); err != nil {
+		writeInvalid(w, r, err)
 		return
 	}
 
-	update := bson.D{
-		{"$set", logEntry},
+	update := database.LogUpdate{Entry: logEntry.Entry}
+	if len(logEntry.Habits) > 0 {
+		update.Habits = logEntry.Habits
 	}
 
-	updateResult, err := database.Logs.UpdateOne(context.TODO(), filter, update)
+	owner, _, _ := getUserFromAuthToken(r)
+	matched, err := database.UpdateLog(r.Context(), objID, owner.OID, update)
 	if err != nil {
-		log.Fatal(err)
+		writeError(w, r, err, "updating log", "Error while updating the log, Try again")
+		return
+	}
+	if !matched {
+		writeNotFound(w, "Log")
+		return
 	}
-	resultJSON, err := json.Marshal(updateResult)
+	resultJSON, err := json.Marshal(mongo.UpdateResult{MatchedCount: 1, ModifiedCount: 1})
 	w.Header().Set("Content-Type", "application/json")
 	w.Write(resultJSON)
 }
@@ -240,39 +232,41 @@ func UpdateHabitHandler(w http.ResponseWriter, r *http.Request) {
 	var habit model.Habit
 	vars := mux.Vars(r)
 	objID, _ := primitive.ObjectIDFromHex(vars["_id"])
-	filter := bson.D{{"_id", objID}}
-
-	if ensureHabitOwner(w, r, filter) == false {
-		return
-	}
-
 	err := json.NewDecoder(r.Body).Decode(&habit)
 	if err != nil {
-		log.Fatal("Invalid params", err)
+		writeInvalidBody(w)
 		return
 	}
 
-	update := bson.D{
-		{"$set", habit},
+	owner, _, _ := getUserFromAuthToken(r)
+	if err := validation.Habit(r.Context(), habit, owner.OID, &objID); err != nil {
+		writeInvalid(w, r, err)
+		return
 	}
 
-	after := options.After
-	upsert := true
-	opt := options.FindOneAndUpdateOptions{
-		ReturnDocument: &after,
-		Upsert:         &upsert,
+	update := database.HabitUpdate{Name: habit.Name}
+	if len(habit.Description) > 0 {
+		update.Description = &habit.Description
 	}
-
-	result := database.Habits.FindOneAndUpdate(context.TODO(), filter, update, &opt)
-
-	if result.Err() != nil {
-		log.Fatal("Eror updating habit", result.Err())
+	if !habit.IdentityID.IsZero() {
+		update.IdentityID = &habit.IdentityID
 	}
 
-	decodeErr := result.Decode(&habit)
-
-	if decodeErr != nil {
-		log.Fatal("Error decoding habit", decodeErr)
+	matched, err := database.UpdateHabit(r.Context(), objID, owner.OID, update)
+	if err == database.ErrHabitNameTaken {
+		writeInvalid(w, r, validation.HabitNameTaken)
+		return
+	}
+	if err == nil && matched {
+		habit, err = database.GetHabit(r.Context(), objID, owner.OID)
+	}
+	if err != nil {
+		writeError(w, r, err, "updating habit", "Error while updating the habit, Try again")
+		return
+	}
+	if !matched {
+		writeNotFound(w, "Habit")
+		return
 	}
 
 	resultJSON, err := json.Marshal(habit)
@@ -286,38 +280,59 @@ func UpdateIdentityHandler(w http.ResponseWriter, r *http.Request) {
 	var identity model.Identity
 	vars := mux.Vars(r)
 	objID, _ := primitive.ObjectIDFromHex(vars["_id"])
-	filter := bson.D{{"_id", objID}}
-
-	if ensureIdentityOwner(w, r, filter) == false {
+	err := json.NewDecoder(r.Body).Decode(&identity)
+	if err != nil {
+		writeInvalidBody(w)
 		return
 	}
 
-	err := json.NewDecoder(r.Body).Decode(&identity)
-	if err != nil {
-		log.Fatal("Invalid params", err)
+	if err := validation.Identity(identity); err != nil {
+		writeInvalid(w, r, err)
 		return
 	}
 
-	update := bson.D{
-		{"$set", identity},
+	update := database.IdentityUpdate{Name: identity.Name}
+	if len(identity.Description) > 0 {
+		update.Description = &identity.Description
 	}
 
-	updateResult, err := database.Identities.UpdateOne(context.TODO(), filter, update)
+	owner, _, _ := getUserFromAuthToken(r)
+	matched, err := database.UpdateIdentity(r.Context(), objID, owner.OID, update)
 	if err != nil {
-		log.Fatal(err)
+		writeError(w, r, err, "updating identity", "Error while updating the identity, Try again")
+		return
+	}
+	if !matched {
+		writeNotFound(w, "Identity")
+		return
 	}
-	resultJSON, err := json.Marshal(updateResult)
+	resultJSON, err := json.Marshal(mongo.UpdateResult{MatchedCount: 1, ModifiedCount: 1})
 	w.Header().Set("Content-Type", "application/json")
 	w.Write(resultJSON)
 }
 
-// GetLogHandler retrieves a log by using the route param
+// GetLogHandler retrieves a log by using the route param.
+// With ?render=html the entry is returned as sanitized html instead of json.
 func GetLogHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
 	owner, _, _ := getUserFromAuthToken(r)
 	vars := mux.Vars(r)
 	objID, _ := primitive.ObjectIDFromHex(vars["_id"])
-	logEntry := database.GetLog(objID, owner.OID)
+	logEntry, err := database.GetLog(r.Context(), objID, owner.OID)
+	if err == database.ErrNotFound {
+		writeNotFound(w, "Log")
+		return
+	}
+	if err != nil {
+		writeError(w, r, err, "reading log", "Error while reading the log, Try again")
+		return
+	}
+
+	if r.URL.Query().Get("render") == "html" {
+		writeLogHTML(w, r, logEntry)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
 	resultJSON, _ := json.Marshal(logEntry)
 	w.Write(resultJSON)
 }
@@ -326,8 +341,13 @@ func GetLogHandler(w http.ResponseWriter, r *http.Request) {
 func GetLogsHandler(w http.ResponseWriter, r *http.Request) {
 	owner, _, _ := getUserFromAuthToken(r)
 
-	logsJSON, err := json.Marshal(database.GetLogs(owner.OID))
+	logs, err := database.GetLogs(r.Context(), owner.OID)
+	if err != nil {
+		writeError(w, r, err, "listing logs", "Error while listing the logs, Try again")
+		return
+	}
 
+	logsJSON, err := json.Marshal(logs)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -339,8 +359,13 @@ func GetLogsHandler(w http.ResponseWriter, r *http.Request) {
 // GetHabitsHandler returns the owners habits
 func GetHabitsHandler(w http.ResponseWriter, r *http.Request) {
 	owner, _, _ := getUserFromAuthToken(r)
-	json, err := json.Marshal(database.GetHabits(owner.OID))
+	habits, err := database.GetHabits(r.Context(), owner.OID)
+	if err != nil {
+		writeError(w, r, err, "listing habits", "Error while listing the habits, Try again")
+		return
+	}
 
+	json, err := json.Marshal(habits)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -349,135 +374,63 @@ func GetHabitsHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write(json)
 }
 
-// Auth
-func RegisterHandler(w http.ResponseWriter, r *http.Request) {
-
-	w.Header().Set("Content-Type", "application/json")
-	var user model.User
-	body, _ := ioutil.ReadAll(r.Body)
-	err := json.Unmarshal(body, &user)
-	var res model.ResponseResult
-	if err != nil {
-		res.Error = err.Error()
-		json.NewEncoder(w).Encode(res)
-		return
+// errorStatus is the status answering err: 504 when the database ran out of time, 503 when
+// the request was canceled before it answered, which only proxies get to see, and 500 otherwise
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, database.ErrTimeout):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, database.ErrCanceled):
+		return http.StatusServiceUnavailable
 	}
+	return http.StatusInternalServerError
+}
 
-	var result model.User
-	err = database.Users.FindOne(context.TODO(), bson.D{{"username", user.Username}}).Decode(&result)
-
-	if err != nil {
-		if err.Error() == "mongo: no documents in result" {
-			hash, err := bcrypt.GenerateFromPassword([]byte(user.Password), 5)
-
-			if err != nil {
-				res.Error = "Error While Hashing Password, Try Again"
-				json.NewEncoder(w).Encode(res)
-				return
-			}
-			user.Password = string(hash)
-
-			_, err = database.Users.InsertOne(context.TODO(), user)
-			if err != nil {
-				res.Error = "Error While Creating User, Try Again"
-				json.NewEncoder(w).Encode(res)
-				return
-			}
-			res.Result = "Registration Successful"
-			json.NewEncoder(w).Encode(res)
-			return
-		}
-
-		res.Error = err.Error()
-		json.NewEncoder(w).Encode(res)
-		return
-	}
+// writeError logs a database error and answers it with message
+func writeError(w http.ResponseWriter, r *http.Request, err error, action string, message string) {
+	logging.FromContext(r.Context()).Error(action, "error", err)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(errorStatus(err))
+	json.NewEncoder(w).Encode(model.ResponseResult{Error: message})
+}
 
-	res.Result = "Username already Exists!!"
-	json.NewEncoder(w).Encode(res)
-	return
+// writeNotFound answers 404 for a thing that doesn't exist or isn't the requester's
+func writeNotFound(w http.ResponseWriter, thing string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusNotFound)
+	json.NewEncoder(w).Encode(model.ResponseResult{Error: thing + " not found"})
 }
 
-func LoginHandler(w http.ResponseWriter, r *http.Request) {
+// writeInvalidBody answers 400 to a body that isn't the expected json
+func writeInvalidBody(w http.ResponseWriter) {
 	w.Header().Set("Content-Type", "application/json")
-	var user model.User
-	body, _ := ioutil.ReadAll(r.Body)
-	err := json.Unmarshal(body, &user)
-	if err != nil {
-		log.Fatal(err)
-	}
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(model.ResponseResult{Error: "Invalid body"})
+}
 
-	var result model.User
+// writeInvalid answers 422 with the fields that broke a validation rule,
+// or an error status when the rules couldn't be checked
+func writeInvalid(w http.ResponseWriter, r *http.Request, err error) {
 	var res model.ResponseResult
+	w.Header().Set("Content-Type", "application/json")
 
-	err = database.Users.FindOne(context.TODO(), bson.D{{"username", user.Username}}).Decode(&result)
-
-	if err != nil {
-		res.Error = "Invalid username"
-		json.NewEncoder(w).Encode(res)
-		return
-	}
-
-	err = bcrypt.CompareHashAndPassword([]byte(result.Password), []byte(user.Password))
-
-	if err != nil {
-		res.Error = "Invalid password"
-		json.NewEncoder(w).Encode(res)
-		return
-	}
-
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"username":  result.Username,
-		"firstname": result.FirstName,
-		"lastname":  result.LastName,
-	})
-
-	tokenString, err := token.SignedString([]byte("jonapi"))
-
-	if err != nil {
-		res.Error = "Error while generating token,Try again"
-		json.NewEncoder(w).Encode(res)
-		return
+	if invalid, ok := err.(validation.Errors); ok {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		res.Error = "Invalid " + strings.Join(invalidFields(invalid), ", ")
+		res.Fields = invalid
+	} else {
+		logging.FromContext(r.Context()).Error("validating request", "error", err)
+		w.WriteHeader(errorStatus(err))
+		res.Error = "Error while validating, Try again"
 	}
 
-	result.Token = tokenString
-	result.Password = ""
-
-	json.NewEncoder(w).Encode(result)
-}
-
-func getUserFromAuthToken(r *http.Request) (model.User, bool, error) {
-	tokenString := strings.Split(r.Header.Get("Authorization"), "Bearer ")[1]
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		return []byte("jonapi"), nil
-	})
-	var user model.User
-	var ok bool
-	if claims, _ := token.Claims.(jwt.MapClaims); token.Valid {
-		user.Username = claims["username"].(string)
-		user.FirstName = claims["firstname"].(string)
-		user.LastName = claims["lastname"].(string)
-		ok = true
-
-		filter := bson.D{{"username", user.Username}}
-		err := database.Users.FindOne(context.TODO(), filter).Decode(&user)
-
-		if err != nil {
-			log.Fatal(err)
-		}
-	}
-	return user, ok, err
+	json.NewEncoder(w).Encode(res)
 }
 
-// ProfileHandler return the user's profile encoded in the jwt token claims
-func ProfileHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	user, ok, err := getUserFromAuthToken(r)
-	var res model.ResponseResult
-	if ok {
-		json.NewEncoder(w).Encode(user)
-	} else {
-		res.Error = err.Error()
-		json.NewEncoder(w).Encode(res)
+func invalidFields(invalid validation.Errors) []string {
+	fields := make([]string, len(invalid))
+	for i, field := range invalid {
+		fields[i] = field.Field
 	}
+	return fields
 }
@@ -0,0 +1,192 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"goplay/database"
+	"goplay/logging"
+	"goplay/model"
+	"goplay/validation"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// apiKeyPrefix starts every API key, so the middleware can tell them from tokens
+const apiKeyPrefix = "gp_"
+
+// apiKeyPrefixLength is how much of a key is kept to tell keys apart
+const apiKeyPrefixLength = len(apiKeyPrefix) + 8
+
+// lastUsedResolution is how stale the last use of a key may get, so
+// scripts calling in a loop don't write to the database every request
+const lastUsedResolution = time.Minute
+
+// apiKeyKey is the request context key the middleware stores the API key under
+type apiKeyKey struct{}
+
+// GetAPIKeysHandler lists the requester's API keys, without the keys themselves
+func GetAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	owner, _, _ := getUserFromAuthToken(r)
+	w.Header().Set("Content-Type", "application/json")
+
+	keys, err := database.GetAPIKeys(r.Context(), owner.OID)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("listing api keys", "error", err)
+		w.WriteHeader(errorStatus(err))
+		json.NewEncoder(w).Encode(model.ResponseResult{Error: "Error while listing the API keys, Try again"})
+		return
+	}
+
+	json.NewEncoder(w).Encode(keys)
+}
+
+// CreateAPIKeyHandler creates a named API key with a scope. The key is only shown in this response.
+func CreateAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	var res model.ResponseResult
+	w.Header().Set("Content-Type", "application/json")
+
+	var key model.APIKey
+	if err := json.NewDecoder(r.Body).Decode(&key); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res.Error = "Invalid body"
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	if err := validation.APIKey(key); err != nil {
+		writeInvalid(w, r, err)
+		return
+	}
+
+	secret, err := randomToken()
+	if err != nil {
+		logging.FromContext(r.Context()).Error("generating api key", "error", err)
+		w.WriteHeader(errorStatus(err))
+		res.Error = "Error while creating the API key, Try again"
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	owner, _, _ := getUserFromAuthToken(r)
+	secret = apiKeyPrefix + secret
+	key.ID = nil
+	key.UserID = owner.OID
+	key.Prefix = secret[:apiKeyPrefixLength]
+	key.KeyHash = hashToken(secret)
+	key.CreatedAt = time.Now().UTC()
+	key.LastUsedAt = nil
+
+	id, err := database.CreateAPIKey(r.Context(), key)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("creating api key", "error", err)
+		w.WriteHeader(errorStatus(err))
+		res.Error = "Error while creating the API key, Try again"
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+	key.ID = &id
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(model.NewAPIKey{APIKey: key, Key: secret})
+}
+
+// DeleteAPIKeyHandler revokes one of the requester's API keys
+func DeleteAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	var res model.ResponseResult
+	w.Header().Set("Content-Type", "application/json")
+
+	owner, _, _ := getUserFromAuthToken(r)
+	id, _ := primitive.ObjectIDFromHex(mux.Vars(r)["_id"])
+
+	deleted, err := database.DeleteAPIKey(r.Context(), id, owner.OID)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("revoking api key", "error", err)
+		w.WriteHeader(errorStatus(err))
+		res.Error = "Error while revoking the API key, Try again"
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+	if !deleted {
+		w.WriteHeader(http.StatusNotFound)
+		res.Error = "API key not found"
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	res.Result = "API key revoked"
+	json.NewEncoder(w).Encode(res)
+}
+
+// bearerAPIKey returns the API key sent as the bearer token, if it is one
+func bearerAPIKey(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	if len(header) < 7 || !strings.EqualFold(header[:7], "bearer ") {
+		return "", false
+	}
+	key := strings.TrimSpace(header[7:])
+	return key, strings.HasPrefix(key, apiKeyPrefix)
+}
+
+// checkAPIKey lets the request through as the owner of key if the key's scope allows it
+func (a *Auth) checkAPIKey(w http.ResponseWriter, r *http.Request, secret string, next http.HandlerFunc) {
+	key, err := database.GetAPIKeyByHash(r.Context(), hashToken(secret))
+	var user model.User
+	if err == nil {
+		user, err = database.GetUser(r.Context(), key.UserID)
+	}
+	if err == database.ErrNotFound {
+		http.Error(w, "Invalid API key", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		logging.FromContext(r.Context()).Error("loading api key", "error", err)
+		http.Error(w, "Error while checking the API key, Try again", errorStatus(err))
+		return
+	}
+
+	logging.SetUserID(r.Context(), user.OID.Hex())
+	if !apiKeyAllows(key, r) {
+		http.Error(w, "The API key's scope doesn't allow this request", http.StatusForbidden)
+		return
+	}
+
+	now := time.Now().UTC()
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= lastUsedResolution {
+		if err := database.TouchAPIKey(r.Context(), *key.ID, now); err != nil {
+			logging.FromContext(r.Context()).Error("recording api key use", "error", err)
+		}
+	}
+
+	ctx := context.WithValue(r.Context(), userKey{}, user)
+	ctx = context.WithValue(ctx, apiKeyKey{}, key)
+	next(w, r.WithContext(ctx))
+}
+
+// apiKeyAllows tells whether the scope of key covers the request. Keys can't manage keys
+// or the account, nor get the calendar url, so a leaked key can't be used to keep access
+// after it is revoked. Exporting everything at once takes a login too.
+func apiKeyAllows(key model.APIKey, r *http.Request) bool {
+	path := r.URL.Path
+	for _, denied := range []string{"/api/keys", "/api/account", "/api/ical", "/api/export"} {
+		if underPath(path, denied) {
+			return false
+		}
+	}
+	if key.LogsOnly && !underPath(path, "/api/logs") {
+		return false
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return key.Scope == model.ScopeWrite
+}
+
+func underPath(path string, prefix string) bool {
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
@@ -0,0 +1,85 @@
+package api
+
+import (
+	"context"
+	"goplay/config"
+	"goplay/database"
+	"goplay/model"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// openDatabase makes a fresh sqlite database the store of the database package
+func openDatabase(t *testing.T) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	cfg := config.Default().Database
+	cfg.Driver = "sqlite"
+	cfg.Data = filepath.Join(t.TempDir(), "goplay.db")
+	if err := database.Open(ctx, cfg); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := database.Close(context.Background()); err != nil {
+			t.Error(err)
+		}
+	})
+	if err := database.EnsureSchema(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newAPIKey stores a key of scope for a new user and returns the key to send
+func newAPIKey(t *testing.T, scope string) string {
+	t.Helper()
+	ctx := context.Background()
+
+	userID, err := database.CreateUser(ctx, model.User{Username: "keys-" + scope, Password: "hash"})
+	if err != nil {
+		t.Fatal("creating user:", err)
+	}
+	secret := apiKeyPrefix + scope + "-secret"
+	key := model.APIKey{UserID: userID, Name: scope, Scope: scope, Prefix: secret[:apiKeyPrefixLength], KeyHash: hashToken(secret), CreatedAt: time.Now().UTC()}
+	if _, err := database.CreateAPIKey(ctx, key); err != nil {
+		t.Fatal("creating api key:", err)
+	}
+	return secret
+}
+
+func TestAPIKeyScopes(t *testing.T) {
+	openDatabase(t)
+	keys := map[string]string{
+		model.ScopeRead:  newAPIKey(t, model.ScopeRead),
+		model.ScopeWrite: newAPIKey(t, model.ScopeWrite),
+	}
+	middleware := (&Auth{}).Middleware()
+
+	for _, tt := range []struct {
+		scope, method, path string
+		want                int
+	}{
+		{model.ScopeRead, http.MethodGet, "/api/habits", http.StatusOK},
+		{model.ScopeRead, http.MethodPost, "/api/habits", http.StatusForbidden},
+		{model.ScopeWrite, http.MethodPost, "/api/habits", http.StatusOK},
+		{model.ScopeRead, http.MethodGet, "/api/keys", http.StatusForbidden},
+		{model.ScopeWrite, http.MethodPatch, "/api/account", http.StatusForbidden},
+		// The calendar url outlives the key, so getting it would keep access after revoking the key
+		{model.ScopeRead, http.MethodGet, "/api/ical/token", http.StatusForbidden},
+		{model.ScopeWrite, http.MethodPost, "/api/ical/token", http.StatusForbidden},
+		{model.ScopeRead, http.MethodGet, "/api/export/markdown", http.StatusForbidden},
+	} {
+		r := httptest.NewRequest(tt.method, tt.path, nil)
+		r.Header.Set("Authorization", "Bearer "+keys[tt.scope])
+		w := httptest.NewRecorder()
+		middleware.ServeHTTP(w, r, func(w http.ResponseWriter, r *http.Request) {})
+
+		if w.Code != tt.want {
+			t.Errorf("%s %s with a %s key answered %d, want %d", tt.method, tt.path, tt.scope, w.Code, tt.want)
+		}
+	}
+}
@@ -0,0 +1,420 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"goplay/config"
+	"goplay/database"
+	"goplay/logging"
+	"goplay/mail"
+	"goplay/metrics"
+	"goplay/model"
+	"goplay/passwords"
+	"goplay/ratelimit"
+	"goplay/tracing"
+	"goplay/validation"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	jwtmiddleware "github.com/auth0/go-jwt-middleware"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/urfave/negroni"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// tokenProperty is the request context key the jwt middleware stores the parsed token under
+const tokenProperty = "user"
+
+// userKey is the request context key the middleware stores the token owner under
+type userKey struct{}
+
+// Auth registers users, hands out tokens and checks them
+type Auth struct {
+	cfg    config.Auth
+	hasher *passwords.Hasher
+	mailer mail.Sender
+	// dummyHash is checked against when the username is unknown
+	dummyHash string
+	// failures counts the failed logins of unknown usernames
+	failures ratelimit.Store
+}
+
+// NewAuth creates the auth handlers from the config. mailer sends the password reset links,
+// failures keeps the failed logins of usernames without an account.
+func NewAuth(cfg config.Auth, mailer mail.Sender, failures ratelimit.Store) *Auth {
+	hasher := passwords.New(cfg)
+	dummyHash, _ := hasher.Hash("not a password")
+	return &Auth{cfg: cfg, hasher: hasher, mailer: mailer, dummyHash: dummyHash, failures: failures}
+}
+
+func (a *Auth) keyFunc(token *jwt.Token) (interface{}, error) {
+	return []byte(a.cfg.JWTSecret), nil
+}
+
+// Middleware is a negroni handler rejecting requests without a valid bearer token,
+// or with one issued before its owner's sessions were revoked. The bearer can also be
+// an API key, which is only let through the routes its scope allows.
+func (a *Auth) Middleware() negroni.Handler {
+	jwtMiddleware := jwtmiddleware.New(jwtmiddleware.Options{
+		Debug:               false,
+		ValidationKeyGetter: a.keyFunc,
+		UserProperty:        tokenProperty,
+		// When set, the middleware verifies that tokens are signed with the specific signing algorithm
+		// If the signing method is not constant the ValidationKeyGetter callback can be used to implement additional checks
+		// Important to avoid security issues described here: https://auth0.com/blog/2015/03/31/critical-vulnerabilities-in-json-web-token-libraries/
+		SigningMethod: jwt.SigningMethodHS256,
+	})
+
+	return negroni.HandlerFunc(func(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+		if key, ok := bearerAPIKey(r); ok {
+			a.checkAPIKey(w, r, key, next)
+			return
+		}
+		jwtMiddleware.HandlerWithNext(w, r, func(w http.ResponseWriter, r *http.Request) {
+			a.checkSession(w, r, next)
+		})
+	})
+}
+
+// checkSession loads the owner of the verified token, once for the whole request
+func (a *Auth) checkSession(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	user, ok, err := getUserFromAuthToken(r)
+	if err != nil && err != database.ErrNotFound {
+		logging.FromContext(r.Context()).Error("loading token owner", "error", err)
+		http.Error(w, "Error while checking the token, Try again", errorStatus(err))
+		return
+	}
+	if !ok || !tokenIssuedAt(r).After(user.SessionsValidAfter) {
+		http.Error(w, "Session expired, log in again", http.StatusUnauthorized)
+		return
+	}
+
+	next(w, r.WithContext(context.WithValue(r.Context(), userKey{}, user)))
+}
+
+// newToken signs a token for user. iat lets password changes revoke older tokens.
+func (a *Auth) newToken(user model.User) (string, error) {
+	// A token of the millisecond the sessions were revoked in would be revoked with them
+	if wait := time.Until(user.SessionsValidAfter.Add(time.Millisecond)); wait > 0 {
+		time.Sleep(wait)
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"username":  user.Username,
+		"firstname": user.FirstName,
+		"lastname":  user.LastName,
+		"iat":       numericDate(time.Now()),
+	})
+	return token.SignedString([]byte(a.cfg.JWTSecret))
+}
+
+// tokenIssuedAt returns the iat claim of the verified token, the Unix epoch for tokens
+// issued before it was set
+func tokenIssuedAt(r *http.Request) time.Time {
+	token, _ := r.Context().Value(tokenProperty).(*jwt.Token)
+	if token == nil {
+		return time.Unix(0, 0)
+	}
+	claims, _ := token.Claims.(jwt.MapClaims)
+	return issuedAt(claims)
+}
+
+// RegisterHandler creates a user with a hashed password
+func (a *Auth) RegisterHandler(w http.ResponseWriter, r *http.Request) {
+
+	w.Header().Set("Content-Type", "application/json")
+	var user model.User
+	var res model.ResponseResult
+	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
+		writeInvalidBody(w)
+		return
+	}
+	// The store picks the id
+	user.OID = primitive.NilObjectID
+
+	if err := validation.User(user, a.cfg.Password); err != nil {
+		writeInvalid(w, r, err)
+		return
+	}
+
+	// Reset links go to the email address, so it can only belong to one account
+	if len(user.Email) > 0 {
+		if _, err := database.GetUserByEmail(r.Context(), user.Email); err == nil {
+			writeInvalid(w, r, validation.EmailTaken)
+			return
+		}
+	}
+
+	hash, err := a.hasher.Hash(user.Password)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("hashing password", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		res.Error = "Error While Hashing Password, Try Again"
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+	user.Password = hash
+
+	// The unique indexes decide, so concurrent registrations can't both win
+	id, err := database.CreateUser(r.Context(), user)
+	if err == database.ErrEmailTaken {
+		writeInvalid(w, r, validation.EmailTaken)
+		return
+	}
+	if err == database.ErrDuplicate {
+		w.WriteHeader(http.StatusConflict)
+		res.Error = "Username already Exists!!"
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+	if err != nil {
+		logging.FromContext(r.Context()).Error("creating user", "error", err)
+		w.WriteHeader(errorStatus(err))
+		res.Error = "Error While Creating User, Try Again"
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+	metrics.UsersRegistered.Inc()
+
+	if len(user.Email) > 0 {
+		user.OID = id
+		if err := a.sendVerification(r, user); err != nil {
+			logging.FromContext(r.Context()).Error("sending verification link", "error", err)
+		}
+	}
+
+	res.Result = "Registration Successful"
+	json.NewEncoder(w).Encode(res)
+}
+
+// loginFailed is the answer to every wrong username or password, so it doesn't tell which one was wrong
+const loginFailed = "Invalid username or password"
+
+// maxFailedLoginDelay caps the delay that doubles with every failed login in a row
+const maxFailedLoginDelay = 8 * time.Second
+
+// LoginHandler exchanges a username and password for a signed token, or for a challenge
+// to complete at /login/2fa when the account uses two-factor authentication.
+// Failed logins are answered late and lock the account once there are too many in a row.
+func (a *Auth) LoginHandler(w http.ResponseWriter, r *http.Request) {
+	var res model.ResponseResult
+	w.Header().Set("Content-Type", "application/json")
+
+	var user model.User
+	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res.Error = "Invalid body"
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	result, err := database.GetUserByUsername(r.Context(), user.Username)
+	if err != nil && err != database.ErrNotFound {
+		logging.FromContext(r.Context()).Error("loading user", "error", err)
+		w.WriteHeader(errorStatus(err))
+		res.Error = "Error while logging in, Try again"
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	if err != nil {
+		// Unknown usernames are delayed and locked out like accounts, so neither tells which exist
+		if a.locked(w, r, model.User{Username: user.Username, LockedUntil: a.unknownLockedUntil(r, user.Username)}) {
+			return
+		}
+		// Take as long as a password check so the timing doesn't tell either
+		a.hasher.Verify(user.Password, a.dummyHash)
+		failures := a.failUnknownLogin(r, user.Username)
+		a.auditLogin(r, model.LoginAttempt{Username: user.Username, Reason: "unknown_user"})
+		a.failLogin(w, r, failures, loginFailed)
+		return
+	}
+
+	if a.locked(w, r, result) {
+		return
+	}
+
+	match, rehash, err := a.hasher.Verify(user.Password, result.Password)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("checking password", "error", err)
+	}
+
+	if !match {
+		// Counted even when the client hangs up, or hanging up would dodge the lockout
+		failures, err := database.RecordLoginFailure(context.WithoutCancel(r.Context()), result.OID, a.cfg.MaxFailedLogins, a.cfg.LockoutDuration)
+		if err != nil {
+			logging.FromContext(r.Context()).Error("recording failed login", "error", err)
+		}
+		a.auditLogin(r, model.LoginAttempt{UserID: result.OID, Username: result.Username, Reason: "wrong_password"})
+		a.failLogin(w, r, failures, loginFailed)
+		return
+	}
+
+	// The password is only known now, so this is when old hashes can be upgraded
+	if rehash {
+		a.rehashPassword(r, result, user.Password)
+	}
+
+	// The login only counts once the second factor is checked too
+	if len(result.TOTPSecret) > 0 {
+		challenge, err := a.newChallenge(result)
+		if err != nil {
+			res.Error = "Error while generating token,Try again"
+			json.NewEncoder(w).Encode(res)
+			return
+		}
+		json.NewEncoder(w).Encode(model.LoginChallenge{TwoFactorRequired: true, Challenge: challenge})
+		return
+	}
+
+	a.completeLogin(w, r, result)
+}
+
+// completeLogin records the successful login of user and answers with a new token
+func (a *Auth) completeLogin(w http.ResponseWriter, r *http.Request, user model.User) {
+	var res model.ResponseResult
+
+	if err := database.RecordLoginSuccess(r.Context(), user.OID); err != nil {
+		logging.FromContext(r.Context()).Error("clearing failed logins", "error", err)
+	}
+	a.auditLogin(r, model.LoginAttempt{UserID: user.OID, Username: user.Username, Success: true})
+
+	tokenString, err := a.newToken(user)
+
+	if err != nil {
+		res.Error = "Error while generating token,Try again"
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	user.Token = tokenString
+	user.Password = ""
+
+	json.NewEncoder(w).Encode(user)
+}
+
+// locked answers with a 429 when user is locked out after too many failed logins
+func (a *Auth) locked(w http.ResponseWriter, r *http.Request, user model.User) bool {
+	wait := time.Until(user.LockedUntil)
+	if wait <= 0 {
+		return false
+	}
+
+	a.auditLogin(r, model.LoginAttempt{UserID: user.OID, Username: user.Username, Reason: "locked"})
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	w.WriteHeader(http.StatusTooManyRequests)
+	json.NewEncoder(w).Encode(model.ResponseResult{Error: "Too many failed logins, try again later"})
+	return true
+}
+
+// rehashPassword replaces the stored hash of user with one using the configured algorithm
+// and parameters. It doesn't revoke sessions and failing only leaves the old hash in place.
+func (a *Auth) rehashPassword(r *http.Request, user model.User, password string) {
+	hash, err := a.hasher.Hash(password)
+	if err == nil {
+		err = database.RehashPassword(r.Context(), user.OID, user.Password, hash)
+	}
+	if err != nil {
+		logging.FromContext(r.Context()).Error("upgrading password hash", "error", err)
+	}
+}
+
+// failLogin answers a failed login with message after failedLoginDelay
+func (a *Auth) failLogin(w http.ResponseWriter, r *http.Request, failures int, message string) {
+	select {
+	case <-time.After(failedLoginDelay(a.cfg.FailedLoginDelay, failures)):
+	case <-r.Context().Done():
+		return
+	}
+
+	var res model.ResponseResult
+	res.Error = message
+	json.NewEncoder(w).Encode(res)
+}
+
+// failedLoginDelay starts at base and doubles with every failure in a row, up to maxFailedLoginDelay
+func failedLoginDelay(base time.Duration, failures int) time.Duration {
+	delay := base
+	for i := 1; i < failures && delay < maxFailedLoginDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxFailedLoginDelay {
+		delay = maxFailedLoginDelay
+	}
+	return delay
+}
+
+// auditLogin stores and logs who tried to log in from where
+func (a *Auth) auditLogin(r *http.Request, attempt model.LoginAttempt) {
+	attempt.IP = remoteIP(r)
+	attempt.UserAgent = r.UserAgent()
+	attempt.RequestID = logging.RequestID(r.Context())
+	attempt.Time = time.Now().UTC()
+
+	logger := logging.FromContext(r.Context())
+	logger.Info("login", "username", attempt.Username, "success", attempt.Success, "reason", attempt.Reason, "ip", attempt.IP)
+	// Recorded even when the client hangs up
+	if err := database.AuditLogin(context.WithoutCancel(r.Context()), attempt); err != nil {
+		logger.Error("auditing login", "error", err)
+	}
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// CurrentUser returns the user owning the request's bearer token
+func CurrentUser(r *http.Request) (model.User, bool) {
+	user, ok, _ := getUserFromAuthToken(r)
+	return user, ok
+}
+
+// TokenUsername returns the username of the token or API key the middleware already verified,
+// without loading the user. Rate limits use it to key authenticated requests.
+func TokenUsername(r *http.Request) (string, bool) {
+	if user, ok := r.Context().Value(userKey{}).(model.User); ok {
+		return user.Username, true
+	}
+
+	token, _ := r.Context().Value(tokenProperty).(*jwt.Token)
+	if token == nil || !token.Valid {
+		return "", false
+	}
+
+	claims, _ := token.Claims.(jwt.MapClaims)
+	username, _ := claims["username"].(string)
+	return username, len(username) > 0
+}
+
+// getUserFromAuthToken loads the owner of the token the middleware already verified
+func getUserFromAuthToken(r *http.Request) (model.User, bool, error) {
+	if user, ok := r.Context().Value(userKey{}).(model.User); ok {
+		return user, true, nil
+	}
+
+	var user model.User
+
+	username, ok := TokenUsername(r)
+	if !ok {
+		return user, false, errors.New("Missing or invalid token")
+	}
+
+	ctx, span := tracing.Start(r.Context(), "auth lookup")
+	defer span.End()
+
+	user, err := database.GetUserByUsername(ctx, username)
+	if err != nil {
+		tracing.Fail(span, err)
+		return user, false, err
+	}
+
+	logging.SetUserID(r.Context(), user.OID.Hex())
+	return user, true, nil
+}
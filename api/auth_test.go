@@ -0,0 +1,71 @@
+package api
+
+import (
+	"goplay/config"
+	"goplay/model"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// claimsOf returns the claims of a token signed by a, checking the signature
+func claimsOf(t *testing.T, a *Auth, signed string) jwt.MapClaims {
+	t.Helper()
+	token, err := jwt.Parse(signed, a.keyFunc)
+	if err != nil {
+		t.Fatal("parsing token:", err)
+	}
+	return token.Claims.(jwt.MapClaims)
+}
+
+func TestTokenAfterRevocationIsValid(t *testing.T) {
+	a := &Auth{cfg: config.Auth{JWTSecret: "secret"}}
+
+	// Revoked in the current millisecond, like a password change right before signing
+	revoked := time.Now().Truncate(time.Millisecond)
+	signed, err := a.newToken(model.User{SessionsValidAfter: revoked})
+	if err != nil {
+		t.Fatal("signing token:", err)
+	}
+
+	if issued := issuedAt(claimsOf(t, a, signed)); !issued.After(revoked) {
+		t.Errorf("token issued at %v, want after the revocation at %v", issued, revoked)
+	}
+}
+
+func TestTokenOfRevokedMillisecondIsRevoked(t *testing.T) {
+	a := &Auth{cfg: config.Auth{JWTSecret: "secret"}}
+
+	signed, err := a.newToken(model.User{})
+	if err != nil {
+		t.Fatal("signing token:", err)
+	}
+	issued := issuedAt(claimsOf(t, a, signed))
+
+	// A password change in the same millisecond, or the same second before tokens had milliseconds
+	for _, revoked := range []time.Time{issued, issued.Add(time.Millisecond)} {
+		if issued.After(revoked) {
+			t.Errorf("token issued at %v survives the revocation at %v", issued, revoked)
+		}
+	}
+	if time.Since(issued) > time.Minute || issued.Nanosecond()%int(time.Millisecond) != 0 {
+		t.Errorf("token issued at %v, want now to the millisecond", issued)
+	}
+}
+
+func TestIssuedAt(t *testing.T) {
+	for _, tt := range []struct {
+		iat  interface{}
+		want time.Time
+	}{
+		{nil, time.Unix(0, 0)},
+		{float64(1700000000), time.Unix(1700000000, 0)},
+		{1700000000.123, time.UnixMilli(1700000000123)},
+		{numericDate(time.UnixMilli(1700000000999)), time.UnixMilli(1700000000999)},
+	} {
+		if got := issuedAt(jwt.MapClaims{"iat": tt.iat}); !got.Equal(tt.want) {
+			t.Errorf("issuedAt(%v) = %v, want %v", tt.iat, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"goplay/database"
+	"goplay/logging"
+	"goplay/version"
+	"goplay/worker"
+	"net/http"
+	"time"
+)
+
+const readyTimeout = 2 * time.Second
+
+// Readiness is the body of /readyz
+type Readiness struct {
+	Status   string            `json:"status"`
+	Database string            `json:"database"`
+	Workers  map[string]string `json:"workers"`
+}
+
+// HealthzHandler answers as long as the process can serve requests
+func HealthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"status":"ok"}`))
+}
+
+// ReadyzHandler reports whether the primary database answers and every background worker runs
+func ReadyzHandler(workers *worker.Group) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+		defer cancel()
+
+		res := Readiness{
+			Status:   "ok",
+			Database: "ok",
+			Workers:  workers.Status(),
+		}
+		status := http.StatusOK
+
+		// /readyz is public, the error can name hosts and connection details
+		if err := database.Ping(ctx); err != nil {
+			logging.FromContext(r.Context()).Error("readiness check", "error", err)
+			res.Database = "unavailable"
+			res.Status = "unavailable"
+			status = http.StatusServiceUnavailable
+		}
+		if !workers.Healthy() {
+			res.Status = "unavailable"
+			status = http.StatusServiceUnavailable
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(res)
+	}
+}
+
+// VersionHandler returns the commit, build time and go version of the binary
+func VersionHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(version.Get())
+}
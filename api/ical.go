@@ -0,0 +1,217 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"goplay/database"
+	"goplay/logging"
+	"goplay/model"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	icalDateFormat     = "20060102"
+	icalDateTimeFormat = "20060102T150405Z"
+)
+
+// CalendarHandler serves the habits and logs of the token owner as an RFC 5545 calendar
+func CalendarHandler(w http.ResponseWriter, r *http.Request) {
+	token := mux.Vars(r)["token"]
+	if len(token) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	owner, err := database.GetUserByCalendarToken(r.Context(), token)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	habits, err := database.GetHabits(r.Context(), owner.OID)
+	var logs []*model.Log
+	if err == nil {
+		logs, err = database.GetLogs(r.Context(), owner.OID)
+	}
+	if err != nil {
+		logging.FromContext(r.Context()).Error("reading calendar", "error", err)
+		w.WriteHeader(errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", `inline; filename="goplay.ics"`)
+	writeCalendar(w, habits, logs, time.Now().UTC())
+}
+
+// GetCalendarTokenHandler returns the requester's calendar feed url, without creating one
+func GetCalendarTokenHandler(w http.ResponseWriter, r *http.Request) {
+	owner, _, _ := getUserFromAuthToken(r)
+
+	if len(owner.CalendarToken) == 0 {
+		var res model.ResponseResult
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		res.Error = "No calendar url yet, create one with POST"
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	writeCalendarURL(w, owner.CalendarToken)
+}
+
+// RotateCalendarTokenHandler creates the requester's calendar feed url, or replaces it
+// invalidating the old one
+func RotateCalendarTokenHandler(w http.ResponseWriter, r *http.Request) {
+	owner, _, _ := getUserFromAuthToken(r)
+
+	var res model.ResponseResult
+	w.Header().Set("Content-Type", "application/json")
+
+	token, err := randomToken()
+	if err == nil {
+		err = database.SetCalendarToken(r.Context(), owner.OID, token)
+	}
+
+	if err != nil {
+		logging.FromContext(r.Context()).Error("creating calendar token", "error", err)
+		w.WriteHeader(errorStatus(err))
+		res.Error = "Error while creating calendar url, Try again"
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	writeCalendarURL(w, token)
+}
+
+func writeCalendarURL(w http.ResponseWriter, token string) {
+	var res model.ResponseResult
+	res.Result = "/ical/" + token + ".ics"
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+// randomToken returns 32 random bytes in hex, for links that work without logging in
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// writeCalendar renders each log as an all-day VEVENT on the day it was written
+// and each habit as a daily recurring VTODO starting on the day it was created
+func writeCalendar(w io.Writer, habits []*model.Habit, logs []*model.Log, now time.Time) {
+	c := &icalWriter{w: w}
+	stamp := now.Format(icalDateTimeFormat)
+
+	c.line("BEGIN:VCALENDAR")
+	c.line("VERSION:2.0")
+	c.line("PRODID:-//goplay//habits//EN")
+	c.line("CALSCALE:GREGORIAN")
+	c.line("METHOD:PUBLISH")
+	c.line("X-WR-CALNAME:" + icalEscape("Habits"))
+
+	for _, habit := range habits {
+		if habit.ID == nil {
+			continue
+		}
+		created := habit.ID.Timestamp().UTC()
+
+		c.line("BEGIN:VTODO")
+		c.line("UID:habit-" + habit.ID.Hex() + "@goplay")
+		c.line("DTSTAMP:" + stamp)
+		c.line("DTSTART;VALUE=DATE:" + created.Format(icalDateFormat))
+		c.line("RRULE:FREQ=DAILY")
+		c.line("SUMMARY:" + icalEscape(habit.Name))
+		if len(habit.Description) > 0 {
+			c.line("DESCRIPTION:" + icalEscape(habit.Description))
+		}
+		c.line("STATUS:NEEDS-ACTION")
+		c.line("END:VTODO")
+	}
+
+	for _, logEntry := range logs {
+		if logEntry.ID == nil {
+			continue
+		}
+		day := logEntry.ID.Timestamp().UTC()
+
+		summary := "Journal entry"
+		if len(logEntry.Habits) > 0 {
+			summary = strings.Join(logEntry.Habits, ", ")
+		}
+
+		c.line("BEGIN:VEVENT")
+		c.line("UID:log-" + logEntry.ID.Hex() + "@goplay")
+		c.line("DTSTAMP:" + stamp)
+		c.line("DTSTART;VALUE=DATE:" + day.Format(icalDateFormat))
+		c.line("DTEND;VALUE=DATE:" + day.AddDate(0, 0, 1).Format(icalDateFormat))
+		c.line("SUMMARY:" + icalEscape(summary))
+		if len(logEntry.Entry) > 0 {
+			c.line("DESCRIPTION:" + icalEscape(logEntry.Entry))
+		}
+		if len(logEntry.Habits) > 0 {
+			c.line("CATEGORIES:" + icalEscapeList(logEntry.Habits))
+		}
+		c.line("TRANSP:TRANSPARENT")
+		c.line("END:VEVENT")
+	}
+
+	c.line("END:VCALENDAR")
+}
+
+// icalWriter writes content lines terminated by CRLF and folded at 75 octets
+type icalWriter struct {
+	w io.Writer
+}
+
+func (c *icalWriter) line(s string) {
+	limit := 75
+
+	for len(s) > limit {
+		cut := limit
+		// Never split a multi-byte UTF-8 sequence, unless the bytes aren't one and there's
+		// no start to back up to
+		for cut > 0 && s[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		if cut == 0 {
+			cut = limit
+		}
+		io.WriteString(c.w, s[:cut]+"\r\n ")
+		s = s[cut:]
+		// Continuation lines start with a space which counts towards the limit
+		limit = 74
+	}
+	io.WriteString(c.w, s+"\r\n")
+}
+
+var icalTextEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	";", `\;`,
+	",", `\,`,
+	"\r\n", `\n`,
+	"\n", `\n`,
+	"\r", `\n`,
+)
+
+func icalEscape(s string) string {
+	return icalTextEscaper.Replace(s)
+}
+
+func icalEscapeList(values []string) string {
+	escaped := make([]string, len(values))
+	for i, v := range values {
+		escaped[i] = icalEscape(v)
+	}
+	return strings.Join(escaped, ",")
+}
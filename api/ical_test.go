@@ -0,0 +1,85 @@
+package api
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func folded(s string) string {
+	var b strings.Builder
+	c := &icalWriter{w: &b}
+	c.line(s)
+	return b.String()
+}
+
+// unfold joins folded lines back the way RFC 5545 readers do
+func unfold(s string) string {
+	return strings.TrimSuffix(strings.ReplaceAll(s, "\r\n ", ""), "\r\n")
+}
+
+func TestLineFoldsAt75Octets(t *testing.T) {
+	for _, s := range []string{
+		"",
+		"SUMMARY:short",
+		strings.Repeat("a", 75),
+		strings.Repeat("a", 76),
+		"DESCRIPTION:" + strings.Repeat("é", 100),
+		"DESCRIPTION:" + strings.Repeat("日本語", 50),
+		"DESCRIPTION:" + strings.Repeat("🙂", 40),
+	} {
+		out := folded(s)
+		if !strings.HasSuffix(out, "\r\n") {
+			t.Errorf("%q doesn't end with CRLF", out)
+		}
+		if got := unfold(out); got != s {
+			t.Errorf("unfolding gave %q, want %q", got, s)
+		}
+
+		lines := strings.Split(strings.TrimSuffix(out, "\r\n"), "\r\n")
+		for i, line := range lines {
+			if len(line) > 75 {
+				t.Errorf("line %d of %q is %d octets", i, s, len(line))
+			}
+			if i > 0 && !strings.HasPrefix(line, " ") {
+				t.Errorf("continuation line %q doesn't start with a space", line)
+			}
+			if !utf8.ValidString(line) {
+				t.Errorf("line %q splits a UTF-8 sequence", line)
+			}
+		}
+	}
+}
+
+func TestLineFoldsInvalidUTF8(t *testing.T) {
+	// Continuation bytes with no start byte used to stop the fold from ever shrinking the line
+	s := "DESCRIPTION:" + strings.Repeat("\x80", 200)
+
+	out := folded(s)
+	if got := unfold(out); got != s {
+		t.Errorf("unfolding gave %q, want %q", got, s)
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(out, "\r\n"), "\r\n") {
+		if len(line) > 75 {
+			t.Errorf("line %q is %d octets", line, len(line))
+		}
+	}
+}
+
+func TestEscape(t *testing.T) {
+	for _, tt := range []struct{ in, want string }{
+		{"plain", "plain"},
+		{`back\slash`, `back\\slash`},
+		{"a;b,c", `a\;b\,c`},
+		{"one\ntwo\r\nthree\rfour", `one\ntwo\nthree\nfour`},
+		{`\n`, `\\n`},
+	} {
+		if got := icalEscape(tt.in); got != tt.want {
+			t.Errorf("icalEscape(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+
+	if got, want := icalEscapeList([]string{"run, fast", "read;books"}), `run\, fast,read\;books`; got != want {
+		t.Errorf("icalEscapeList = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,43 @@
+package api
+
+import (
+	"context"
+	"goplay/logging"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// forgetUnknownLogins is how long the failures of a username without an account are kept
+// after the last one. Accounts keep theirs until a login succeeds, the memory they'd take
+// isn't worth telling the two apart a day later.
+const forgetUnknownLogins = 24 * time.Hour
+
+// unknownLoginKey names the failed logins of a username without an account in the
+// rate limit store, ignoring case like usernames do
+func unknownLoginKey(username string) string {
+	return "login:" + strings.ToLower(username)
+}
+
+// unknownLockedUntil returns until when a username without an account is locked, the zero
+// time when it isn't. The store is shared by the replicas when it's Redis, so they all
+// answer the same like they do for accounts.
+func (a *Auth) unknownLockedUntil(r *http.Request, username string) time.Time {
+	lockedUntil, err := a.failures.LockedUntil(r.Context(), unknownLoginKey(username))
+	if err != nil {
+		logging.FromContext(r.Context()).Error("checking failed logins", "error", err)
+	}
+	return lockedUntil
+}
+
+// failUnknownLogin counts a failed login of a username without an account the way
+// database.RecordLoginFailure counts those of accounts, and returns the failures in a row
+func (a *Auth) failUnknownLogin(r *http.Request, username string) int {
+	// Counted even when the client hangs up, or hanging up would dodge the lockout
+	failures, err := a.failures.Fail(context.WithoutCancel(r.Context()), unknownLoginKey(username),
+		a.cfg.MaxFailedLogins, a.cfg.LockoutDuration, forgetUnknownLogins)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("recording failed login", "error", err)
+	}
+	return failures
+}
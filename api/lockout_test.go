@@ -0,0 +1,63 @@
+package api
+
+import (
+	"goplay/config"
+	"goplay/ratelimit"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestFailedLoginDelayDoubles(t *testing.T) {
+	for _, tt := range []struct {
+		base     time.Duration
+		failures int
+		want     time.Duration
+	}{
+		{time.Second, 0, time.Second},
+		{time.Second, 1, time.Second},
+		{time.Second, 2, 2 * time.Second},
+		{time.Second, 3, 4 * time.Second},
+		{time.Second, 4, 8 * time.Second},
+		{time.Second, 5, 8 * time.Second},
+		{time.Second, 1000, 8 * time.Second},
+		{3 * time.Second, 3, 8 * time.Second},
+		{20 * time.Second, 1, 8 * time.Second},
+		{0, 10, 0},
+	} {
+		if got := failedLoginDelay(tt.base, tt.failures); got != tt.want {
+			t.Errorf("failedLoginDelay(%s, %d) = %s, want %s", tt.base, tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestUnknownLoginLockout(t *testing.T) {
+	a := &Auth{
+		cfg:      config.Auth{MaxFailedLogins: 3, LockoutDuration: 15 * time.Minute},
+		failures: ratelimit.NewMemoryStore(),
+	}
+	r := httptest.NewRequest(http.MethodPost, "/login", nil)
+
+	for i := 1; i <= 3; i++ {
+		if locked := a.unknownLockedUntil(r, "nobody"); !locked.IsZero() {
+			t.Fatalf("locked until %v after %d failures, want not yet", locked, i-1)
+		}
+		if failures := a.failUnknownLogin(r, "nobody"); failures != i {
+			t.Errorf("failure %d counted as %d", i, failures)
+		}
+	}
+
+	// Usernames ignore case, so another spelling is the same username
+	locked := a.unknownLockedUntil(r, "NoBody")
+	if until := time.Until(locked); until < 14*time.Minute || until > 15*time.Minute {
+		t.Errorf("locked for %s, want the 15m lockout", until)
+	}
+	if locked := a.unknownLockedUntil(r, "somebody"); !locked.IsZero() {
+		t.Errorf("another username is locked until %v", locked)
+	}
+	// Counting starts over after a lockout
+	if failures := a.failUnknownLogin(r, "nobody"); failures != 1 {
+		t.Errorf("the failure after the lockout counted as %d, want 1", failures)
+	}
+}
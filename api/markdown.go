@@ -0,0 +1,185 @@
+package api
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"goplay/database"
+	"goplay/logging"
+	"goplay/model"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+)
+
+var (
+	markdown = goldmark.New(goldmark.WithExtensions(extension.GFM))
+
+	// Entries are user input, so whatever goldmark produces goes through a UGC policy
+	htmlPolicy = bluemonday.UGCPolicy()
+)
+
+// renderMarkdown converts a markdown log entry into sanitized html
+func renderMarkdown(entry string) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := markdown.Convert([]byte(entry), &buf); err != nil {
+		return nil, err
+	}
+	return htmlPolicy.SanitizeBytes(buf.Bytes()), nil
+}
+
+func writeLogHTML(w http.ResponseWriter, r *http.Request, logEntry model.Log) {
+	if logEntry.ID == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	html, err := renderMarkdown(logEntry.Entry)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("rendering log", "error", err)
+		w.WriteHeader(errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(html)
+}
+
+// ExportMarkdownHandler returns a zip of the requester's logs with one markdown file per day.
+// Days are split in the requester's time zone preference, or UTC, unless a "tz" query param
+// names an IANA time zone.
+func ExportMarkdownHandler(w http.ResponseWriter, r *http.Request) {
+	owner, _, _ := getUserFromAuthToken(r)
+
+	loc := time.UTC
+	tz := r.URL.Query().Get("tz")
+	if len(tz) == 0 {
+		tz = owner.Preferences.TimeZone
+	}
+	if len(tz) > 0 {
+		var err error
+		if loc, err = time.LoadLocation(tz); err != nil {
+			var res model.ResponseResult
+			res.Error = "Unknown time zone " + tz
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(res)
+			return
+		}
+	}
+
+	logs, err := database.GetLogs(r.Context(), owner.OID)
+	var buf bytes.Buffer
+	if err == nil {
+		err = writeMarkdownArchive(&buf, logs, loc)
+	}
+	if err != nil {
+		logging.FromContext(r.Context()).Error("exporting logs", "error", err)
+		w.WriteHeader(errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="journal.zip"`)
+	w.Write(buf.Bytes())
+}
+
+// journalDay collects the logs written on one calendar day
+type journalDay struct {
+	date   time.Time
+	habits []string
+	logs   []*model.Log
+}
+
+func writeMarkdownArchive(w io.Writer, logs []*model.Log, loc *time.Location) error {
+	days := map[string]*journalDay{}
+
+	for _, logEntry := range logs {
+		if logEntry.ID == nil {
+			continue
+		}
+		written := logEntry.ID.Timestamp().In(loc)
+		key := written.Format("2006-01-02")
+
+		day, ok := days[key]
+		if !ok {
+			day = &journalDay{date: written}
+			days[key] = day
+		}
+		day.logs = append(day.logs, logEntry)
+
+		for _, habit := range logEntry.Habits {
+			if !containsString(day.habits, habit) {
+				day.habits = append(day.habits, habit)
+			}
+		}
+	}
+
+	keys := make([]string, 0, len(days))
+	for key := range days {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	archive := zip.NewWriter(w)
+	for _, key := range keys {
+		day := days[key]
+
+		// Oldest entry first so the file reads like a diary
+		sort.Slice(day.logs, func(i, j int) bool {
+			return day.logs[i].ID.Timestamp().Before(day.logs[j].ID.Timestamp())
+		})
+
+		f, err := archive.CreateHeader(&zip.FileHeader{
+			Name:     key + ".md",
+			Method:   zip.Deflate,
+			Modified: day.date,
+		})
+		if err != nil {
+			return err
+		}
+		writeJournalDay(f, key, day, loc)
+	}
+	return archive.Close()
+}
+
+// writeJournalDay writes YAML front matter followed by the entries of the day
+func writeJournalDay(w io.Writer, key string, day *journalDay, loc *time.Location) {
+	fmt.Fprintln(w, "---")
+	fmt.Fprintf(w, "date: %s\n", key)
+	if len(day.habits) == 0 {
+		fmt.Fprintln(w, "habits: []")
+	} else {
+		fmt.Fprintln(w, "habits:")
+		for _, habit := range day.habits {
+			fmt.Fprintf(w, "  - %s\n", yamlString(habit))
+		}
+	}
+	fmt.Fprintln(w, "---")
+
+	for _, logEntry := range day.logs {
+		fmt.Fprintf(w, "\n## %s\n\n", logEntry.ID.Timestamp().In(loc).Format("15:04"))
+		fmt.Fprintln(w, logEntry.Entry)
+	}
+}
+
+// yamlString quotes s as a double-quoted YAML scalar, whose escapes are a superset of JSON's
+func yamlString(s string) string {
+	quoted, _ := json.Marshal(s)
+	return string(quoted)
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,213 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"goplay/database"
+	"goplay/logging"
+	"goplay/mail"
+	"goplay/model"
+	"goplay/validation"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// ChangePasswordHandler replaces the requester's password after checking the current one.
+// Every other session is logged out; the response carries a new token for this one.
+func (a *Auth) ChangePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var res model.ResponseResult
+	w.Header().Set("Content-Type", "application/json")
+
+	var change model.PasswordChange
+	if err := json.NewDecoder(r.Body).Decode(&change); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res.Error = "Invalid body"
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	owner, _, _ := getUserFromAuthToken(r)
+	if match, _, _ := a.hasher.Verify(change.CurrentPassword, owner.Password); !match {
+		w.WriteHeader(http.StatusForbidden)
+		res.Error = "The current password is wrong"
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	if err := validation.Password(a.cfg.Password, change.NewPassword, owner.Username); err != nil {
+		writeInvalid(w, r, err)
+		return
+	}
+
+	revoked, err := a.setPassword(r.Context(), owner, change.NewPassword)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("changing password", "error", err)
+		w.WriteHeader(errorStatus(err))
+		res.Error = "Error while changing the password, Try again"
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	// Signed after the change, so it is the only token still valid
+	owner.SessionsValidAfter = revoked
+	token, err := a.newToken(owner)
+	if err != nil {
+		res.Error = "Error while generating token,Try again"
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	owner.Token = token
+	owner.Password = ""
+	json.NewEncoder(w).Encode(owner)
+}
+
+// setPassword hashes and stores a new password, revoking the user's tokens and
+// two-factor challenges. It returns the time they are revoked up to.
+func (a *Auth) setPassword(ctx context.Context, user model.User, password string) (time.Time, error) {
+	hash, err := a.hasher.Hash(password)
+	if err != nil {
+		return time.Time{}, err
+	}
+	revoked := time.Now().Truncate(time.Millisecond)
+	return revoked, database.SetPassword(ctx, user.OID, hash, revoked)
+}
+
+// ForgotPasswordHandler emails a reset link to the account using the address, once verified.
+// It answers the same whether or not there is one, so addresses can't be probed.
+func (a *Auth) ForgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var res model.ResponseResult
+	w.Header().Set("Content-Type", "application/json")
+
+	var forgotten model.PasswordForgotten
+	if err := json.NewDecoder(r.Body).Decode(&forgotten); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res.Error = "Invalid body"
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	if len(forgotten.Email) > 0 {
+		// An unverified address may not be the owner's, it could have been set with a stolen token
+		user, err := database.GetUserByEmail(r.Context(), forgotten.Email)
+		if err == nil && user.EmailVerified {
+			err = a.sendResetLink(r, user)
+		}
+		if err != nil && err != database.ErrNotFound {
+			logging.FromContext(r.Context()).Error("sending password reset link", "error", err)
+		}
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	res.Result = "If an account uses this verified address, a reset link was sent to it"
+	json.NewEncoder(w).Encode(res)
+}
+
+func (a *Auth) sendResetLink(r *http.Request, user model.User) error {
+	token, err := randomToken()
+	if err != nil {
+		return err
+	}
+
+	err = database.CreatePasswordReset(r.Context(), model.PasswordResetToken{
+		UserID:    user.OID,
+		TokenHash: hashToken(token),
+		ExpiresAt: time.Now().Add(a.cfg.ResetTTL),
+	})
+	if err != nil {
+		return err
+	}
+
+	link, err := url.Parse(a.cfg.ResetURL)
+	if err != nil {
+		return err
+	}
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+
+	return a.mailer.Send(r.Context(), mail.Message{
+		To:      user.Email,
+		Subject: "Reset your goplay password",
+		Body: fmt.Sprintf("Hi %s,\n\n"+
+			"Someone asked to reset the password of your goplay account. "+
+			"Open this link within %s to choose a new one:\n\n%s\n\n"+
+			"If it wasn't you, ignore this email and your password stays the same.\n",
+			user.Username, a.cfg.ResetTTL, link),
+	})
+}
+
+// ResetPasswordHandler sets a new password with the token of a reset link.
+// The link works once and logs out every session of the account.
+func (a *Auth) ResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var res model.ResponseResult
+	w.Header().Set("Content-Type", "application/json")
+
+	var reset model.PasswordReset
+	if err := json.NewDecoder(r.Body).Decode(&reset); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res.Error = "Invalid body"
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	invalidLink := func() {
+		w.WriteHeader(http.StatusBadRequest)
+		res.Error = "The reset link is invalid or expired"
+		json.NewEncoder(w).Encode(res)
+	}
+
+	tokenHash := hashToken(reset.Token)
+	userID, err := database.GetPasswordReset(r.Context(), tokenHash)
+	if err == database.ErrNotFound {
+		invalidLink()
+		return
+	}
+
+	var user model.User
+	if err == nil {
+		user, err = database.GetUser(r.Context(), userID)
+	}
+	if err != nil {
+		logging.FromContext(r.Context()).Error("loading password reset", "error", err)
+		w.WriteHeader(errorStatus(err))
+		res.Error = "Error while resetting the password, Try again"
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	// Check the password before using up the link, so a rejected one can be corrected
+	if err := validation.Password(a.cfg.Password, reset.Password, user.Username); err != nil {
+		writeInvalid(w, r, err)
+		return
+	}
+
+	_, err = database.UsePasswordReset(r.Context(), tokenHash)
+	if err == database.ErrNotFound {
+		invalidLink()
+		return
+	}
+	if err == nil {
+		_, err = a.setPassword(r.Context(), user, reset.Password)
+	}
+	if err != nil {
+		logging.FromContext(r.Context()).Error("resetting password", "error", err)
+		w.WriteHeader(errorStatus(err))
+		res.Error = "Error while resetting the password, Try again"
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	res.Result = "Password changed, log in with the new password"
+	json.NewEncoder(w).Encode(res)
+}
+
+// hashToken is what is stored of a reset token, so a leaked database doesn't leak working links
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
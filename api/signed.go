@@ -0,0 +1,62 @@
+package api
+
+import (
+	"errors"
+	"goplay/model"
+	"math"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// signFor signs claims about user for one purpose, valid for ttl. Every purpose is signed
+// with its own key, so the middleware doesn't take them for tokens, nor one purpose for another.
+func (a *Auth) signFor(purpose string, user model.User, ttl time.Duration, claims jwt.MapClaims) (string, error) {
+	if claims == nil {
+		claims = jwt.MapClaims{}
+	}
+	now := time.Now()
+	claims["sub"] = user.OID.Hex()
+	claims["purpose"] = purpose
+	claims["iat"] = numericDate(now)
+	claims["exp"] = now.Add(ttl).Unix()
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(a.purposeKey(purpose))
+}
+
+// parseFor returns the user and claims of an unexpired string signed for purpose
+func (a *Auth) parseFor(purpose string, signed string) (primitive.ObjectID, jwt.MapClaims, error) {
+	token, err := jwt.Parse(signed, func(token *jwt.Token) (interface{}, error) {
+		if token.Method != jwt.SigningMethodHS256 {
+			return nil, errors.New("unexpected signing method")
+		}
+		return a.purposeKey(purpose), nil
+	})
+	if err != nil {
+		return primitive.NilObjectID, nil, err
+	}
+
+	claims, _ := token.Claims.(jwt.MapClaims)
+	if p, _ := claims["purpose"].(string); p != purpose {
+		return primitive.NilObjectID, nil, errors.New("signed for another purpose")
+	}
+	sub, _ := claims["sub"].(string)
+	userID, err := primitive.ObjectIDFromHex(sub)
+	return userID, claims, err
+}
+
+// numericDate returns t as the seconds of a JWT date, to the millisecond sessions are revoked to.
+// Older tokens have whole seconds.
+func numericDate(t time.Time) float64 {
+	return float64(t.UnixMilli()) / 1000
+}
+
+// issuedAt returns the iat claim to the millisecond, the Unix epoch when it is missing
+func issuedAt(claims jwt.MapClaims) time.Time {
+	iat, _ := claims["iat"].(float64)
+	return time.UnixMilli(int64(math.Round(iat * 1000)))
+}
+
+func (a *Auth) purposeKey(purpose string) []byte {
+	return []byte(a.cfg.JWTSecret + "/" + purpose)
+}
@@ -0,0 +1,237 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"goplay/database"
+	"goplay/logging"
+	"goplay/model"
+	"goplay/twofactor"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// challengeTTL is how long a login challenge waits for its code
+const challengeTTL = 5 * time.Minute
+
+// challengePurpose marks the claims of login challenges
+const challengePurpose = "2fa"
+
+// wrongCode is the answer to a wrong authenticator or recovery code
+const wrongCode = "Invalid code"
+
+// EnrollTwoFactorHandler starts turning on two-factor authentication. The secret it returns
+// as an otpauth URI and QR code only protects logins once confirmed with a code.
+func (a *Auth) EnrollTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	var res model.ResponseResult
+	w.Header().Set("Content-Type", "application/json")
+
+	owner, _, _ := getUserFromAuthToken(r)
+	if len(owner.TOTPSecret) > 0 {
+		w.WriteHeader(http.StatusConflict)
+		res.Error = "Two-factor authentication is already on, turn it off first"
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	enrollment, err := twofactor.Enroll(a.cfg.TOTPIssuer, owner.Username)
+	if err == nil {
+		err = database.SetPendingTOTP(r.Context(), owner.OID, enrollment.Secret)
+	}
+	if err != nil {
+		logging.FromContext(r.Context()).Error("enrolling authenticator", "error", err)
+		w.WriteHeader(errorStatus(err))
+		res.Error = "Error while enrolling the authenticator, Try again"
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	json.NewEncoder(w).Encode(model.TwoFactorEnrollment{
+		Secret: enrollment.Secret,
+		URI:    enrollment.URI,
+		QRCode: enrollment.QRCode,
+	})
+}
+
+// ConfirmTwoFactorHandler turns on two-factor authentication with a code of the enrolled
+// authenticator. It answers with the recovery codes, the only time they are shown.
+func (a *Auth) ConfirmTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	var res model.ResponseResult
+	w.Header().Set("Content-Type", "application/json")
+
+	var body model.TwoFactorCode
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res.Error = "Invalid body"
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	owner, _, _ := getUserFromAuthToken(r)
+	if len(owner.PendingTOTPSecret) == 0 {
+		w.WriteHeader(http.StatusConflict)
+		res.Error = "No authenticator is waiting for confirmation, enroll one first"
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	step, ok := twofactor.Validate(owner.PendingTOTPSecret, body.Code, time.Now())
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		res.Error = wrongCode
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	codes, hashes, err := twofactor.RecoveryCodes()
+	if err == nil {
+		ok, err = database.EnableTOTP(r.Context(), owner.OID, owner.PendingTOTPSecret, step, hashes)
+	}
+	if err != nil {
+		logging.FromContext(r.Context()).Error("enabling two-factor authentication", "error", err)
+		w.WriteHeader(errorStatus(err))
+		res.Error = "Error while turning on two-factor authentication, Try again"
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+	if !ok {
+		// Another enrollment replaced the secret in the meantime
+		w.WriteHeader(http.StatusConflict)
+		res.Error = "The authenticator was replaced by a newer enrollment"
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	json.NewEncoder(w).Encode(model.RecoveryCodes{RecoveryCodes: codes})
+}
+
+// DisableTwoFactorHandler turns off two-factor authentication with a code of the
+// authenticator or a recovery code, so a stolen token alone can't do it
+func (a *Auth) DisableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	var res model.ResponseResult
+	w.Header().Set("Content-Type", "application/json")
+
+	var body model.TwoFactorCode
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res.Error = "Invalid body"
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	owner, _, _ := getUserFromAuthToken(r)
+	if len(owner.TOTPSecret) == 0 {
+		w.WriteHeader(http.StatusConflict)
+		res.Error = "Two-factor authentication is off"
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	ok, err := useCode(r.Context(), owner, body.Code)
+	if err == nil && ok {
+		err = database.DisableTOTP(r.Context(), owner.OID)
+	}
+	if err != nil {
+		logging.FromContext(r.Context()).Error("disabling two-factor authentication", "error", err)
+		w.WriteHeader(errorStatus(err))
+		res.Error = "Error while turning off two-factor authentication, Try again"
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+	if !ok {
+		w.WriteHeader(http.StatusForbidden)
+		res.Error = wrongCode
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	res.Result = "Two-factor authentication is off"
+	json.NewEncoder(w).Encode(res)
+}
+
+// LoginTwoFactorHandler exchanges the challenge of a correct password and a code of the
+// authenticator, or a recovery code, for a token. Wrong codes count as failed logins.
+func (a *Auth) LoginTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	var res model.ResponseResult
+	w.Header().Set("Content-Type", "application/json")
+
+	var login model.TwoFactorLogin
+	if err := json.NewDecoder(r.Body).Decode(&login); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res.Error = "Invalid body"
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	invalidChallenge := func() {
+		w.WriteHeader(http.StatusUnauthorized)
+		res.Error = "The challenge is invalid or expired, log in again"
+		json.NewEncoder(w).Encode(res)
+	}
+
+	userID, issued, err := a.parseChallenge(login.Challenge)
+	if err != nil {
+		invalidChallenge()
+		return
+	}
+
+	user, err := database.GetUser(r.Context(), userID)
+	// A password change or reset since the challenge revokes it like it does tokens
+	if err == database.ErrNotFound || (err == nil && (len(user.TOTPSecret) == 0 || !issued.After(user.SessionsValidAfter))) {
+		invalidChallenge()
+		return
+	}
+	if err != nil {
+		logging.FromContext(r.Context()).Error("loading challenged user", "error", err)
+		w.WriteHeader(errorStatus(err))
+		res.Error = "Error while checking the code, Try again"
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	if a.locked(w, r, user) {
+		return
+	}
+
+	ok, err := useCode(r.Context(), user, login.Code)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("checking two-factor code", "error", err)
+		w.WriteHeader(errorStatus(err))
+		res.Error = "Error while checking the code, Try again"
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	if !ok {
+		failures, err := database.RecordLoginFailure(context.WithoutCancel(r.Context()), user.OID, a.cfg.MaxFailedLogins, a.cfg.LockoutDuration)
+		if err != nil {
+			logging.FromContext(r.Context()).Error("recording failed login", "error", err)
+		}
+		a.auditLogin(r, model.LoginAttempt{UserID: user.OID, Username: user.Username, Reason: "wrong_code"})
+		a.failLogin(w, r, failures, wrongCode)
+		return
+	}
+
+	a.completeLogin(w, r, user)
+}
+
+// newChallenge signs the proof that user got the password right
+func (a *Auth) newChallenge(user model.User) (string, error) {
+	return a.signFor(challengePurpose, user, challengeTTL, nil)
+}
+
+// parseChallenge returns whose password an unexpired challenge proves, and when it was issued
+func (a *Auth) parseChallenge(challenge string) (primitive.ObjectID, time.Time, error) {
+	userID, claims, err := a.parseFor(challengePurpose, challenge)
+	return userID, issuedAt(claims), err
+}
+
+// useCode accepts a code of user's authenticator once, or consumes one of their recovery codes
+func useCode(ctx context.Context, user model.User, code string) (bool, error) {
+	if step, ok := twofactor.Validate(user.TOTPSecret, code, time.Now()); ok {
+		return database.UseTOTPStep(ctx, user.OID, step)
+	}
+	return database.UseRecoveryCode(ctx, user.OID, twofactor.HashRecoveryCode(code))
+}
@@ -0,0 +1,120 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"goplay/config"
+	"goplay/database"
+	"goplay/model"
+	"goplay/twofactor"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pquerna/otp/totp"
+)
+
+// enrolledUser stores a user with two-factor authentication on and returns it with its recovery codes
+func enrolledUser(t *testing.T) (model.User, []string) {
+	t.Helper()
+	ctx := context.Background()
+
+	userID, err := database.CreateUser(ctx, model.User{Username: "twofactor", Password: "hash"})
+	if err != nil {
+		t.Fatal("creating user:", err)
+	}
+	enrollment, err := twofactor.Enroll("goplay", "twofactor")
+	if err != nil {
+		t.Fatal("enrolling:", err)
+	}
+	codes, hashes, err := twofactor.RecoveryCodes()
+	if err != nil {
+		t.Fatal("generating recovery codes:", err)
+	}
+	if err := database.SetPendingTOTP(ctx, userID, enrollment.Secret); err != nil {
+		t.Fatal("setting pending secret:", err)
+	}
+	// Enabled with a step long past, like a confirmation code of an earlier enrollment
+	if _, err := database.EnableTOTP(ctx, userID, enrollment.Secret, 1, hashes); err != nil {
+		t.Fatal("enabling:", err)
+	}
+
+	user, err := database.GetUser(ctx, userID)
+	if err != nil {
+		t.Fatal("getting user:", err)
+	}
+	return user, codes
+}
+
+func TestCodesAreUsedOnce(t *testing.T) {
+	openDatabase(t)
+	ctx := context.Background()
+	user, codes := enrolledUser(t)
+
+	code, err := totp.GenerateCode(user.TOTPSecret, time.Now())
+	if err != nil {
+		t.Fatal("generating code:", err)
+	}
+	recovery := strings.ToUpper(codes[0])
+
+	for _, tt := range []struct {
+		name, code string
+		want       bool
+	}{
+		{"authenticator code", code, true},
+		{"replayed authenticator code", code, false},
+		{"recovery code typed in capitals", recovery, true},
+		{"used recovery code", codes[0], false},
+		{"another recovery code", codes[1], true},
+		{"made up code", "abcde-fghjk", false},
+	} {
+		ok, err := useCode(ctx, user, tt.code)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if ok != tt.want {
+			t.Errorf("%s: useCode = %t, want %t", tt.name, ok, tt.want)
+		}
+	}
+}
+
+func TestPasswordChangeRevokesChallenges(t *testing.T) {
+	openDatabase(t)
+	ctx := context.Background()
+	user, _ := enrolledUser(t)
+	a := &Auth{cfg: config.Auth{JWTSecret: "secret", MaxFailedLogins: 5}}
+
+	login := func(challenge string) int {
+		code, err := totp.GenerateCode(user.TOTPSecret, time.Now())
+		if err != nil {
+			t.Fatal("generating code:", err)
+		}
+		body, _ := json.Marshal(model.TwoFactorLogin{Challenge: challenge, Code: code})
+		w := httptest.NewRecorder()
+		a.LoginTwoFactorHandler(w, httptest.NewRequest(http.MethodPost, "/login/2fa", strings.NewReader(string(body))))
+		return w.Code
+	}
+
+	challenge, err := a.newChallenge(user)
+	if err != nil {
+		t.Fatal("signing challenge:", err)
+	}
+	// Most likely in the millisecond the challenge was issued in, which revokes it too
+	if err := database.SetPassword(ctx, user.OID, "new hash", time.Now().Truncate(time.Millisecond)); err != nil {
+		t.Fatal("setting password:", err)
+	}
+	if code := login(challenge); code != http.StatusUnauthorized {
+		t.Errorf("a challenge of before the password change answered %d, want 401", code)
+	}
+
+	time.Sleep(2 * time.Millisecond)
+	challenge, err = a.newChallenge(user)
+	if err != nil {
+		t.Fatal("signing challenge:", err)
+	}
+	if code := login(challenge); code != http.StatusOK {
+		t.Errorf("a challenge of after the password change answered %d, want 200", code)
+	}
+}
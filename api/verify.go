@@ -0,0 +1,128 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"goplay/database"
+	"goplay/logging"
+	"goplay/mail"
+	"goplay/model"
+	"net/http"
+	"net/url"
+)
+
+// verifyPurpose marks the claims of email verification links
+const verifyPurpose = "verify_email"
+
+// VerifyEmailHandler marks an email address verified with the token of a verification link.
+// Links are signed for the address they were sent to and stop working when it changes.
+func (a *Auth) VerifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	var res model.ResponseResult
+	w.Header().Set("Content-Type", "application/json")
+
+	var verification model.EmailVerification
+	if err := json.NewDecoder(r.Body).Decode(&verification); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res.Error = "Invalid body"
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	invalidLink := func() {
+		w.WriteHeader(http.StatusBadRequest)
+		res.Error = "The verification link is invalid or expired"
+		json.NewEncoder(w).Encode(res)
+	}
+
+	userID, claims, err := a.parseFor(verifyPurpose, verification.Token)
+	if err != nil {
+		invalidLink()
+		return
+	}
+	email, _ := claims["email"].(string)
+
+	verified, err := database.VerifyEmail(r.Context(), userID, email)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("verifying email", "error", err)
+		w.WriteHeader(errorStatus(err))
+		res.Error = "Error while verifying the email, Try again"
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+	if !verified {
+		invalidLink()
+		return
+	}
+
+	res.Result = "Email verified"
+	json.NewEncoder(w).Encode(res)
+}
+
+// ResendVerificationHandler sends a new verification link to the requester's email
+func (a *Auth) ResendVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	var res model.ResponseResult
+	w.Header().Set("Content-Type", "application/json")
+
+	owner, _, _ := getUserFromAuthToken(r)
+	if len(owner.Email) == 0 || owner.EmailVerified {
+		w.WriteHeader(http.StatusConflict)
+		res.Error = "There is no unverified email to verify"
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	if err := a.sendVerification(r, owner); err != nil {
+		logging.FromContext(r.Context()).Error("sending verification link", "error", err)
+		w.WriteHeader(errorStatus(err))
+		res.Error = "Error while sending the verification link, Try again"
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	res.Result = "A verification link was sent to " + owner.Email
+	json.NewEncoder(w).Encode(res)
+}
+
+func (a *Auth) sendVerification(r *http.Request, user model.User) error {
+	token, err := a.signFor(verifyPurpose, user, a.cfg.VerifyTTL, map[string]interface{}{"email": user.Email})
+	if err != nil {
+		return err
+	}
+
+	link, err := url.Parse(a.cfg.VerifyURL)
+	if err != nil {
+		return err
+	}
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+
+	return a.mailer.Send(r.Context(), mail.Message{
+		To:      user.Email,
+		Subject: "Verify your goplay email address",
+		Body: fmt.Sprintf("Hi %s,\n\n"+
+			"Open this link within %s to confirm this is your email address:\n\n%s\n\n"+
+			"If you don't have a goplay account, ignore this email.\n",
+			user.Username, a.cfg.VerifyTTL, link),
+	})
+}
+
+// RequireVerified answers 403 to accounts without a verified email when
+// auth.restrict_unverified is on, and lets everyone through otherwise
+func (a *Auth) RequireVerified(next http.HandlerFunc) http.HandlerFunc {
+	if !a.cfg.RestrictUnverified {
+		return next
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		owner, _, _ := getUserFromAuthToken(r)
+		if !owner.EmailVerified {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(model.ResponseResult{Error: "Verify your email address first"})
+			return
+		}
+		next(w, r)
+	}
+}
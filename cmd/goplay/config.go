@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+const defaultServer = "http://localhost:5000"
+
+// config is what the cli remembers between runs
+type config struct {
+	Server   string `json:"server"`
+	Username string `json:"username,omitempty"`
+	Token    string `json:"token,omitempty"`
+}
+
+// configPath honours GOPLAY_CLI_CONFIG, otherwise the file lives in the user's config directory
+func configPath() (string, error) {
+	if path := os.Getenv("GOPLAY_CLI_CONFIG"); len(path) > 0 {
+		return path, nil
+	}
+
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "goplay", "config.json"), nil
+}
+
+func loadConfig() (config, error) {
+	cfg := config{Server: defaultServer}
+
+	path, err := configPath()
+	if err != nil {
+		return cfg, err
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, err
+	}
+
+	err = json.Unmarshal(data, &cfg)
+	if len(cfg.Server) == 0 {
+		cfg.Server = defaultServer
+	}
+	return cfg, err
+}
+
+// saveConfig writes the config readable by the current user only, since it holds the token
+func saveConfig(cfg config) error {
+	path, err := configPath()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0600)
+}
@@ -0,0 +1,369 @@
+// Command goplay logs habits from the terminal through the REST api.
+//
+//	goplay login
+//	goplay log "did 30 min run" --habit running
+//	goplay habits
+//	goplay today
+//	goplay streak running
+//
+// Every command accepts --json to print machine readable output.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"goplay/model"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/term"
+)
+
+const usage = `Usage: goplay <command> [arguments] [--json]
+
+Commands:
+  login [--server URL]           log in and save the token
+  logout                         forget the saved token
+  log ENTRY [--habit NAME ...]   write a log tagged with habits
+  habits                         list your habits
+  today                          list today's logs
+  streak HABIT                   count the consecutive days a habit was logged
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		fail(err)
+	}
+
+	command, args := os.Args[1], os.Args[2:]
+	switch command {
+	case "login":
+		err = loginCommand(cfg, args)
+	case "logout":
+		cfg.Token = ""
+		err = saveConfig(cfg)
+	case "log":
+		err = logCommand(cfg, args)
+	case "habits":
+		err = habitsCommand(cfg, args)
+	case "today":
+		err = todayCommand(cfg, args)
+	case "streak":
+		err = streakCommand(cfg, args)
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprintf(os.Stderr, "goplay: unknown command %q\n\n%s", command, usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fail(err)
+	}
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "goplay:", err)
+	os.Exit(1)
+}
+
+// stringsFlag collects a flag that may be repeated
+type stringsFlag []string
+
+func (s *stringsFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringsFlag) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// parse allows flags before, between and after the positional arguments
+func parse(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+func newFlagSet(name string) (*flag.FlagSet, *bool) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print json")
+	return fs, asJSON
+}
+
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func loginCommand(cfg config, args []string) error {
+	fs, asJSON := newFlagSet("login")
+	server := fs.String("server", cfg.Server, "api url")
+	username := fs.String("username", cfg.Username, "username")
+	if _, err := parse(fs, args); err != nil {
+		return err
+	}
+
+	in := bufio.NewReader(os.Stdin)
+	if len(*username) == 0 {
+		fmt.Fprint(os.Stderr, "Username: ")
+		line, err := in.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		*username = strings.TrimSpace(line)
+	}
+
+	password, err := readPassword(in)
+	if err != nil {
+		return err
+	}
+
+	cfg.Server = *server
+	c := newClient(cfg)
+	var login struct {
+		model.User
+		model.LoginChallenge
+	}
+	err = c.do(http.MethodPost, "/login", model.User{Username: *username, Password: password}, &login)
+	if err != nil {
+		return err
+	}
+
+	user := login.User
+	if login.TwoFactorRequired {
+		fmt.Fprint(os.Stderr, "Authenticator or recovery code: ")
+		code, err := in.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		err = c.do(http.MethodPost, "/login/2fa", model.TwoFactorLogin{Challenge: login.Challenge, Code: strings.TrimSpace(code)}, &user)
+		if err != nil {
+			return err
+		}
+	}
+
+	cfg.Username = user.Username
+	cfg.Token = user.Token
+	if err := saveConfig(cfg); err != nil {
+		return err
+	}
+
+	if *asJSON {
+		return printJSON(map[string]string{"username": user.Username, "server": cfg.Server})
+	}
+	fmt.Printf("Logged in to %s as %s\n", cfg.Server, user.Username)
+	return nil
+}
+
+// readPassword reads without echo from a terminal, or a line from piped input
+func readPassword(in *bufio.Reader) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		fmt.Fprint(os.Stderr, "Password: ")
+		password, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		return string(password), err
+	}
+
+	line, err := in.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func logCommand(cfg config, args []string) error {
+	fs, asJSON := newFlagSet("log")
+	var habits stringsFlag
+	fs.Var(&habits, "habit", "habit done, may be repeated")
+	positional, err := parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) == 0 {
+		return fmt.Errorf("missing entry, usage: goplay log ENTRY [--habit NAME ...]")
+	}
+
+	logEntry := model.Log{
+		Entry:  strings.Join(positional, " "),
+		Habits: habits,
+	}
+	var result struct {
+		InsertedID string
+	}
+	if err := newClient(cfg).do(http.MethodPost, "/api/logs", logEntry, &result); err != nil {
+		return err
+	}
+
+	if *asJSON {
+		return printJSON(map[string]interface{}{"id": result.InsertedID, "entry": logEntry.Entry, "habits": logEntry.Habits})
+	}
+	fmt.Println("Logged", result.InsertedID)
+	return nil
+}
+
+func habitsCommand(cfg config, args []string) error {
+	fs, asJSON := newFlagSet("habits")
+	if _, err := parse(fs, args); err != nil {
+		return err
+	}
+
+	var habits []model.Habit
+	if err := newClient(cfg).do(http.MethodGet, "/api/habits", nil, &habits); err != nil {
+		return err
+	}
+
+	if *asJSON {
+		return printJSON(habits)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tDESCRIPTION")
+	for _, habit := range habits {
+		fmt.Fprintf(w, "%s\t%s\n", habit.Name, habit.Description)
+	}
+	return w.Flush()
+}
+
+// getLogs returns every log, oldest first
+func getLogs(cfg config) ([]model.Log, error) {
+	var logs []model.Log
+	if err := newClient(cfg).do(http.MethodGet, "/api/logs", nil, &logs); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(logs, func(i, j int) bool {
+		return logCreated(logs[i]).Before(logCreated(logs[j]))
+	})
+	return logs, nil
+}
+
+func logCreated(logEntry model.Log) time.Time {
+	if logEntry.ID == nil {
+		return time.Time{}
+	}
+	return logEntry.ID.Timestamp().Local()
+}
+
+func day(t time.Time) string {
+	return t.Format("2006-01-02")
+}
+
+func todayCommand(cfg config, args []string) error {
+	fs, asJSON := newFlagSet("today")
+	if _, err := parse(fs, args); err != nil {
+		return err
+	}
+
+	logs, err := getLogs(cfg)
+	if err != nil {
+		return err
+	}
+
+	today := day(time.Now())
+	todays := []model.Log{}
+	for _, logEntry := range logs {
+		if day(logCreated(logEntry)) == today {
+			todays = append(todays, logEntry)
+		}
+	}
+
+	if *asJSON {
+		return printJSON(todays)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tHABITS\tENTRY")
+	for _, logEntry := range todays {
+		entry := truncate(strings.SplitN(logEntry.Entry, "\n", 2)[0], 60)
+		fmt.Fprintf(w, "%s\t%s\t%s\n", logCreated(logEntry).Format("15:04"), strings.Join(logEntry.Habits, ", "), entry)
+	}
+	return w.Flush()
+}
+
+// truncate shortens s to max characters, ending with "..." when it was longer
+func truncate(s string, max int) string {
+	runes := []rune(s)
+	if len(runes) <= max {
+		return s
+	}
+	return string(runes[:max-3]) + "..."
+}
+
+func streakCommand(cfg config, args []string) error {
+	fs, asJSON := newFlagSet("streak")
+	positional, err := parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return fmt.Errorf("usage: goplay streak HABIT")
+	}
+	habit := positional[0]
+
+	logs, err := getLogs(cfg)
+	if err != nil {
+		return err
+	}
+
+	done := map[string]bool{}
+	for _, logEntry := range logs {
+		for _, name := range logEntry.Habits {
+			if name == habit {
+				done[day(logCreated(logEntry))] = true
+			}
+		}
+	}
+
+	// Today still counts as ongoing, so a streak ending yesterday is not broken yet
+	now := time.Now()
+	doneToday := done[day(now)]
+	streak := 0
+	date := now
+	if !doneToday {
+		date = date.AddDate(0, 0, -1)
+	}
+	for done[day(date)] {
+		streak++
+		date = date.AddDate(0, 0, -1)
+	}
+
+	if *asJSON {
+		return printJSON(map[string]interface{}{"habit": habit, "days": streak, "done_today": doneToday})
+	}
+
+	unit := "days"
+	if streak == 1 {
+		unit = "day"
+	}
+	fmt.Printf("%s: %d %s", habit, streak, unit)
+	if !doneToday && streak > 0 {
+		fmt.Print(" (not done today yet)")
+	}
+	fmt.Println()
+	return nil
+}
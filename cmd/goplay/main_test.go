@@ -0,0 +1,25 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncate(t *testing.T) {
+	for _, tt := range []struct{ in, want string }{
+		{"short", "short"},
+		{strings.Repeat("a", 60), strings.Repeat("a", 60)},
+		{strings.Repeat("a", 61), strings.Repeat("a", 57) + "..."},
+		{strings.Repeat("é", 60), strings.Repeat("é", 60)},
+		{strings.Repeat("日", 61), strings.Repeat("日", 57) + "..."},
+	} {
+		got := truncate(tt.in, 60)
+		if got != tt.want {
+			t.Errorf("truncate(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+		if !utf8.ValidString(got) {
+			t.Errorf("truncate(%q) split a character", tt.in)
+		}
+	}
+}
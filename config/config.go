@@ -0,0 +1,566 @@
+// Package config loads the server settings from a YAML file, the environment and flags
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/mail"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Config holds every setting of the server
+type Config struct {
+	Server    Server    `yaml:"server"`
+	Database  Database  `yaml:"database"`
+	Auth      Auth      `yaml:"auth"`
+	CORS      CORS      `yaml:"cors"`
+	Log       Log       `yaml:"log"`
+	Tracing   Tracing   `yaml:"tracing"`
+	RateLimit RateLimit `yaml:"rate_limit"`
+	Mail      Mail      `yaml:"mail"`
+}
+
+// Server configures the http listener
+type Server struct {
+	Port         int           `yaml:"port"`
+	ReadTimeout  time.Duration `yaml:"read_timeout"`
+	WriteTimeout time.Duration `yaml:"write_timeout"`
+	// ShutdownTimeout bounds how long in-flight requests get to finish on SIGINT/SIGTERM
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+}
+
+// Database configures the storage backend
+type Database struct {
+	// Driver is the backend, mongo, postgres or sqlite
+	Driver string `yaml:"driver"`
+	// URL is the connection string, mongodb://... for mongo and postgres://... for postgres
+	URL string `yaml:"url"`
+	// Name is the mongo database. Postgres takes the database from the URL.
+	Name string `yaml:"name"`
+	// Data is the file sqlite keeps everything in, created when missing
+	Data string `yaml:"data"`
+	// ConnectTimeout bounds how long startup keeps retrying to connect
+	ConnectTimeout time.Duration `yaml:"connect_timeout"`
+	// Timeout is the deadline of each operation, unless Timeouts has one for it.
+	// Operations also stop when the client of their request goes away.
+	Timeout time.Duration `yaml:"timeout"`
+	// Timeouts are deadlines of single operations by name, e.g. get_logs
+	Timeouts map[string]time.Duration `yaml:"timeouts"`
+}
+
+// Auth configures tokens and password hashing
+type Auth struct {
+	// JWTSecret signs the HS256 tokens handed out by /login
+	JWTSecret string `yaml:"jwt_secret"`
+	// PasswordHash is the algorithm new passwords are hashed with, bcrypt or argon2id.
+	// Stored hashes using another algorithm or weaker parameters are upgraded on login.
+	PasswordHash string `yaml:"password_hash"`
+	BcryptCost   int    `yaml:"bcrypt_cost"`
+	Argon2       Argon2 `yaml:"argon2"`
+	// MaxFailedLogins in a row lock an account for LockoutDuration
+	MaxFailedLogins int           `yaml:"max_failed_logins"`
+	LockoutDuration time.Duration `yaml:"lockout_duration"`
+	// FailedLoginDelay is how long a failed login waits before answering.
+	// It doubles with every failure in a row.
+	FailedLoginDelay time.Duration  `yaml:"failed_login_delay"`
+	Password         PasswordPolicy `yaml:"password"`
+	// ResetURL is the frontend page that reads the token from ?token= and posts
+	// the new password to /password/reset. Reset emails link to it.
+	ResetURL string `yaml:"reset_url"`
+	// ResetTTL is how long a password reset link stays valid
+	ResetTTL time.Duration `yaml:"reset_ttl"`
+	// VerifyURL is the frontend page that reads the token from ?token= and posts it
+	// to /email/verify. Verification emails link to it.
+	VerifyURL string `yaml:"verify_url"`
+	// VerifyTTL is how long an email verification link stays valid
+	VerifyTTL time.Duration `yaml:"verify_ttl"`
+	// RestrictUnverified keeps accounts without a verified email from creating API keys,
+	// sharing a calendar feed and exporting their journal
+	RestrictUnverified bool `yaml:"restrict_unverified"`
+	// TOTPIssuer names the server in authenticator apps
+	TOTPIssuer string `yaml:"totp_issuer"`
+}
+
+// Argon2 configures argon2id hashing
+type Argon2 struct {
+	// Memory is in KiB
+	Memory      uint32 `yaml:"memory"`
+	Iterations  uint32 `yaml:"iterations"`
+	Parallelism uint8  `yaml:"parallelism"`
+}
+
+// PasswordPolicy configures which new passwords are accepted
+type PasswordPolicy struct {
+	MinLength     int  `yaml:"min_length"`
+	RequireUpper  bool `yaml:"require_upper"`
+	RequireLower  bool `yaml:"require_lower"`
+	RequireDigit  bool `yaml:"require_digit"`
+	RequireSymbol bool `yaml:"require_symbol"`
+	// RejectUsername refuses passwords containing the username
+	RejectUsername bool `yaml:"reject_username"`
+}
+
+// Mail configures how emails such as password resets are sent
+type Mail struct {
+	// Driver is file to write each email to Dir, for local development, or smtp
+	Driver string `yaml:"driver"`
+	From   string `yaml:"from"`
+	Dir    string `yaml:"dir"`
+	SMTP   SMTP   `yaml:"smtp"`
+}
+
+// SMTP configures the mail server used by the smtp driver
+type SMTP struct {
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+// CORS configures which browser origins may call the api
+type CORS struct {
+	AllowedOrigins []string `yaml:"allowed_origins"`
+}
+
+// Log configures the JSON logs written to stdout
+type Log struct {
+	// Level is one of debug, info, warn or error. Debug includes every database command.
+	Level string `yaml:"level"`
+}
+
+// Tracing configures where OpenTelemetry spans are exported
+type Tracing struct {
+	// Exporter is none, stdout to print spans to stderr for local debugging, or otlp
+	Exporter string `yaml:"exporter"`
+	// Endpoint is the host:port of an OTLP/HTTP collector. When empty the standard
+	// OTEL_EXPORTER_OTLP_* variables apply.
+	Endpoint string `yaml:"endpoint"`
+	// Insecure sends spans over plain http
+	Insecure bool `yaml:"insecure"`
+}
+
+// RateLimit configures the token buckets that throttle clients
+type RateLimit struct {
+	Enabled bool `yaml:"enabled"`
+	// Auth limits /login and /register per client ip
+	Auth Limit `yaml:"auth"`
+	// API limits /api and /graphql per user
+	API Limit `yaml:"api"`
+	// RedisURL keeps the buckets, and the failed logins of unknown usernames even when
+	// the limits are disabled, in a Redis compatible server shared by every replica,
+	// e.g. redis://localhost:6379/0. When empty each replica counts on its own.
+	RedisURL string `yaml:"redis_url"`
+	// TrustProxy takes the client ip from the X-Forwarded-For header set by a reverse proxy
+	TrustProxy bool `yaml:"trust_proxy"`
+}
+
+// Limit allows bursts of Requests, refilled evenly over Per
+type Limit struct {
+	Requests int           `yaml:"requests"`
+	Per      time.Duration `yaml:"per"`
+}
+
+// DefaultJWTSecret is the secret used before it was configurable.
+// Keeping it as the default keeps existing tokens valid.
+const DefaultJWTSecret = "jonapi"
+
+// Default returns the settings used when nothing overrides them
+func Default() Config {
+	return Config{
+		Server: Server{
+			Port:            5000,
+			ReadTimeout:     15 * time.Second,
+			WriteTimeout:    15 * time.Second,
+			ShutdownTimeout: 15 * time.Second,
+		},
+		Database: Database{
+			Driver:         "mongo",
+			URL:            "mongodb://localhost:27017",
+			Name:           "jonapi",
+			Data:           "goplay.db",
+			ConnectTimeout: time.Minute,
+			// Below the write timeout, so a slow query answers 504 instead of nothing
+			Timeout: 10 * time.Second,
+		},
+		Auth: Auth{
+			JWTSecret:    DefaultJWTSecret,
+			PasswordHash: "bcrypt",
+			// Hashes of the old default cost of 5 are upgraded as users log in
+			BcryptCost: 12,
+			Argon2: Argon2{
+				Memory:      64 * 1024,
+				Iterations:  3,
+				Parallelism: 2,
+			},
+			MaxFailedLogins:  5,
+			LockoutDuration:  15 * time.Minute,
+			FailedLoginDelay: 500 * time.Millisecond,
+			Password: PasswordPolicy{
+				MinLength:      8,
+				RejectUsername: true,
+			},
+			ResetURL:   "http://localhost:8080/reset-password",
+			ResetTTL:   time.Hour,
+			VerifyURL:  "http://localhost:8080/verify-email",
+			VerifyTTL:  24 * time.Hour,
+			TOTPIssuer: "goplay",
+		},
+		CORS: CORS{
+			AllowedOrigins: []string{"http://localhost:8080", "http://frontend:8080"},
+		},
+		Log: Log{
+			Level: "info",
+		},
+		Tracing: Tracing{
+			Exporter: "none",
+		},
+		RateLimit: RateLimit{
+			Enabled: true,
+			Auth:    Limit{Requests: 10, Per: time.Minute},
+			API:     Limit{Requests: 600, Per: time.Minute},
+		},
+		Mail: Mail{
+			Driver: "file",
+			From:   "goplay <noreply@localhost>",
+			Dir:    "tmp/mail",
+			SMTP: SMTP{
+				Port: 587,
+			},
+		},
+	}
+}
+
+// Load builds the config from, in increasing order of precedence, the defaults,
+// the YAML file named by -config or GOPLAY_SERVER_CONFIG, environment variables and flags.
+// args are the command line arguments without the program name.
+func Load(args []string) (Config, error) {
+	return LoadFlags(flag.NewFlagSet("goplay", flag.ContinueOnError), args)
+}
+
+// LoadFlags is Load for subcommands with flags of their own: it adds the config
+// flags to fs before parsing args with it, so fs.Args() holds the rest.
+func LoadFlags(fs *flag.FlagSet, args []string) (Config, error) {
+	cfg := Default()
+
+	path := fs.String("config", os.Getenv("GOPLAY_SERVER_CONFIG"), "path to a YAML config file")
+	port := fs.Int("port", 0, "port to listen on")
+	dbDriver := fs.String("db-driver", "", "database backend, mongo, postgres or sqlite")
+	dbURL := fs.String("db-url", "", "database connection string")
+	mongoURL := fs.String("mongo-url", "", "mongo connection string")
+	dbName := fs.String("db-name", "", "mongo database name")
+	data := fs.String("data", "", "sqlite database file; selects sqlite unless -db-driver is given")
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+
+	if len(*path) > 0 {
+		if err := loadFile(&cfg, *path); err != nil {
+			return cfg, err
+		}
+	}
+
+	if err := loadEnv(&cfg); err != nil {
+		return cfg, err
+	}
+
+	// Flags are visited in lexical order, so -db-driver overrides the sqlite -data picks
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "port":
+			cfg.Server.Port = *port
+		case "db-driver":
+			cfg.Database.Driver = *dbDriver
+		case "db-url":
+			cfg.Database.URL = *dbURL
+		case "mongo-url":
+			cfg.Database.URL = *mongoURL
+		case "db-name":
+			cfg.Database.Name = *dbName
+		case "data":
+			cfg.Database.Driver = "sqlite"
+			cfg.Database.Data = *data
+		}
+	})
+
+	return cfg, cfg.Validate()
+}
+
+func loadFile(cfg *Config, path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config: %v", err)
+	}
+	if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+		return fmt.Errorf("config: %s: %v", path, err)
+	}
+	return nil
+}
+
+// loadEnv applies the environment variables that are set
+func loadEnv(cfg *Config) error {
+	var errs []string
+
+	envInt(&errs, "SERVER_PORT", &cfg.Server.Port)
+	envDuration(&errs, "GOPLAY_READ_TIMEOUT", &cfg.Server.ReadTimeout)
+	envDuration(&errs, "GOPLAY_WRITE_TIMEOUT", &cfg.Server.WriteTimeout)
+	envDuration(&errs, "GOPLAY_SHUTDOWN_TIMEOUT", &cfg.Server.ShutdownTimeout)
+
+	// MONGO_PATH is the host of a default mongo, MONGO_URL a full connection string
+	if v, ok := lookupEnv("MONGO_PATH"); ok {
+		cfg.Database.URL = "mongodb://" + v + ":27017"
+	}
+	envString("MONGO_URL", &cfg.Database.URL)
+	envString("GOPLAY_DB_DRIVER", &cfg.Database.Driver)
+	envString("GOPLAY_DB_URL", &cfg.Database.URL)
+	envString("GOPLAY_DB_NAME", &cfg.Database.Name)
+	envString("GOPLAY_DATA", &cfg.Database.Data)
+	envDuration(&errs, "GOPLAY_DB_CONNECT_TIMEOUT", &cfg.Database.ConnectTimeout)
+	envDuration(&errs, "GOPLAY_DB_TIMEOUT", &cfg.Database.Timeout)
+
+	envString("GOPLAY_JWT_SECRET", &cfg.Auth.JWTSecret)
+	envString("GOPLAY_PASSWORD_HASH", &cfg.Auth.PasswordHash)
+	envInt(&errs, "GOPLAY_BCRYPT_COST", &cfg.Auth.BcryptCost)
+	envInt(&errs, "GOPLAY_MAX_FAILED_LOGINS", &cfg.Auth.MaxFailedLogins)
+	envDuration(&errs, "GOPLAY_LOCKOUT_DURATION", &cfg.Auth.LockoutDuration)
+	envDuration(&errs, "GOPLAY_FAILED_LOGIN_DELAY", &cfg.Auth.FailedLoginDelay)
+	envInt(&errs, "GOPLAY_PASSWORD_MIN_LENGTH", &cfg.Auth.Password.MinLength)
+	envString("GOPLAY_RESET_URL", &cfg.Auth.ResetURL)
+	envDuration(&errs, "GOPLAY_RESET_TTL", &cfg.Auth.ResetTTL)
+	envString("GOPLAY_VERIFY_URL", &cfg.Auth.VerifyURL)
+	envDuration(&errs, "GOPLAY_VERIFY_TTL", &cfg.Auth.VerifyTTL)
+	envBool(&errs, "GOPLAY_RESTRICT_UNVERIFIED", &cfg.Auth.RestrictUnverified)
+	envString("GOPLAY_TOTP_ISSUER", &cfg.Auth.TOTPIssuer)
+
+	if v, ok := lookupEnv("GOPLAY_CORS_ORIGINS"); ok {
+		cfg.CORS.AllowedOrigins = strings.Split(v, ",")
+	}
+
+	envString("GOPLAY_LOG_LEVEL", &cfg.Log.Level)
+
+	envString("GOPLAY_TRACING_EXPORTER", &cfg.Tracing.Exporter)
+	envString("GOPLAY_TRACING_ENDPOINT", &cfg.Tracing.Endpoint)
+	envBool(&errs, "GOPLAY_TRACING_INSECURE", &cfg.Tracing.Insecure)
+
+	envBool(&errs, "GOPLAY_RATE_LIMIT_ENABLED", &cfg.RateLimit.Enabled)
+	envString("GOPLAY_RATE_LIMIT_REDIS_URL", &cfg.RateLimit.RedisURL)
+	envBool(&errs, "GOPLAY_RATE_LIMIT_TRUST_PROXY", &cfg.RateLimit.TrustProxy)
+
+	envString("GOPLAY_MAIL_DRIVER", &cfg.Mail.Driver)
+	envString("GOPLAY_MAIL_FROM", &cfg.Mail.From)
+	envString("GOPLAY_MAIL_DIR", &cfg.Mail.Dir)
+	envString("GOPLAY_SMTP_HOST", &cfg.Mail.SMTP.Host)
+	envInt(&errs, "GOPLAY_SMTP_PORT", &cfg.Mail.SMTP.Port)
+	envString("GOPLAY_SMTP_USERNAME", &cfg.Mail.SMTP.Username)
+	envString("GOPLAY_SMTP_PASSWORD", &cfg.Mail.SMTP.Password)
+
+	if len(errs) > 0 {
+		return errors.New("config: " + strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func envString(key string, dst *string) {
+	if v, ok := lookupEnv(key); ok {
+		*dst = v
+	}
+}
+
+func envInt(errs *[]string, key string, dst *int) {
+	if v, ok := lookupEnv(key); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			*errs = append(*errs, key+" must be a number")
+			return
+		}
+		*dst = n
+	}
+}
+
+func envBool(errs *[]string, key string, dst *bool) {
+	if v, ok := lookupEnv(key); ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			*errs = append(*errs, key+" must be true or false")
+			return
+		}
+		*dst = b
+	}
+}
+
+func envDuration(errs *[]string, key string, dst *time.Duration) {
+	if v, ok := lookupEnv(key); ok {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			*errs = append(*errs, key+" must be a duration such as 15s")
+			return
+		}
+		*dst = d
+	}
+}
+
+// lookupEnv treats empty variables as unset, like the server always did
+func lookupEnv(key string) (string, bool) {
+	v := os.Getenv(key)
+	return v, len(v) > 0
+}
+
+// Validate reports every invalid setting at once
+func (c Config) Validate() error {
+	var errs []string
+
+	if c.Server.Port < 1 || c.Server.Port > 65535 {
+		errs = append(errs, fmt.Sprintf("server.port must be between 1 and 65535, got %d", c.Server.Port))
+	}
+	if c.Server.ReadTimeout <= 0 {
+		errs = append(errs, "server.read_timeout must be positive")
+	}
+	if c.Server.WriteTimeout <= 0 {
+		errs = append(errs, "server.write_timeout must be positive")
+	}
+	if c.Server.ShutdownTimeout <= 0 {
+		errs = append(errs, "server.shutdown_timeout must be positive")
+	}
+
+	switch c.Database.Driver {
+	case "mongo":
+		if !strings.HasPrefix(c.Database.URL, "mongodb://") && !strings.HasPrefix(c.Database.URL, "mongodb+srv://") {
+			errs = append(errs, fmt.Sprintf("database.url must start with mongodb:// or mongodb+srv://, got %q", c.Database.URL))
+		}
+		if len(c.Database.Name) == 0 {
+			errs = append(errs, "database.name is required")
+		}
+	case "postgres":
+		if !strings.HasPrefix(c.Database.URL, "postgres://") && !strings.HasPrefix(c.Database.URL, "postgresql://") {
+			errs = append(errs, fmt.Sprintf("database.url must start with postgres:// or postgresql://, got %q", c.Database.URL))
+		}
+	case "sqlite":
+		if len(c.Database.Data) == 0 {
+			errs = append(errs, "database.data is required")
+		}
+	default:
+		errs = append(errs, fmt.Sprintf("database.driver must be mongo, postgres or sqlite, got %q", c.Database.Driver))
+	}
+	if c.Database.ConnectTimeout <= 0 {
+		errs = append(errs, "database.connect_timeout must be positive")
+	}
+	if c.Database.Timeout <= 0 {
+		errs = append(errs, "database.timeout must be positive")
+	}
+	operations := make([]string, 0, len(c.Database.Timeouts))
+	for operation := range c.Database.Timeouts {
+		operations = append(operations, operation)
+	}
+	sort.Strings(operations)
+	for _, operation := range operations {
+		if c.Database.Timeouts[operation] <= 0 {
+			errs = append(errs, fmt.Sprintf("database.timeouts.%s must be positive", operation))
+		}
+	}
+
+	if len(c.Auth.JWTSecret) == 0 {
+		errs = append(errs, "auth.jwt_secret is required")
+	}
+	if c.Auth.BcryptCost < bcrypt.MinCost || c.Auth.BcryptCost > bcrypt.MaxCost {
+		errs = append(errs, fmt.Sprintf("auth.bcrypt_cost must be between %d and %d, got %d", bcrypt.MinCost, bcrypt.MaxCost, c.Auth.BcryptCost))
+	}
+
+	switch c.Auth.PasswordHash {
+	case "bcrypt", "argon2id":
+	default:
+		errs = append(errs, fmt.Sprintf("auth.password_hash must be bcrypt or argon2id, got %q", c.Auth.PasswordHash))
+	}
+	if c.Auth.Argon2.Iterations < 1 || c.Auth.Argon2.Parallelism < 1 {
+		errs = append(errs, "auth.argon2.iterations and auth.argon2.parallelism must be at least 1")
+	}
+	if c.Auth.Argon2.Memory < 8*uint32(c.Auth.Argon2.Parallelism) {
+		errs = append(errs, "auth.argon2.memory must be at least 8 KiB per thread of parallelism")
+	}
+	if c.Auth.MaxFailedLogins < 1 {
+		errs = append(errs, "auth.max_failed_logins must be at least 1")
+	}
+	if c.Auth.LockoutDuration <= 0 {
+		errs = append(errs, "auth.lockout_duration must be positive")
+	}
+	if c.Auth.FailedLoginDelay < 0 {
+		errs = append(errs, "auth.failed_login_delay can't be negative")
+	}
+	if c.Auth.Password.MinLength < 1 {
+		errs = append(errs, "auth.password.min_length must be at least 1")
+	}
+	if !strings.HasPrefix(c.Auth.ResetURL, "http://") && !strings.HasPrefix(c.Auth.ResetURL, "https://") {
+		errs = append(errs, fmt.Sprintf("auth.reset_url must be a url, got %q", c.Auth.ResetURL))
+	}
+	if c.Auth.ResetTTL <= 0 {
+		errs = append(errs, "auth.reset_ttl must be positive")
+	}
+	if !strings.HasPrefix(c.Auth.VerifyURL, "http://") && !strings.HasPrefix(c.Auth.VerifyURL, "https://") {
+		errs = append(errs, fmt.Sprintf("auth.verify_url must be a url, got %q", c.Auth.VerifyURL))
+	}
+	if c.Auth.VerifyTTL <= 0 {
+		errs = append(errs, "auth.verify_ttl must be positive")
+	}
+	if len(strings.TrimSpace(c.Auth.TOTPIssuer)) == 0 {
+		errs = append(errs, "auth.totp_issuer is required")
+	}
+
+	for _, origin := range c.CORS.AllowedOrigins {
+		if origin != "*" && !strings.HasPrefix(origin, "http://") && !strings.HasPrefix(origin, "https://") {
+			errs = append(errs, fmt.Sprintf("cors.allowed_origins must be urls or *, got %q", origin))
+		}
+	}
+
+	switch strings.ToLower(c.Log.Level) {
+	case "debug", "info", "warn", "error":
+	default:
+		errs = append(errs, fmt.Sprintf("log.level must be debug, info, warn or error, got %q", c.Log.Level))
+	}
+
+	switch c.Tracing.Exporter {
+	case "none", "stdout", "otlp":
+	default:
+		errs = append(errs, fmt.Sprintf("tracing.exporter must be none, stdout or otlp, got %q", c.Tracing.Exporter))
+	}
+
+	if c.RateLimit.Enabled {
+		if c.RateLimit.Auth.Requests <= 0 || c.RateLimit.Auth.Per <= 0 {
+			errs = append(errs, "rate_limit.auth needs positive requests and per")
+		}
+		if c.RateLimit.API.Requests <= 0 || c.RateLimit.API.Per <= 0 {
+			errs = append(errs, "rate_limit.api needs positive requests and per")
+		}
+	}
+	// Checked with the limits off too, the store also counts failed logins
+	if len(c.RateLimit.RedisURL) > 0 && !strings.HasPrefix(c.RateLimit.RedisURL, "redis://") && !strings.HasPrefix(c.RateLimit.RedisURL, "rediss://") {
+		errs = append(errs, fmt.Sprintf("rate_limit.redis_url must start with redis:// or rediss://, got %q", c.RateLimit.RedisURL))
+	}
+
+	if _, err := mail.ParseAddress(c.Mail.From); err != nil {
+		errs = append(errs, fmt.Sprintf("mail.from must be an email address, got %q", c.Mail.From))
+	}
+	switch c.Mail.Driver {
+	case "file":
+		if len(c.Mail.Dir) == 0 {
+			errs = append(errs, "mail.dir is required by the file driver")
+		}
+	case "smtp":
+		if len(c.Mail.SMTP.Host) == 0 {
+			errs = append(errs, "mail.smtp.host is required by the smtp driver")
+		}
+	default:
+		errs = append(errs, fmt.Sprintf("mail.driver must be file or smtp, got %q", c.Mail.Driver))
+	}
+
+	if len(errs) > 0 {
+		return errors.New("invalid config:\n  " + strings.Join(errs, "\n  "))
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeFile writes a config file for the test and returns its path
+func writeFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yml")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestDefaultIsValid(t *testing.T) {
+	if err := Default().Validate(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestExampleLoads(t *testing.T) {
+	// Strict, so a setting the example has and Config doesn't fails here
+	cfg, err := Load([]string{"-config", "../config.example.yml"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Database.Timeouts["get_logs"] != 30*time.Second {
+		t.Errorf("database.timeouts.get_logs = %s, want the example's 30s", cfg.Database.Timeouts["get_logs"])
+	}
+}
+
+func TestPrecedence(t *testing.T) {
+	path := writeFile(t, "server:\n  port: 6000\nauth:\n  max_failed_logins: 3\n")
+
+	cfg, err := Load([]string{"-config", path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Server.Port != 6000 || cfg.Auth.MaxFailedLogins != 3 || cfg.Auth.LockoutDuration != 15*time.Minute {
+		t.Errorf("from the file: port %d, max failed logins %d, lockout %s, want 6000, 3 and the default 15m",
+			cfg.Server.Port, cfg.Auth.MaxFailedLogins, cfg.Auth.LockoutDuration)
+	}
+
+	t.Setenv("GOPLAY_SERVER_CONFIG", path)
+	t.Setenv("SERVER_PORT", "7000")
+	t.Setenv("GOPLAY_LOCKOUT_DURATION", "1h")
+	cfg, err = Load(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Server.Port != 7000 || cfg.Auth.MaxFailedLogins != 3 || cfg.Auth.LockoutDuration != time.Hour {
+		t.Errorf("with the environment: port %d, max failed logins %d, lockout %s, want 7000, 3 and 1h",
+			cfg.Server.Port, cfg.Auth.MaxFailedLogins, cfg.Auth.LockoutDuration)
+	}
+
+	cfg, err = Load([]string{"-port", "8000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Server.Port != 8000 {
+		t.Errorf("with a flag: port %d, want 8000", cfg.Server.Port)
+	}
+}
+
+func TestDataSelectsSQLite(t *testing.T) {
+	cfg, err := Load([]string{"-data", "test.db"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Database.Driver != "sqlite" || cfg.Database.Data != "test.db" {
+		t.Errorf("-data gave driver %q and data %q", cfg.Database.Driver, cfg.Database.Data)
+	}
+
+	cfg, err = Load([]string{"-data", "test.db", "-db-driver", "postgres", "-db-url", "postgres://localhost/goplay"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Database.Driver != "postgres" {
+		t.Errorf("-db-driver with -data gave driver %q, want postgres", cfg.Database.Driver)
+	}
+}
+
+func TestInvalidSources(t *testing.T) {
+	if _, err := Load([]string{"-config", writeFile(t, "auth:\n  max_failed_login: 3\n")}); err == nil || !strings.Contains(err.Error(), "max_failed_login") {
+		t.Errorf("a misspelled setting gave %v, want it named", err)
+	}
+	if _, err := Load([]string{"-config", filepath.Join(t.TempDir(), "missing.yml")}); err == nil {
+		t.Errorf("a missing file gave no error")
+	}
+
+	t.Setenv("GOPLAY_LOCKOUT_DURATION", "15")
+	t.Setenv("GOPLAY_MAX_FAILED_LOGINS", "five")
+	_, err := Load(nil)
+	if err == nil || !strings.Contains(err.Error(), "GOPLAY_LOCKOUT_DURATION must be a duration") || !strings.Contains(err.Error(), "GOPLAY_MAX_FAILED_LOGINS must be a number") {
+		t.Errorf("invalid variables gave %v, want both reported", err)
+	}
+}
+
+func TestValidateReportsEverySetting(t *testing.T) {
+	cfg := Default()
+	cfg.Auth.BcryptCost = 3
+	cfg.Auth.MaxFailedLogins = 0
+	cfg.Auth.LockoutDuration = 0
+	cfg.Auth.FailedLoginDelay = -time.Second
+	cfg.Auth.Password.MinLength = 0
+	// The limits are only checked while they are on
+	cfg.RateLimit.Auth = Limit{Requests: 10}
+	cfg.RateLimit.API = Limit{Requests: 0, Per: time.Minute}
+	cfg.RateLimit.RedisURL = "localhost:6379"
+
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("got no error")
+	}
+	for _, want := range []string{
+		"auth.bcrypt_cost", "auth.max_failed_logins", "auth.lockout_duration", "auth.failed_login_delay",
+		"auth.password.min_length", "rate_limit.auth", "rate_limit.api", "rate_limit.redis_url",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("%q doesn't mention %s", err, want)
+		}
+	}
+
+	// The store counts failed logins with the limits off too, so its url is still checked
+	cfg.RateLimit.Enabled = false
+	err = cfg.Validate()
+	if err == nil || strings.Contains(err.Error(), "rate_limit.api") || !strings.Contains(err.Error(), "rate_limit.redis_url") {
+		t.Errorf("with the limits off got %v, want only the redis url of the rate limit settings", err)
+	}
+}
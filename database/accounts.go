@@ -0,0 +1,77 @@
+package database
+
+import (
+	"context"
+	"goplay/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// UpdateAccount applies the fields of update that are set to a user. A new email starts unverified;
+// it fails with ErrEmailTaken when another user has it.
+func (mongoStore) UpdateAccount(ctx context.Context, userID primitive.ObjectID, update model.AccountUpdate) error {
+	set := bson.D{}
+	unset := bson.D{}
+	if update.FirstName != nil {
+		set = append(set, bson.E{"firstname", *update.FirstName})
+	}
+	if update.LastName != nil {
+		set = append(set, bson.E{"lastname", *update.LastName})
+	}
+	if update.Email != nil {
+		if len(*update.Email) > 0 {
+			set = append(set, bson.E{"email", *update.Email})
+		} else {
+			unset = append(unset, bson.E{"email", ""})
+		}
+		unset = append(unset, bson.E{"email_verified", ""})
+	}
+	if update.Preferences != nil {
+		set = append(set, bson.E{"preferences", *update.Preferences})
+	}
+
+	changes := bson.D{}
+	if len(set) > 0 {
+		changes = append(changes, bson.E{"$set", set})
+	}
+	if len(unset) > 0 {
+		changes = append(changes, bson.E{"$unset", unset})
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+
+	_, err := Users.UpdateOne(ctx, bson.D{{"_id", userID}}, changes)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrEmailTaken
+	}
+	return err
+}
+
+// VerifyEmail marks the email of a user verified, unless it changed from email.
+// It reports false when it did.
+func (mongoStore) VerifyEmail(ctx context.Context, userID primitive.ObjectID, email string) (bool, error) {
+	filter := bson.D{{"_id", userID}, {"email", email}}
+	update := bson.D{{"$set", bson.D{{"email_verified", true}}}}
+	result, err := Users.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount > 0, nil
+}
+
+// DeleteAccount removes a user with everything they own. The user goes last,
+// so an account that failed to delete halfway can be deleted again. The login audit is kept.
+func (mongoStore) DeleteAccount(ctx context.Context, userID primitive.ObjectID) error {
+	owned := bson.D{{"user_id", userID}}
+	for _, collection := range []*mongo.Collection{Logs, Habits, Identities, APIKeys, PasswordResets} {
+		if _, err := collection.DeleteMany(ctx, owned); err != nil {
+			return err
+		}
+	}
+
+	_, err := Users.DeleteOne(ctx, bson.D{{"_id", userID}})
+	return err
+}
@@ -0,0 +1,46 @@
+package database
+
+import (
+	"context"
+	"goplay/model"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CreateAPIKey stores a new API key and returns its id
+func (mongoStore) CreateAPIKey(ctx context.Context, key model.APIKey) (primitive.ObjectID, error) {
+	result, err := APIKeys.InsertOne(ctx, key)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	return result.InsertedID.(primitive.ObjectID), nil
+}
+
+// GetAPIKeys lists the API keys of a user, newest first
+func (mongoStore) GetAPIKeys(ctx context.Context, ownerID primitive.ObjectID) ([]*model.APIKey, error) {
+	keys := []*model.APIKey{}
+	opts := options.Find().SetSort(bson.D{{"created_at", -1}})
+	cur, err := APIKeys.Find(ctx, bson.D{{"user_id", ownerID}}, opts)
+	if err != nil {
+		return keys, err
+	}
+	err = cur.All(ctx, &keys)
+	return keys, err
+}
+
+// GetAPIKeyByHash finds the API key with the hash
+func (mongoStore) GetAPIKeyByHash(ctx context.Context, keyHash string) (model.APIKey, error) {
+	var key model.APIKey
+	err := APIKeys.FindOne(ctx, bson.D{{"key_hash", keyHash}}).Decode(&key)
+	return key, err
+}
+
+// TouchAPIKey records that an API key was used at t
+func (mongoStore) TouchAPIKey(ctx context.Context, id primitive.ObjectID, t time.Time) error {
+	update := bson.D{{"$set", bson.D{{"last_used_at", t}}}}
+	_, err := APIKeys.UpdateOne(ctx, bson.D{{"_id", id}}, update)
+	return err
+}
@@ -0,0 +1,758 @@
+// Package conformance checks that a storage backend behaves the way the api expects, so every
+// backend answers the same. The tests of each backend run it against a live database; it
+// creates users of its own and deletes them afterwards, still point it at a scratch database.
+package conformance
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"goplay/database"
+	"goplay/model"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// backend is the store under test and the context to call it with
+type backend struct {
+	ctx   context.Context
+	store database.Store
+}
+
+// checks are every behavior a backend has to have
+var checks = []struct {
+	name string
+	run  func(t *testing.T, b backend)
+}{
+	{"users", checkUsers},
+	{"account", checkAccount},
+	{"emails", checkEmails},
+	{"passwords", checkPasswords},
+	{"logins", checkLogins},
+	{"two factor", checkTwoFactor},
+	{"api keys", checkAPIKeys},
+	{"identities", checkIdentities},
+	{"habits", checkHabits},
+	{"habit names", checkHabitNames},
+	{"logs", checkLogs},
+	{"delete account", checkDeleteAccount},
+}
+
+// Run runs every check against store, each as a subtest of t
+func Run(t *testing.T, store database.Store) {
+	for _, check := range checks {
+		t.Run(check.name, func(t *testing.T) {
+			check.run(t, backend{ctx: context.Background(), store: store})
+		})
+	}
+}
+
+// ok stops the check when err isn't nil
+func ok(t *testing.T, err error, doing string) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("%s: %v", doing, err)
+	}
+}
+
+// newUser creates a user with a unique name, deleted when the check ends
+func newUser(t *testing.T, b backend) model.User {
+	t.Helper()
+	suffix := make([]byte, 6)
+	rand.Read(suffix)
+	user := model.User{
+		Username:  "conformance-" + hex.EncodeToString(suffix),
+		FirstName: "Con",
+		LastName:  "Formance",
+		Password:  "hash",
+	}
+
+	id, err := b.store.CreateUser(b.ctx, user)
+	ok(t, err, "creating user")
+	t.Cleanup(func() {
+		if err := b.store.DeleteAccount(b.ctx, id); err != nil {
+			t.Errorf("cleaning up user %s: %v", id.Hex(), err)
+		}
+	})
+	user.OID = id
+	return user
+}
+
+func checkUsers(t *testing.T, b backend) {
+	user := newUser(t, b)
+
+	got, err := b.store.GetUser(b.ctx, user.OID)
+	ok(t, err, "getting user")
+	if got.Username != user.Username || got.FirstName != "Con" || got.Password != "hash" {
+		t.Errorf("GetUser = %+v, want %+v", got, user)
+	}
+
+	got, err = b.store.GetUserByUsername(b.ctx, strings.ToUpper(user.Username))
+	ok(t, err, "getting user by username")
+	if got.OID != user.OID {
+		t.Errorf("GetUserByUsername ignoring case found %s, want %s", got.OID.Hex(), user.OID.Hex())
+	}
+
+	_, err = b.store.CreateUser(b.ctx, model.User{Username: strings.ToUpper(user.Username), Password: "hash"})
+	if err != database.ErrDuplicate {
+		t.Errorf("CreateUser with a taken username ignoring case = %v, want ErrDuplicate", err)
+	}
+
+	if _, err := b.store.GetUser(b.ctx, primitive.NewObjectID()); err != database.ErrNotFound {
+		t.Errorf("GetUser of a missing user = %v, want ErrNotFound", err)
+	}
+	if _, err := b.store.GetUserByEmail(b.ctx, user.Username+"@example.com"); err != database.ErrNotFound {
+		t.Errorf("GetUserByEmail of an unused address = %v, want ErrNotFound", err)
+	}
+
+	token := user.Username + "-calendar"
+	ok(t, b.store.SetCalendarToken(b.ctx, user.OID, token), "setting calendar token")
+	got, err = b.store.GetUserByCalendarToken(b.ctx, token)
+	ok(t, err, "getting user by calendar token")
+	if got.OID != user.OID || got.CalendarToken != token {
+		t.Errorf("GetUserByCalendarToken = %s with token %q, want %s", got.OID.Hex(), got.CalendarToken, user.OID.Hex())
+	}
+}
+
+func checkAccount(t *testing.T, b backend) {
+	user := newUser(t, b)
+	email := user.Username + "@example.com"
+	first := "New"
+	zone := "Europe/Amsterdam"
+
+	ok(t, b.store.UpdateAccount(b.ctx, user.OID, model.AccountUpdate{
+		FirstName:   &first,
+		Email:       &email,
+		Preferences: &model.Preferences{TimeZone: zone},
+	}), "updating account")
+
+	got, err := b.store.GetUserByEmail(b.ctx, email)
+	ok(t, err, "getting user by email")
+	if got.OID != user.OID || got.FirstName != first || got.LastName != "Formance" || got.Preferences.TimeZone != zone || got.EmailVerified {
+		t.Errorf("after UpdateAccount the user is %+v", got)
+	}
+
+	verified, err := b.store.VerifyEmail(b.ctx, user.OID, "old-"+email)
+	ok(t, err, "verifying another email")
+	if verified {
+		t.Errorf("VerifyEmail of an address the user no longer has = true")
+	}
+	verified, err = b.store.VerifyEmail(b.ctx, user.OID, email)
+	ok(t, err, "verifying email")
+	got, err = b.store.GetUser(b.ctx, user.OID)
+	ok(t, err, "getting user")
+	if !verified || !got.EmailVerified {
+		t.Errorf("VerifyEmail = %v and the user is verified: %v, want both true", verified, got.EmailVerified)
+	}
+
+	// A new address starts unverified, no address at all is no address
+	other := "other-" + email
+	ok(t, b.store.UpdateAccount(b.ctx, user.OID, model.AccountUpdate{Email: &other}), "changing email")
+	got, err = b.store.GetUser(b.ctx, user.OID)
+	ok(t, err, "getting user")
+	if got.Email != other || got.EmailVerified {
+		t.Errorf("after changing the email the user has %q, verified %v", got.Email, got.EmailVerified)
+	}
+
+	none := ""
+	ok(t, b.store.UpdateAccount(b.ctx, user.OID, model.AccountUpdate{Email: &none}), "removing email")
+	got, err = b.store.GetUser(b.ctx, user.OID)
+	ok(t, err, "getting user")
+	if got.Email != "" {
+		t.Errorf("after removing the email the user has %q", got.Email)
+	}
+	if _, err := b.store.GetUserByEmail(b.ctx, ""); err != database.ErrNotFound {
+		t.Errorf("GetUserByEmail of no address = %v, want ErrNotFound", err)
+	}
+
+	ok(t, b.store.UpdateAccount(b.ctx, user.OID, model.AccountUpdate{}), "updating nothing")
+}
+
+func checkHabitNames(t *testing.T, b backend) {
+	user := newUser(t, b)
+	other := newUser(t, b)
+
+	_, err := b.store.CreateHabit(b.ctx, model.Habit{UserID: user.OID, Name: "run"})
+	ok(t, err, "creating habit")
+	readID, err := b.store.CreateHabit(b.ctx, model.Habit{UserID: user.OID, Name: "read"})
+	ok(t, err, "creating habit")
+
+	if _, err := b.store.CreateHabit(b.ctx, model.Habit{UserID: user.OID, Name: "run"}); err != database.ErrHabitNameTaken {
+		t.Errorf("CreateHabit with a taken name = %v, want ErrHabitNameTaken", err)
+	}
+	if _, err := b.store.UpdateHabit(b.ctx, readID, user.OID, database.HabitUpdate{Name: "run"}); err != database.ErrHabitNameTaken {
+		t.Errorf("UpdateHabit to a taken name = %v, want ErrHabitNameTaken", err)
+	}
+	habit, err := b.store.GetHabit(b.ctx, readID, user.OID)
+	ok(t, err, "getting habit")
+	if habit.Name != "read" {
+		t.Errorf("a failed rename left the name %q", habit.Name)
+	}
+
+	// Names are only unique per owner, and keeping its own name isn't taking it
+	_, err = b.store.CreateHabit(b.ctx, model.Habit{UserID: other.OID, Name: "run"})
+	ok(t, err, "creating another user's habit with the same name")
+	_, err = b.store.UpdateHabit(b.ctx, readID, user.OID, database.HabitUpdate{Name: "read"})
+	ok(t, err, "updating a habit keeping its name")
+}
+
+func checkEmails(t *testing.T, b backend) {
+	user := newUser(t, b)
+	other := newUser(t, b)
+	email := user.Username + "@example.com"
+	taken := strings.ToUpper(email)
+	ok(t, b.store.UpdateAccount(b.ctx, user.OID, model.AccountUpdate{Email: &email}), "setting email")
+
+	got, err := b.store.GetUserByEmail(b.ctx, taken)
+	ok(t, err, "getting user by email ignoring case")
+	if got.OID != user.OID {
+		t.Errorf("GetUserByEmail ignoring case found %s, want %s", got.OID.Hex(), user.OID.Hex())
+	}
+
+	if err := b.store.UpdateAccount(b.ctx, other.OID, model.AccountUpdate{Email: &taken}); err != database.ErrEmailTaken {
+		t.Errorf("UpdateAccount to a taken email ignoring case = %v, want ErrEmailTaken", err)
+	}
+	id, err := b.store.CreateUser(b.ctx, model.User{Username: other.Username + "-email", Email: taken, Password: "hash"})
+	if err == nil {
+		b.store.DeleteAccount(b.ctx, id)
+	}
+	if err != database.ErrEmailTaken {
+		t.Errorf("CreateUser with a taken email ignoring case = %v, want ErrEmailTaken", err)
+	}
+
+	// Neither other nor a third user has an email, which doesn't make them collide
+	newUser(t, b)
+}
+
+func checkPasswords(t *testing.T, b backend) {
+	user := newUser(t, b)
+
+	_, err := b.store.RecordLoginFailure(b.ctx, user.OID, 1, time.Hour)
+	ok(t, err, "locking user")
+	ok(t, b.store.RehashPassword(b.ctx, user.OID, "stale", "rehashed"), "rehashing a stale password")
+	// Sessions are revoked to the millisecond, which every backend keeps
+	revoked := time.Now().Truncate(time.Millisecond)
+	ok(t, b.store.SetPassword(b.ctx, user.OID, "new", revoked), "setting password")
+
+	got, err := b.store.GetUser(b.ctx, user.OID)
+	ok(t, err, "getting user")
+	if got.Password != "new" || got.SessionsValidAfter.IsZero() || !got.LockedUntil.IsZero() || got.FailedLogins != 0 {
+		t.Errorf("after SetPassword the user has password %q, sessions valid after %v, locked until %v, %d failures",
+			got.Password, got.SessionsValidAfter, got.LockedUntil, got.FailedLogins)
+	}
+	if !got.SessionsValidAfter.Equal(revoked) {
+		t.Errorf("sessions valid after %v, want %v", got.SessionsValidAfter, revoked)
+	}
+
+	ok(t, b.store.RehashPassword(b.ctx, user.OID, "new", "rehashed"), "rehashing password")
+	got, err = b.store.GetUser(b.ctx, user.OID)
+	ok(t, err, "getting user")
+	if got.Password != "rehashed" {
+		t.Errorf("after RehashPassword the password is %q, want rehashed", got.Password)
+	}
+
+	hash := user.Username + "-reset"
+	ok(t, b.store.CreatePasswordReset(b.ctx, model.PasswordResetToken{UserID: user.OID, TokenHash: "first-" + hash, ExpiresAt: time.Now().Add(time.Hour)}), "creating reset")
+	ok(t, b.store.CreatePasswordReset(b.ctx, model.PasswordResetToken{UserID: user.OID, TokenHash: hash, ExpiresAt: time.Now().Add(time.Hour)}), "creating reset")
+	if _, err := b.store.GetPasswordReset(b.ctx, "first-"+hash); err != database.ErrNotFound {
+		t.Errorf("GetPasswordReset of a replaced link = %v, want ErrNotFound", err)
+	}
+
+	owner, err := b.store.GetPasswordReset(b.ctx, hash)
+	ok(t, err, "getting reset")
+	if owner != user.OID {
+		t.Errorf("GetPasswordReset = %s, want %s", owner.Hex(), user.OID.Hex())
+	}
+	owner, err = b.store.UsePasswordReset(b.ctx, hash)
+	ok(t, err, "using reset")
+	if owner != user.OID {
+		t.Errorf("UsePasswordReset = %s, want %s", owner.Hex(), user.OID.Hex())
+	}
+	if _, err := b.store.UsePasswordReset(b.ctx, hash); err != database.ErrNotFound {
+		t.Errorf("using a reset link twice = %v, want ErrNotFound", err)
+	}
+
+	ok(t, b.store.CreatePasswordReset(b.ctx, model.PasswordResetToken{UserID: user.OID, TokenHash: hash, ExpiresAt: time.Now().Add(-time.Minute)}), "creating expired reset")
+	if _, err := b.store.GetPasswordReset(b.ctx, hash); err != database.ErrNotFound {
+		t.Errorf("GetPasswordReset of an expired link = %v, want ErrNotFound", err)
+	}
+}
+
+func checkLogins(t *testing.T, b backend) {
+	user := newUser(t, b)
+
+	for want := 1; want <= 2; want++ {
+		failures, err := b.store.RecordLoginFailure(b.ctx, user.OID, 3, time.Hour)
+		ok(t, err, "recording failure")
+		if failures != want {
+			t.Errorf("failure %d is counted as %d", want, failures)
+		}
+	}
+	got, err := b.store.GetUser(b.ctx, user.OID)
+	ok(t, err, "getting user")
+	if got.FailedLogins != 2 || !got.LockedUntil.IsZero() {
+		t.Errorf("after 2 failures the user has %d, locked until %v", got.FailedLogins, got.LockedUntil)
+	}
+
+	failures, err := b.store.RecordLoginFailure(b.ctx, user.OID, 3, time.Hour)
+	ok(t, err, "recording failure")
+	got, err = b.store.GetUser(b.ctx, user.OID)
+	ok(t, err, "getting user")
+	if failures != 3 || got.FailedLogins != 0 || time.Until(got.LockedUntil) < 59*time.Minute {
+		t.Errorf("the failure reaching the threshold is counted as %d and leaves %d, locked until %v", failures, got.FailedLogins, got.LockedUntil)
+	}
+
+	_, err = b.store.RecordLoginFailure(b.ctx, user.OID, 3, time.Hour)
+	ok(t, err, "recording failure")
+	ok(t, b.store.RecordLoginSuccess(b.ctx, user.OID), "recording success")
+	got, err = b.store.GetUser(b.ctx, user.OID)
+	ok(t, err, "getting user")
+	if got.FailedLogins != 0 || !got.LockedUntil.IsZero() {
+		t.Errorf("after a success the user has %d failures, locked until %v", got.FailedLogins, got.LockedUntil)
+	}
+
+	ok(t, b.store.AuditLogin(b.ctx, model.LoginAttempt{UserID: user.OID, Username: user.Username, Success: true, IP: "127.0.0.1", Time: time.Now()}), "auditing login")
+	ok(t, b.store.AuditLogin(b.ctx, model.LoginAttempt{Username: "nobody", Reason: "unknown_user", IP: "127.0.0.1", Time: time.Now()}), "auditing unknown user")
+}
+
+func checkTwoFactor(t *testing.T, b backend) {
+	user := newUser(t, b)
+
+	ok(t, b.store.SetPendingTOTP(b.ctx, user.OID, "first"), "setting pending secret")
+	ok(t, b.store.SetPendingTOTP(b.ctx, user.OID, "second"), "replacing pending secret")
+
+	enabled, err := b.store.EnableTOTP(b.ctx, user.OID, "first", 10, []string{"a", "b"})
+	ok(t, err, "enabling a replaced secret")
+	if enabled {
+		t.Errorf("EnableTOTP with a replaced secret = true")
+	}
+	enabled, err = b.store.EnableTOTP(b.ctx, user.OID, "second", 10, []string{"a", "b"})
+	ok(t, err, "enabling")
+	got, err := b.store.GetUser(b.ctx, user.OID)
+	ok(t, err, "getting user")
+	if !enabled || got.TOTPSecret != "second" || got.PendingTOTPSecret != "" || got.TOTPLastStep != 10 {
+		t.Errorf("EnableTOTP = %v and the user has secret %q, pending %q, last step %d", enabled, got.TOTPSecret, got.PendingTOTPSecret, got.TOTPLastStep)
+	}
+	enabled, err = b.store.EnableTOTP(b.ctx, user.OID, "second", 10, []string{"c"})
+	ok(t, err, "enabling again")
+	if enabled {
+		t.Errorf("EnableTOTP without a pending secret = true")
+	}
+
+	for _, step := range []struct {
+		step int64
+		want bool
+	}{{10, false}, {9, false}, {11, true}, {11, false}} {
+		used, err := b.store.UseTOTPStep(b.ctx, user.OID, step.step)
+		ok(t, err, "using step")
+		if used != step.want {
+			t.Errorf("UseTOTPStep(%d) = %v, want %v", step.step, used, step.want)
+		}
+	}
+
+	for _, code := range []struct {
+		hash string
+		want bool
+	}{{"a", true}, {"a", false}, {"c", false}, {"b", true}} {
+		used, err := b.store.UseRecoveryCode(b.ctx, user.OID, code.hash)
+		ok(t, err, "using recovery code")
+		if used != code.want {
+			t.Errorf("UseRecoveryCode(%q) = %v, want %v", code.hash, used, code.want)
+		}
+	}
+
+	ok(t, b.store.SetPendingTOTP(b.ctx, user.OID, "third"), "setting pending secret")
+	enabled, err = b.store.EnableTOTP(b.ctx, user.OID, "third", 20, []string{"d"})
+	ok(t, err, "enabling")
+	ok(t, b.store.DisableTOTP(b.ctx, user.OID), "disabling")
+	got, err = b.store.GetUser(b.ctx, user.OID)
+	ok(t, err, "getting user")
+	if got.TOTPSecret != "" || got.PendingTOTPSecret != "" {
+		t.Errorf("after DisableTOTP the user has secret %q, pending %q", got.TOTPSecret, got.PendingTOTPSecret)
+	}
+	if used, _ := b.store.UseRecoveryCode(b.ctx, user.OID, "d"); used {
+		t.Errorf("a recovery code was left after DisableTOTP")
+	}
+	if used, _ := b.store.UseTOTPStep(b.ctx, user.OID, 1); !used {
+		t.Errorf("the last step was left after DisableTOTP")
+	}
+}
+
+func checkAPIKeys(t *testing.T, b backend) {
+	user := newUser(t, b)
+	other := newUser(t, b)
+
+	older := model.APIKey{UserID: user.OID, Name: "older", Scope: model.ScopeRead, Prefix: "gp_old", KeyHash: user.Username + "-older", CreatedAt: time.Now().Add(-time.Hour).Truncate(time.Millisecond)}
+	newer := model.APIKey{UserID: user.OID, Name: "newer", Scope: model.ScopeWrite, LogsOnly: true, Prefix: "gp_new", KeyHash: user.Username + "-newer", CreatedAt: time.Now().Truncate(time.Millisecond)}
+	olderID, err := b.store.CreateAPIKey(b.ctx, older)
+	ok(t, err, "creating key")
+	newerID, err := b.store.CreateAPIKey(b.ctx, newer)
+	ok(t, err, "creating key")
+
+	keys, err := b.store.GetAPIKeys(b.ctx, user.OID)
+	ok(t, err, "listing keys")
+	if len(keys) != 2 || *keys[0].ID != newerID || *keys[1].ID != olderID {
+		t.Fatalf("GetAPIKeys returned %d keys, want the newer then the older", len(keys))
+	}
+	if keys[0].Name != "newer" || keys[0].Scope != model.ScopeWrite || !keys[0].LogsOnly || keys[0].Prefix != "gp_new" ||
+		!keys[0].CreatedAt.Equal(newer.CreatedAt) || keys[0].LastUsedAt != nil {
+		t.Errorf("GetAPIKeys returned %+v for %+v", keys[0], newer)
+	}
+	if keys, _ := b.store.GetAPIKeys(b.ctx, other.OID); len(keys) != 0 {
+		t.Errorf("another user has %d keys, want 0", len(keys))
+	}
+
+	used := time.Now().Truncate(time.Millisecond)
+	ok(t, b.store.TouchAPIKey(b.ctx, olderID, used), "touching key")
+	key, err := b.store.GetAPIKeyByHash(b.ctx, older.KeyHash)
+	ok(t, err, "getting key by hash")
+	if *key.ID != olderID || key.UserID != user.OID || key.LastUsedAt == nil || !key.LastUsedAt.Equal(used) {
+		t.Errorf("GetAPIKeyByHash = %+v, want %s used at %v", key, olderID.Hex(), used)
+	}
+	if _, err := b.store.GetAPIKeyByHash(b.ctx, "missing"); err != database.ErrNotFound {
+		t.Errorf("GetAPIKeyByHash of a missing key = %v, want ErrNotFound", err)
+	}
+
+	deleted, err := b.store.DeleteAPIKey(b.ctx, olderID, other.OID)
+	ok(t, err, "deleting another user's key")
+	if deleted {
+		t.Errorf("DeleteAPIKey of another user's key = true")
+	}
+	deleted, err = b.store.DeleteAPIKey(b.ctx, olderID, user.OID)
+	ok(t, err, "deleting key")
+	if !deleted {
+		t.Errorf("DeleteAPIKey = false")
+	}
+	if _, err := b.store.GetAPIKeyByHash(b.ctx, older.KeyHash); err != database.ErrNotFound {
+		t.Errorf("GetAPIKeyByHash of a deleted key = %v, want ErrNotFound", err)
+	}
+}
+
+func checkIdentities(t *testing.T, b backend) {
+	user := newUser(t, b)
+	other := newUser(t, b)
+
+	athleteID, err := b.store.CreateIdentity(b.ctx, model.Identity{UserID: user.OID, Name: "athlete", Description: "moves"})
+	ok(t, err, "creating identity")
+	writerID, err := b.store.CreateIdentity(b.ctx, model.Identity{UserID: user.OID, Name: "writer"})
+	ok(t, err, "creating identity")
+	_, err = b.store.CreateIdentity(b.ctx, model.Identity{UserID: other.OID, Name: "athlete"})
+	ok(t, err, "creating identity")
+
+	identity, err := b.store.GetIdentity(b.ctx, athleteID, user.OID)
+	ok(t, err, "getting identity")
+	if identity.Name != "athlete" || identity.Description != "moves" || identity.UserID != user.OID {
+		t.Errorf("GetIdentity = %+v", identity)
+	}
+	if _, err := b.store.GetIdentity(b.ctx, athleteID, other.OID); err != database.ErrNotFound {
+		t.Errorf("GetIdentity of another user's identity = %v, want ErrNotFound", err)
+	}
+
+	identities, err := b.store.GetIdentities(b.ctx, user.OID)
+	ok(t, err, "listing identities")
+	if len(identities) != 2 || *identities[0].ID != athleteID || *identities[1].ID != writerID {
+		t.Errorf("GetIdentities returned %d identities, want athlete then writer", len(identities))
+	}
+
+	identities, err = b.store.FindIdentities(b.ctx, user.OID, database.IdentityFilter{IDs: []primitive.ObjectID{writerID, primitive.NewObjectID()}})
+	ok(t, err, "finding identities")
+	if len(identities) != 1 || *identities[0].ID != writerID {
+		t.Errorf("FindIdentities by id returned %d identities, want writer", len(identities))
+	}
+	identities, err = b.store.FindIdentities(b.ctx, user.OID, database.IdentityFilter{Skip: 1, Limit: 5})
+	ok(t, err, "paging identities")
+	if len(identities) != 1 || *identities[0].ID != writerID {
+		t.Errorf("FindIdentities skipping 1 returned %d identities, want writer", len(identities))
+	}
+	identities, err = b.store.FindIdentities(b.ctx, user.OID, database.IdentityFilter{IDs: []primitive.ObjectID{}})
+	ok(t, err, "finding no identities")
+	if len(identities) != 0 {
+		t.Errorf("FindIdentities with no ids returned %d identities", len(identities))
+	}
+
+	matched, err := b.store.UpdateIdentity(b.ctx, athleteID, user.OID, database.IdentityUpdate{Name: "runner"})
+	ok(t, err, "renaming identity")
+	identity, err = b.store.GetIdentity(b.ctx, athleteID, user.OID)
+	ok(t, err, "getting identity")
+	if !matched || identity.Name != "runner" || identity.Description != "moves" {
+		t.Errorf("UpdateIdentity without a description = %v and left %+v", matched, identity)
+	}
+	description := ""
+	_, err = b.store.UpdateIdentity(b.ctx, athleteID, user.OID, database.IdentityUpdate{Name: "runner", Description: &description})
+	ok(t, err, "clearing description")
+	identity, err = b.store.GetIdentity(b.ctx, athleteID, user.OID)
+	ok(t, err, "getting identity")
+	if identity.Description != "" {
+		t.Errorf("UpdateIdentity with an empty description left %q", identity.Description)
+	}
+	matched, err = b.store.UpdateIdentity(b.ctx, athleteID, other.OID, database.IdentityUpdate{Name: "stolen"})
+	ok(t, err, "updating another user's identity")
+	if matched {
+		t.Errorf("UpdateIdentity of another user's identity = true")
+	}
+
+	deleted, err := b.store.DeleteIdentity(b.ctx, writerID, other.OID)
+	ok(t, err, "deleting another user's identity")
+	if deleted {
+		t.Errorf("DeleteIdentity of another user's identity = true")
+	}
+	deleted, err = b.store.DeleteIdentity(b.ctx, writerID, user.OID)
+	ok(t, err, "deleting identity")
+	if _, err := b.store.GetIdentity(b.ctx, writerID, user.OID); !deleted || err != database.ErrNotFound {
+		t.Errorf("DeleteIdentity = %v and getting it after = %v", deleted, err)
+	}
+}
+
+func checkHabits(t *testing.T, b backend) {
+	user := newUser(t, b)
+	other := newUser(t, b)
+
+	identityID, err := b.store.CreateIdentity(b.ctx, model.Identity{UserID: user.OID, Name: "athlete"})
+	ok(t, err, "creating identity")
+	runID, err := b.store.CreateHabit(b.ctx, model.Habit{UserID: user.OID, Name: "run", Description: "5k", IdentityID: identityID})
+	ok(t, err, "creating habit")
+	readID, err := b.store.CreateHabit(b.ctx, model.Habit{UserID: user.OID, Name: "read"})
+	ok(t, err, "creating habit")
+	_, err = b.store.CreateHabit(b.ctx, model.Habit{UserID: other.OID, Name: "swim", IdentityID: identityID})
+	ok(t, err, "creating habit")
+
+	habit, err := b.store.GetHabit(b.ctx, runID, user.OID)
+	ok(t, err, "getting habit")
+	if habit.Name != "run" || habit.Description != "5k" || habit.IdentityID != identityID || habit.UserID != user.OID {
+		t.Errorf("GetHabit = %+v", habit)
+	}
+	habit, err = b.store.GetHabit(b.ctx, readID, user.OID)
+	ok(t, err, "getting habit")
+	if !habit.IdentityID.IsZero() {
+		t.Errorf("a habit without identity has %s", habit.IdentityID.Hex())
+	}
+	if _, err := b.store.GetHabit(b.ctx, runID, other.OID); err != database.ErrNotFound {
+		t.Errorf("GetHabit of another user's habit = %v, want ErrNotFound", err)
+	}
+
+	habits, err := b.store.GetHabits(b.ctx, user.OID)
+	ok(t, err, "listing habits")
+	if len(habits) != 2 || *habits[0].ID != runID || *habits[1].ID != readID {
+		t.Errorf("GetHabits returned %d habits, want run then read", len(habits))
+	}
+	habits, err = b.store.FindHabits(b.ctx, user.OID, database.HabitFilter{IdentityIDs: []primitive.ObjectID{identityID}})
+	ok(t, err, "finding habits")
+	if len(habits) != 1 || *habits[0].ID != runID {
+		t.Errorf("FindHabits by identity returned %d habits, want run", len(habits))
+	}
+	habits, err = b.store.FindHabits(b.ctx, user.OID, database.HabitFilter{Limit: 1})
+	ok(t, err, "paging habits")
+	if len(habits) != 1 || *habits[0].ID != runID {
+		t.Errorf("FindHabits limited to 1 returned %d habits, want run", len(habits))
+	}
+
+	for _, taken := range []struct {
+		owner  primitive.ObjectID
+		name   string
+		except *primitive.ObjectID
+		want   bool
+	}{
+		{user.OID, "run", nil, true},
+		{user.OID, "run", &runID, false},
+		{user.OID, "run", &readID, true},
+		{user.OID, "swim", nil, false},
+		{other.OID, "swim", nil, true},
+	} {
+		got, err := b.store.HabitNameTaken(b.ctx, taken.owner, taken.name, taken.except)
+		ok(t, err, "checking name")
+		if got != taken.want {
+			t.Errorf("HabitNameTaken(%q, except %v) = %v, want %v", taken.name, taken.except != nil, got, taken.want)
+		}
+	}
+
+	matched, err := b.store.UpdateHabit(b.ctx, runID, user.OID, database.HabitUpdate{Name: "jog"})
+	ok(t, err, "renaming habit")
+	habit, err = b.store.GetHabit(b.ctx, runID, user.OID)
+	ok(t, err, "getting habit")
+	if !matched || habit.Name != "jog" || habit.Description != "5k" || habit.IdentityID != identityID {
+		t.Errorf("UpdateHabit of the name only = %v and left %+v", matched, habit)
+	}
+	description := "10k"
+	_, err = b.store.UpdateHabit(b.ctx, readID, user.OID, database.HabitUpdate{Name: "read", Description: &description, IdentityID: &identityID})
+	ok(t, err, "updating habit")
+	habit, err = b.store.GetHabit(b.ctx, readID, user.OID)
+	ok(t, err, "getting habit")
+	if habit.Description != "10k" || habit.IdentityID != identityID {
+		t.Errorf("UpdateHabit of every field left %+v", habit)
+	}
+	matched, err = b.store.UpdateHabit(b.ctx, runID, other.OID, database.HabitUpdate{Name: "stolen"})
+	ok(t, err, "updating another user's habit")
+	if matched {
+		t.Errorf("UpdateHabit of another user's habit = true")
+	}
+
+	deleted, err := b.store.DeleteHabit(b.ctx, readID, user.OID)
+	ok(t, err, "deleting habit")
+	if _, err := b.store.GetHabit(b.ctx, readID, user.OID); !deleted || err != database.ErrNotFound {
+		t.Errorf("DeleteHabit = %v and getting it after = %v", deleted, err)
+	}
+}
+
+func checkLogs(t *testing.T, b backend) {
+	user := newUser(t, b)
+	other := newUser(t, b)
+
+	runID, err := b.store.CreateHabit(b.ctx, model.Habit{UserID: user.OID, Name: "run"})
+	ok(t, err, "creating habit")
+	_, err = b.store.CreateHabit(b.ctx, model.Habit{UserID: user.OID, Name: "read"})
+	ok(t, err, "creating habit")
+	// Another user's habit of the same name stays out of habits_info
+	_, err = b.store.CreateHabit(b.ctx, model.Habit{UserID: other.OID, Name: "run"})
+	ok(t, err, "creating habit")
+
+	var ids []primitive.ObjectID
+	for _, entry := range []model.Log{
+		{UserID: user.OID, Entry: "first", Habits: []string{"run", "read"}},
+		{UserID: user.OID, Entry: "second", Habits: []string{"read"}},
+		{UserID: user.OID, Entry: "third", Habits: []string{"unknown", "run"}},
+		{UserID: user.OID, Entry: "untagged"},
+		{UserID: other.OID, Entry: "other", Habits: []string{"run"}},
+	} {
+		id, err := b.store.CreateLog(b.ctx, entry)
+		ok(t, err, "creating log")
+		ids = append(ids, id)
+	}
+
+	logEntry, err := b.store.GetLog(b.ctx, ids[2], user.OID)
+	ok(t, err, "getting log")
+	if logEntry.Entry != "third" || strings.Join(logEntry.Habits, ",") != "unknown,run" || logEntry.UserID != user.OID {
+		t.Errorf("GetLog = %+v", logEntry)
+	}
+	if len(logEntry.HabitsInfo) != 1 || *logEntry.HabitsInfo[0].ID != runID {
+		t.Errorf("GetLog has %d habits_info, want the user's run", len(logEntry.HabitsInfo))
+	}
+	logEntry, err = b.store.GetLog(b.ctx, ids[3], user.OID)
+	ok(t, err, "getting log")
+	if len(logEntry.Habits) != 0 || len(logEntry.HabitsInfo) != 0 {
+		t.Errorf("an untagged log has habits %v and habits_info %v, want none", logEntry.Habits, logEntry.HabitsInfo)
+	}
+	if _, err := b.store.GetLog(b.ctx, ids[4], user.OID); err != database.ErrNotFound {
+		t.Errorf("GetLog of another user's log = %v, want ErrNotFound", err)
+	}
+
+	logs, err := b.store.GetLogs(b.ctx, user.OID)
+	ok(t, err, "listing logs")
+	if entries(logs) != "untagged,third,second,first" {
+		t.Errorf("GetLogs = %s, want the newest first", entries(logs))
+	}
+	if len(logs) == 4 && len(logs[3].HabitsInfo) != 2 {
+		t.Errorf("GetLogs gave the first log %d habits_info, want 2", len(logs[3].HabitsInfo))
+	}
+
+	logs, err = b.store.FindLogs(b.ctx, user.OID, database.LogFilter{Habits: []string{"run"}})
+	ok(t, err, "finding logs")
+	if entries(logs) != "third,first" {
+		t.Errorf("FindLogs by habit = %s, want third,first", entries(logs))
+	}
+	logs, err = b.store.FindLogs(b.ctx, user.OID, database.LogFilter{Skip: 1, Limit: 2})
+	ok(t, err, "paging logs")
+	if entries(logs) != "third,second" {
+		t.Errorf("FindLogs skipping 1 limited to 2 = %s, want third,second", entries(logs))
+	}
+	logs, err = b.store.FindLogs(b.ctx, user.OID, database.LogFilter{Habits: []string{}})
+	ok(t, err, "finding no logs")
+	if len(logs) != 0 {
+		t.Errorf("FindLogs with no habits = %s, want none", entries(logs))
+	}
+
+	recent, err := b.store.GetRecentLogsByHabits(b.ctx, user.OID, []string{"run", "read", "swim"}, 1)
+	ok(t, err, "getting recent logs")
+	byHabit := map[string]string{}
+	for _, group := range recent {
+		byHabit[group.Habit] = entries(group.Logs)
+	}
+	if len(byHabit) != 2 || byHabit["run"] != "third" || byHabit["read"] != "second" {
+		t.Errorf("GetRecentLogsByHabits limited to 1 = %v, want run: third and read: second", byHabit)
+	}
+
+	matched, err := b.store.UpdateLog(b.ctx, ids[0], user.OID, database.LogUpdate{Entry: "edited"})
+	ok(t, err, "updating entry")
+	logEntry, err = b.store.GetLog(b.ctx, ids[0], user.OID)
+	ok(t, err, "getting log")
+	if !matched || logEntry.Entry != "edited" || strings.Join(logEntry.Habits, ",") != "run,read" {
+		t.Errorf("UpdateLog without habits = %v and left %q tagged %v", matched, logEntry.Entry, logEntry.Habits)
+	}
+	_, err = b.store.UpdateLog(b.ctx, ids[0], user.OID, database.LogUpdate{Entry: "edited", Habits: []string{"read", "run"}})
+	ok(t, err, "updating habits")
+	logEntry, err = b.store.GetLog(b.ctx, ids[0], user.OID)
+	ok(t, err, "getting log")
+	if strings.Join(logEntry.Habits, ",") != "read,run" {
+		t.Errorf("UpdateLog with habits left %v", logEntry.Habits)
+	}
+	_, err = b.store.UpdateLog(b.ctx, ids[0], user.OID, database.LogUpdate{Entry: "edited", Habits: []string{}})
+	ok(t, err, "clearing habits")
+	logEntry, err = b.store.GetLog(b.ctx, ids[0], user.OID)
+	ok(t, err, "getting log")
+	if len(logEntry.Habits) != 0 || len(logEntry.HabitsInfo) != 0 {
+		t.Errorf("UpdateLog with no habits left %v", logEntry.Habits)
+	}
+	matched, err = b.store.UpdateLog(b.ctx, ids[4], user.OID, database.LogUpdate{Entry: "stolen"})
+	ok(t, err, "updating another user's log")
+	if matched {
+		t.Errorf("UpdateLog of another user's log = true")
+	}
+
+	deleted, err := b.store.DeleteLog(b.ctx, ids[4], user.OID)
+	ok(t, err, "deleting another user's log")
+	if deleted {
+		t.Errorf("DeleteLog of another user's log = true")
+	}
+	deleted, err = b.store.DeleteLog(b.ctx, ids[1], user.OID)
+	ok(t, err, "deleting log")
+	if _, err := b.store.GetLog(b.ctx, ids[1], user.OID); !deleted || err != database.ErrNotFound {
+		t.Errorf("DeleteLog = %v and getting it after = %v", deleted, err)
+	}
+}
+
+func checkDeleteAccount(t *testing.T, b backend) {
+	user := newUser(t, b)
+	other := newUser(t, b)
+
+	_, err := b.store.CreateIdentity(b.ctx, model.Identity{UserID: user.OID, Name: "athlete"})
+	ok(t, err, "creating identity")
+	_, err = b.store.CreateHabit(b.ctx, model.Habit{UserID: user.OID, Name: "run"})
+	ok(t, err, "creating habit")
+	_, err = b.store.CreateLog(b.ctx, model.Log{UserID: user.OID, Entry: "ran", Habits: []string{"run"}})
+	ok(t, err, "creating log")
+	_, err = b.store.CreateAPIKey(b.ctx, model.APIKey{UserID: user.OID, Name: "key", Scope: model.ScopeRead, KeyHash: user.Username + "-key", CreatedAt: time.Now()})
+	ok(t, err, "creating key")
+	ok(t, b.store.CreatePasswordReset(b.ctx, model.PasswordResetToken{UserID: user.OID, TokenHash: user.Username + "-reset", ExpiresAt: time.Now().Add(time.Hour)}), "creating reset")
+	ok(t, b.store.AuditLogin(b.ctx, model.LoginAttempt{UserID: user.OID, Username: user.Username, Success: true, Time: time.Now()}), "auditing login")
+	otherLog, err := b.store.CreateLog(b.ctx, model.Log{UserID: other.OID, Entry: "stays"})
+	ok(t, err, "creating log")
+
+	ok(t, b.store.DeleteAccount(b.ctx, user.OID), "deleting account")
+
+	if _, err := b.store.GetUser(b.ctx, user.OID); err != database.ErrNotFound {
+		t.Errorf("GetUser of a deleted user = %v, want ErrNotFound", err)
+	}
+	identities, _ := b.store.GetIdentities(b.ctx, user.OID)
+	habits, _ := b.store.GetHabits(b.ctx, user.OID)
+	logs, _ := b.store.GetLogs(b.ctx, user.OID)
+	keys, _ := b.store.GetAPIKeys(b.ctx, user.OID)
+	if len(identities)+len(habits)+len(logs)+len(keys) > 0 {
+		t.Errorf("a deleted user left %d identities, %d habits, %d logs and %d keys", len(identities), len(habits), len(logs), len(keys))
+	}
+	if _, err := b.store.GetPasswordReset(b.ctx, user.Username+"-reset"); err != database.ErrNotFound {
+		t.Errorf("GetPasswordReset of a deleted user = %v, want ErrNotFound", err)
+	}
+	if _, err := b.store.GetLog(b.ctx, otherLog, other.OID); err != nil {
+		t.Errorf("deleting an account took another user's log: %v", err)
+	}
+}
+
+func entries(logs []*model.Log) string {
+	names := make([]string, len(logs))
+	for i, logEntry := range logs {
+		names[i] = logEntry.Entry
+	}
+	return strings.Join(names, ",")
+}
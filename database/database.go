@@ -2,9 +2,11 @@ package database
 
 import (
 	"context"
+	"fmt"
+	"goplay/config"
 	"goplay/model"
 	"log"
-	"os"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -14,190 +16,405 @@ import (
 )
 
 var (
+	client *mongo.Client
+
 	DB         *mongo.Database
 	Logs       *mongo.Collection
 	Users      *mongo.Collection
 	Habits     *mongo.Collection
 	Identities *mongo.Collection
+	LoginAudit *mongo.Collection
+	// PasswordResets holds the reset links that haven't been used yet
+	PasswordResets *mongo.Collection
+	APIKeys        *mongo.Collection
 )
 
-func init() {
-	dbPath := os.Getenv("MONGO_PATH")
+// Open connects to the database the driver of cfg names and makes it the store of the
+// package functions. It retries with exponential backoff until the server answers or ctx is done.
+func Open(ctx context.Context, cfg config.Database) error {
+	if err := setDeadlines(cfg); err != nil {
+		return err
+	}
 
-	if len(dbPath) == 0 {
-		dbPath = "localhost"
+	open := openMongo
+	switch cfg.Driver {
+	case "postgres":
+		open = openPostgres
+	case "sqlite":
+		open = openSQLite
 	}
+	backoff := 500 * time.Millisecond
 
-	mongoURL := os.Getenv("MONGO_URL")
+	for attempt := 1; ; attempt++ {
+		s, err := open(ctx, cfg)
+		if err == nil {
+			store = s
+			break
+		}
 
-	if len(mongoURL) == 0 {
-		mongoURL = "mongodb://" + dbPath + ":27017"
+		log.Printf("Couldn't connect to the database (attempt %d), retrying in %s: %v", attempt, backoff, err)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("couldn't connect to the database: %v", err)
+		case <-time.After(backoff):
+		}
+
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
 	}
+	log.Println("Connected!")
+	return nil
+}
 
-	client, _ := mongo.Connect(context.TODO(), options.Client().ApplyURI(mongoURL))
-	err := client.Ping(context.TODO(), readpref.Primary())
+const (
+	maxBackoff     = 10 * time.Second
+	attemptTimeout = 5 * time.Second
+)
+
+// mongoStore keeps the data in the collections of DB
+type mongoStore struct{}
+
+func openMongo(ctx context.Context, cfg config.Database) (Store, error) {
+	c, err := connect(ctx, cfg.URL)
 	if err != nil {
-		log.Fatal("Couldn't connect to the database", err)
-	} else {
-		log.Println("Connected!")
+		return nil, err
 	}
 
-	DB = client.Database("jonapi")
+	client = c
+	DB = client.Database(cfg.Name)
 	Logs = DB.Collection("logs")
 	Users = DB.Collection("users")
 	Habits = DB.Collection("habits")
 	Identities = DB.Collection("identities")
+	LoginAudit = DB.Collection("login_audit")
+	PasswordResets = DB.Collection("password_resets")
+	APIKeys = DB.Collection("api_keys")
+	return mongoStore{}, nil
 }
 
-// Creates a new Log
-func CreateLog(logEntry model.Log) *mongo.InsertOneResult {
-	result, err := Logs.InsertOne(context.TODO(), logEntry)
+func connect(ctx context.Context, url string) (*mongo.Client, error) {
+	ctx, cancel := context.WithTimeout(ctx, attemptTimeout)
+	defer cancel()
+
+	c, err := mongo.Connect(ctx, options.Client().ApplyURI(url).SetMonitor(newCommandMonitor()))
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
+	}
+
+	if err := c.Ping(ctx, readpref.Primary()); err != nil {
+		c.Disconnect(context.Background())
+		return nil, err
 	}
-	return result
+	return c, nil
 }
 
+// Ping checks that the primary answers
+func (mongoStore) Ping(ctx context.Context) error {
+	return client.Ping(ctx, readpref.Primary())
+}
+
+// Close disconnects from mongo, waiting for in-flight operations until ctx is done
+func (mongoStore) Close(ctx context.Context) error {
+	return client.Disconnect(ctx)
+}
+
+// CreateLog stores a new log and returns its id
+func (mongoStore) CreateLog(ctx context.Context, logEntry model.Log) (primitive.ObjectID, error) {
+	return insert(ctx, Logs, logEntry)
+}
+
+// newestLogsFirst sorts logs by order_number like before the storage backends, for the logs of
+// clients that set one. The rest follow newest first, which is how the SQL backends list them.
+var newestLogsFirst = bson.D{{"order_number", -1}, {"_id", -1}}
+
+// logsLookup adds the owner's habits a log is tagged with as habits_info, oldest first.
+// Matching on the name alone would add other users' habits of the same name too.
 var logsLookup = bson.D{
 	{"from", "habits"},
-	{"localField", "habits"},
-	{"foreignField", "name"},
+	{"let", bson.D{{"habits", bson.D{{"$ifNull", bson.A{"$habits", bson.A{}}}}}, {"owner", "$user_id"}}},
+	{"pipeline", mongo.Pipeline{
+		{{"$match", bson.D{{"$expr", bson.D{{"$and", bson.A{
+			bson.D{{"$eq", bson.A{"$user_id", "$$owner"}}},
+			bson.D{{"$in", bson.A{"$name", "$$habits"}}},
+		}}}}}}},
+		{{"$sort", bson.D{{"_id", 1}}}},
+	}},
 	{"as", "habits_info"},
 }
 
-func GetLog(id primitive.ObjectID, ownerId primitive.ObjectID) model.Log {
-	var logEntry model.Log
-
+func (mongoStore) GetLog(ctx context.Context, id primitive.ObjectID, ownerId primitive.ObjectID) (model.Log, error) {
 	pipeline := mongo.Pipeline{
 		{{"$match", bson.D{{"_id", id}, {"user_id", ownerId}}}},
 		{{"$lookup", logsLookup}},
 	}
-	cursor, err := Logs.Aggregate(context.Background(), pipeline)
-	if err != nil {
-		log.Fatal(err)
+
+	var results []*model.Log
+	if err := aggregateAll(ctx, Logs, pipeline, &results); err != nil {
+		return model.Log{}, err
+	}
+	if len(results) == 0 {
+		return model.Log{}, ErrNotFound
 	}
+	return *results[0], nil
+}
 
-	defer cursor.Close(context.Background())
-	for cursor.Next(context.Background()) {
-		err := cursor.Decode(&logEntry)
-		if err != nil {
-			log.Fatal(err)
-		}
+func (mongoStore) GetLogs(ctx context.Context, ownerId primitive.ObjectID) ([]*model.Log, error) {
+	pipeline := mongo.Pipeline{
+		{{"$match", bson.D{{"user_id", ownerId}}}},
+		{{"$sort", newestLogsFirst}},
+		{{"$lookup", logsLookup}},
 	}
 
-	return logEntry
+	var results []*model.Log
+	err := aggregateAll(ctx, Logs, pipeline, &results)
+	return results, err
 }
 
-func GetLogs(ownerId primitive.ObjectID) []*model.Log {
-	var results []*model.Log
+func (mongoStore) CreateHabit(ctx context.Context, habit model.Habit) (primitive.ObjectID, error) {
+	id, err := insert(ctx, Habits, habit)
+	if mongo.IsDuplicateKeyError(err) {
+		return primitive.NilObjectID, ErrHabitNameTaken
+	}
+	return id, err
+}
 
+// GetHabits lists the owner's habits in the order they were created, like the SQL backends.
+// Without the sort they'd come in mongo's natural order, which only usually is that.
+func (mongoStore) GetHabits(ctx context.Context, ownerId primitive.ObjectID) ([]*model.Habit, error) {
 	pipeline := mongo.Pipeline{
 		{{"$match", bson.D{{"user_id", ownerId}}}},
-		{{"$sort", bson.D{{"order_number", -1}}}},
-		{{"$lookup", logsLookup}},
+		{{"$sort", bson.D{{"_id", 1}}}},
 	}
 
-	cursor, err := Logs.Aggregate(context.Background(), pipeline)
-	defer cursor.Close(context.Background())
-	if err != nil {
-		log.Fatal(err)
+	var results []*model.Habit
+	err := aggregateAll(ctx, Habits, pipeline, &results)
+	return results, err
+}
+
+// CreateIdentity
+func (mongoStore) CreateIdentity(ctx context.Context, identity model.Identity) (primitive.ObjectID, error) {
+	return insert(ctx, Identities, identity)
+}
+
+// GetIdentities lists the owner's identities in the order they were created, like GetHabits
+func (mongoStore) GetIdentities(ctx context.Context, ownerId primitive.ObjectID) ([]*model.Identity, error) {
+	pipeline := mongo.Pipeline{
+		{{"$match", bson.D{{"user_id", ownerId}}}},
+		{{"$sort", bson.D{{"_id", 1}}}},
 	}
 
-	// Iterate through the cursor
-	for cursor.Next(context.Background()) {
-		var logEntry model.Log
-		err := cursor.Decode(&logEntry)
-		if err != nil {
-			log.Fatal(err)
-		}
+	var results []*model.Identity
+	err := aggregateAll(ctx, Identities, pipeline, &results)
+	return results, err
+}
+
+// GetUserByCalendarToken finds the owner of a calendar feed token
+func (mongoStore) GetUserByCalendarToken(ctx context.Context, token string) (model.User, error) {
+	var user model.User
+	err := Users.FindOne(ctx, bson.D{{"calendar_token", token}}).Decode(&user)
+	return user, err
+}
+
+// SetCalendarToken replaces the calendar feed token of a user
+func (mongoStore) SetCalendarToken(ctx context.Context, userID primitive.ObjectID, token string) error {
+	update := bson.D{
+		{"$set", bson.D{{"calendar_token", token}}},
+	}
+	_, err := Users.UpdateOne(ctx, bson.D{{"_id", userID}}, update)
+	return err
+}
 
-		results = append(results, &logEntry)
+// FindLogs returns the owner's logs matching the filter, newest first
+func (mongoStore) FindLogs(ctx context.Context, ownerID primitive.ObjectID, filter LogFilter) ([]*model.Log, error) {
+	match := bson.D{{"user_id", ownerID}}
+	if filter.Habits != nil {
+		match = append(match, bson.E{"habits", bson.D{{"$in", filter.Habits}}})
 	}
 
-	if err := cursor.Err(); err != nil {
-		log.Fatal(err)
+	pipeline := mongo.Pipeline{
+		{{"$match", match}},
+		{{"$sort", newestLogsFirst}},
 	}
+	pipeline = appendPage(pipeline, filter.Skip, filter.Limit)
+	pipeline = append(pipeline, bson.D{{"$lookup", logsLookup}})
 
-	return results
+	var results []*model.Log
+	err := aggregateAll(ctx, Logs, pipeline, &results)
+	return results, err
 }
 
-func CreateHabit(habit model.Habit) *mongo.InsertOneResult {
-	result, err := Habits.InsertOne(context.TODO(), habit)
-	if err != nil {
-		log.Fatal(err)
+// GetRecentLogsByHabits returns up to limit of the newest logs for each habit name in a single query
+func (mongoStore) GetRecentLogsByHabits(ctx context.Context, ownerID primitive.ObjectID, habits []string, limit int64) ([]*HabitLogs, error) {
+	pipeline := mongo.Pipeline{
+		{{"$match", bson.D{{"user_id", ownerID}, {"habits", bson.D{{"$in", habits}}}}}},
+		{{"$sort", newestLogsFirst}},
+		{{"$lookup", logsLookup}},
+		{{"$addFields", bson.D{{"_habit", "$habits"}}}},
+		{{"$unwind", "$_habit"}},
+		{{"$match", bson.D{{"_habit", bson.D{{"$in", habits}}}}}},
+		{{"$group", bson.D{{"_id", "$_habit"}, {"logs", bson.D{{"$push", "$$ROOT"}}}}}},
+		{{"$project", bson.D{{"logs", bson.D{{"$slice", bson.A{"$logs", limit}}}}}}},
 	}
-	return result
+
+	var results []*HabitLogs
+	err := aggregateAll(ctx, Logs, pipeline, &results)
+	return results, err
 }
 
-func GetHabits(ownerId primitive.ObjectID) []*model.Habit {
+// FindHabits returns the owner's habits matching the filter
+func (mongoStore) FindHabits(ctx context.Context, ownerID primitive.ObjectID, filter HabitFilter) ([]*model.Habit, error) {
+	match := bson.D{{"user_id", ownerID}}
+	if filter.IdentityIDs != nil {
+		match = append(match, bson.E{"identity_id", bson.D{{"$in", filter.IdentityIDs}}})
+	}
+
+	pipeline := mongo.Pipeline{
+		{{"$match", match}},
+		{{"$sort", bson.D{{"_id", 1}}}},
+	}
+	pipeline = appendPage(pipeline, filter.Skip, filter.Limit)
+
 	var results []*model.Habit
+	err := aggregateAll(ctx, Habits, pipeline, &results)
+	return results, err
+}
+
+// FindIdentities returns the owner's identities matching the filter
+func (mongoStore) FindIdentities(ctx context.Context, ownerID primitive.ObjectID, filter IdentityFilter) ([]*model.Identity, error) {
+	match := bson.D{{"user_id", ownerID}}
+	if filter.IDs != nil {
+		match = append(match, bson.E{"_id", bson.D{{"$in", filter.IDs}}})
+	}
 
 	pipeline := mongo.Pipeline{
-		{{"$match", bson.D{{"user_id", ownerId}}}},
-		// {{"$lookup", logsLookup}},
+		{{"$match", match}},
+		{{"$sort", bson.D{{"_id", 1}}}},
 	}
+	pipeline = appendPage(pipeline, filter.Skip, filter.Limit)
 
-	cursor, err := Habits.Aggregate(context.Background(), pipeline)
-	defer cursor.Close(context.Background())
-	if err != nil {
-		log.Fatal(err)
+	var results []*model.Identity
+	err := aggregateAll(ctx, Identities, pipeline, &results)
+	return results, err
+}
+
+func (mongoStore) UpdateLog(ctx context.Context, id primitive.ObjectID, ownerID primitive.ObjectID, update LogUpdate) (bool, error) {
+	set := bson.D{{"entry", update.Entry}}
+	if update.Habits != nil {
+		set = append(set, bson.E{"habits", update.Habits})
 	}
+	return updateOwned(ctx, Logs, id, ownerID, set)
+}
 
-	// Iterate through the cursor
-	for cursor.Next(context.Background()) {
-		var habit model.Habit
-		err := cursor.Decode(&habit)
-		if err != nil {
-			log.Fatal(err)
-		}
+func (mongoStore) DeleteLog(ctx context.Context, id primitive.ObjectID, ownerID primitive.ObjectID) (bool, error) {
+	return deleteOwned(ctx, Logs, id, ownerID)
+}
 
-		results = append(results, &habit)
+func (mongoStore) UpdateHabit(ctx context.Context, id primitive.ObjectID, ownerID primitive.ObjectID, update HabitUpdate) (bool, error) {
+	set := bson.D{{"name", update.Name}}
+	if update.Description != nil {
+		set = append(set, bson.E{"description", *update.Description})
+	}
+	if update.IdentityID != nil {
+		set = append(set, bson.E{"identity_id", *update.IdentityID})
 	}
+	matched, err := updateOwned(ctx, Habits, id, ownerID, set)
+	if mongo.IsDuplicateKeyError(err) {
+		return false, ErrHabitNameTaken
+	}
+	return matched, err
+}
+
+func (mongoStore) DeleteHabit(ctx context.Context, id primitive.ObjectID, ownerID primitive.ObjectID) (bool, error) {
+	return deleteOwned(ctx, Habits, id, ownerID)
+}
 
-	if err := cursor.Err(); err != nil {
-		log.Fatal(err)
+func (mongoStore) UpdateIdentity(ctx context.Context, id primitive.ObjectID, ownerID primitive.ObjectID, update IdentityUpdate) (bool, error) {
+	set := bson.D{{"name", update.Name}}
+	if update.Description != nil {
+		set = append(set, bson.E{"description", *update.Description})
 	}
+	return updateOwned(ctx, Identities, id, ownerID, set)
+}
 
-	return results
+func (mongoStore) DeleteIdentity(ctx context.Context, id primitive.ObjectID, ownerID primitive.ObjectID) (bool, error) {
+	return deleteOwned(ctx, Identities, id, ownerID)
 }
 
-// CreateIdentity
-func CreateIdentity(identity model.Identity) *mongo.InsertOneResult {
-	result, err := Identities.InsertOne(context.TODO(), identity)
+func (mongoStore) DeleteAPIKey(ctx context.Context, id primitive.ObjectID, ownerID primitive.ObjectID) (bool, error) {
+	return deleteOwned(ctx, APIKeys, id, ownerID)
+}
+
+// insert stores a document and returns the id mongo gave it
+func insert(ctx context.Context, collection *mongo.Collection, document interface{}) (primitive.ObjectID, error) {
+	result, err := collection.InsertOne(ctx, document)
 	if err != nil {
-		log.Fatal(err)
+		return primitive.NilObjectID, err
 	}
-	return result
+	return result.InsertedID.(primitive.ObjectID), nil
 }
 
-func GetIdentities(ownerId primitive.ObjectID) []*model.Identity {
-	var results []*model.Identity
-
-	pipeline := mongo.Pipeline{
-		{{"$match", bson.D{{"user_id", ownerId}}}},
-		// {{"$lookup", logsLookup}},
+// updateOwned applies $set to the document with id if it belongs to the owner.
+// It reports whether a document matched.
+func updateOwned(ctx context.Context, collection *mongo.Collection, id primitive.ObjectID, ownerID primitive.ObjectID, set bson.D) (bool, error) {
+	filter := bson.D{{"_id", id}, {"user_id", ownerID}}
+	result, err := collection.UpdateOne(ctx, filter, bson.D{{"$set", set}})
+	if err != nil {
+		return false, err
 	}
+	return result.MatchedCount > 0, nil
+}
 
-	cursor, err := Identities.Aggregate(context.Background(), pipeline)
-	defer cursor.Close(context.Background())
+// deleteOwned removes the document with id if it belongs to the owner.
+// It reports whether a document was removed.
+func deleteOwned(ctx context.Context, collection *mongo.Collection, id primitive.ObjectID, ownerID primitive.ObjectID) (bool, error) {
+	filter := bson.D{{"_id", id}, {"user_id", ownerID}}
+	result, err := collection.DeleteOne(ctx, filter)
 	if err != nil {
-		log.Fatal(err)
+		return false, err
 	}
+	return result.DeletedCount > 0, nil
+}
 
-	// Iterate through the cursor
-	for cursor.Next(context.Background()) {
-		var identity model.Identity
-		err := cursor.Decode(&identity)
-		if err != nil {
-			log.Fatal(err)
-		}
+func appendPage(pipeline mongo.Pipeline, skip int64, limit int64) mongo.Pipeline {
+	if skip > 0 {
+		pipeline = append(pipeline, bson.D{{"$skip", skip}})
+	}
+	if limit > 0 {
+		pipeline = append(pipeline, bson.D{{"$limit", limit}})
+	}
+	return pipeline
+}
 
-		results = append(results, &identity)
+func aggregateAll(ctx context.Context, collection *mongo.Collection, pipeline mongo.Pipeline, results interface{}) error {
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return err
 	}
+	return cursor.All(ctx, results)
+}
+
+// GetHabit returns the habit with id if it belongs to the owner
+func (mongoStore) GetHabit(ctx context.Context, id primitive.ObjectID, ownerID primitive.ObjectID) (model.Habit, error) {
+	var habit model.Habit
+	err := Habits.FindOne(ctx, bson.D{{"_id", id}, {"user_id", ownerID}}).Decode(&habit)
+	return habit, err
+}
+
+// GetIdentity returns the identity with id if it belongs to the owner
+func (mongoStore) GetIdentity(ctx context.Context, id primitive.ObjectID, ownerID primitive.ObjectID) (model.Identity, error) {
+	var identity model.Identity
+	err := Identities.FindOne(ctx, bson.D{{"_id", id}, {"user_id", ownerID}}).Decode(&identity)
+	return identity, err
+}
 
-	if err := cursor.Err(); err != nil {
-		log.Fatal(err)
+// HabitNameTaken reports whether the owner has another habit than except called name
+func (mongoStore) HabitNameTaken(ctx context.Context, ownerID primitive.ObjectID, name string, except *primitive.ObjectID) (bool, error) {
+	filter := bson.D{{"user_id", ownerID}, {"name", name}}
+	if except != nil {
+		filter = append(filter, bson.E{"_id", bson.D{{"$ne", *except}}})
 	}
 
-	return results
+	count, err := Habits.CountDocuments(ctx, filter, options.Count().SetLimit(1))
+	return count > 0, err
 }
@@ -0,0 +1,87 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"goplay/config"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrTimeout is returned when an operation ran out of time, its own deadline or the caller's.
+// The error of the driver is wrapped, compare with errors.Is.
+var ErrTimeout = errors.New("database: operation timed out")
+
+// ErrCanceled is returned when the caller gave up on an operation, e.g. the client of a
+// request went away. The error of the driver is wrapped, compare with errors.Is.
+var ErrCanceled = errors.New("database: operation canceled")
+
+// Operations are the names deadlines can be set for in database.timeouts,
+// one for each function of the package
+var Operations = []string{
+	"create_user", "get_user", "get_user_by_username", "get_user_by_email", "get_user_by_calendar_token",
+	"set_calendar_token", "update_account", "verify_email", "delete_account",
+	"set_password", "rehash_password", "create_password_reset", "get_password_reset", "use_password_reset",
+	"record_login_failure", "record_login_success", "audit_login",
+	"set_pending_totp", "enable_totp", "disable_totp", "use_totp_step", "use_recovery_code",
+	"create_api_key", "get_api_keys", "get_api_key_by_hash", "touch_api_key", "delete_api_key",
+	"create_log", "get_log", "get_logs", "find_logs", "get_recent_logs_by_habits", "update_log", "delete_log",
+	"create_habit", "get_habit", "get_habits", "find_habits", "habit_name_taken", "update_habit", "delete_habit",
+	"create_identity", "get_identity", "get_identities", "find_identities", "update_identity", "delete_identity",
+}
+
+// deadlines bound how long each operation may take, set by Open
+var deadlines struct {
+	fallback   time.Duration
+	operations map[string]time.Duration
+}
+
+func setDeadlines(cfg config.Database) error {
+	known := map[string]bool{}
+	for _, operation := range Operations {
+		known[operation] = true
+	}
+	var unknown []string
+	for operation := range cfg.Timeouts {
+		if !known[operation] {
+			unknown = append(unknown, operation)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("database.timeouts: unknown operations %s", strings.Join(unknown, ", "))
+	}
+
+	deadlines.fallback = cfg.Timeout
+	deadlines.operations = cfg.Timeouts
+	return nil
+}
+
+// withDeadline derives the context an operation runs in from the caller's
+func withDeadline(ctx context.Context, operation string) (context.Context, context.CancelFunc) {
+	timeout, ok := deadlines.operations[operation]
+	if !ok {
+		timeout = deadlines.fallback
+	}
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// interrupted tells apart the errors of an operation that ran out of time or was canceled,
+// whatever the driver made of them
+func interrupted(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	switch ctx.Err() {
+	case context.DeadlineExceeded:
+		return fmt.Errorf("%w: %v", ErrTimeout, err)
+	case context.Canceled:
+		return fmt.Errorf("%w: %v", ErrCanceled, err)
+	}
+	return err
+}
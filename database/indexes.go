@@ -0,0 +1,87 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// usernameCollation compares usernames and email addresses ignoring case. Queries on them
+// have to use it too, to match the way the unique indexes do and to be able to use them.
+var usernameCollation = &options.Collation{Locale: "en", Strength: 2}
+
+// habitNameIndex keeps the names of an owner's habits unique. It replaces a plain index on
+// the same keys with the default name, mongo doesn't allow both.
+const (
+	habitNameIndex    = "user_id_name_unique"
+	oldHabitNameIndex = "user_id_1_name_1"
+)
+
+// EnsureSchema creates the indexes the queries and uniqueness rules rely on.
+// Existing indexes are left alone, so it is safe to run on every start.
+func (mongoStore) EnsureSchema(ctx context.Context) error {
+	indexes := []struct {
+		collection *mongo.Collection
+		models     []mongo.IndexModel
+	}{
+		{Users, []mongo.IndexModel{
+			{
+				Keys:    bson.D{{"username", 1}},
+				Options: options.Index().SetName("username_unique_ci").SetUnique(true).SetCollation(usernameCollation),
+			},
+			{
+				// Sparse, accounts without an email don't collide
+				Keys:    bson.D{{"email", 1}},
+				Options: options.Index().SetName(emailIndex).SetUnique(true).SetSparse(true).SetCollation(usernameCollation),
+			},
+			{
+				Keys:    bson.D{{"calendar_token", 1}},
+				Options: options.Index().SetName("calendar_token").SetSparse(true),
+			},
+		}},
+		{Logs, []mongo.IndexModel{{Keys: bson.D{{"user_id", 1}}}}},
+		{Habits, []mongo.IndexModel{{
+			Keys:    bson.D{{"user_id", 1}, {"name", 1}},
+			Options: options.Index().SetName(habitNameIndex).SetUnique(true),
+		}}},
+		{Identities, []mongo.IndexModel{{Keys: bson.D{{"user_id", 1}}}}},
+		{APIKeys, []mongo.IndexModel{
+			{Keys: bson.D{{"key_hash", 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{"user_id", 1}}},
+		}},
+		{PasswordResets, []mongo.IndexModel{
+			{Keys: bson.D{{"token_hash", 1}}},
+			// Mongo removes the links once they expire
+			{Keys: bson.D{{"expires_at", 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+		}},
+	}
+
+	if err := dropIndex(ctx, Habits, oldHabitNameIndex); err != nil {
+		return fmt.Errorf("dropping index %s on habits: %v", oldHabitNameIndex, err)
+	}
+
+	for _, index := range indexes {
+		_, err := index.collection.Indexes().CreateMany(ctx, index.models)
+		if index.collection == Habits && mongo.IsDuplicateKeyError(err) {
+			return fmt.Errorf("creating indexes on habits: %v; an owner has habits with the same name, run the migrate subcommand to rename them", err)
+		}
+		if err != nil {
+			return fmt.Errorf("creating indexes on %s: %v", index.collection.Name(), err)
+		}
+	}
+	return nil
+}
+
+// dropIndex drops the index called name, if both it and the collection exist
+func dropIndex(ctx context.Context, collection *mongo.Collection, name string) error {
+	_, err := collection.Indexes().DropOne(ctx, name)
+	var commandErr mongo.CommandError
+	if errors.As(err, &commandErr) && (commandErr.Name == "IndexNotFound" || commandErr.Name == "NamespaceNotFound") {
+		return nil
+	}
+	return err
+}
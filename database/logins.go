@@ -0,0 +1,55 @@
+package database
+
+import (
+	"context"
+	"goplay/model"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// RecordLoginFailure counts a failed login of the user. Once threshold failures in a row
+// are reached the user is locked for lockout and counting starts over.
+// It returns the failures in a row, including the one that caused a lockout.
+func (mongoStore) RecordLoginFailure(ctx context.Context, userID primitive.ObjectID, threshold int, lockout time.Duration) (int, error) {
+	var user model.User
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	update := bson.D{{"$inc", bson.D{{"failed_logins", 1}}}}
+	err := Users.FindOneAndUpdate(ctx, bson.D{{"_id", userID}}, update, opts).Decode(&user)
+	if err != nil {
+		return 0, err
+	}
+
+	if user.FailedLogins < threshold {
+		return user.FailedLogins, nil
+	}
+
+	update = bson.D{
+		{"$set", bson.D{{"locked_until", time.Now().Add(lockout)}}},
+		{"$unset", bson.D{{"failed_logins", ""}}},
+	}
+	_, err = Users.UpdateOne(ctx, bson.D{{"_id", userID}}, update)
+	return user.FailedLogins, err
+}
+
+// RecordLoginSuccess clears the failed logins and lockout of the user
+func (mongoStore) RecordLoginSuccess(ctx context.Context, userID primitive.ObjectID) error {
+	filter := bson.D{
+		{"_id", userID},
+		{"$or", bson.A{
+			bson.D{{"failed_logins", bson.D{{"$exists", true}}}},
+			bson.D{{"locked_until", bson.D{{"$exists", true}}}},
+		}},
+	}
+	update := bson.D{{"$unset", bson.D{{"failed_logins", ""}, {"locked_until", ""}}}}
+	_, err := Users.UpdateOne(ctx, filter, update)
+	return err
+}
+
+// AuditLogin stores the record of a login attempt
+func (mongoStore) AuditLogin(ctx context.Context, attempt model.LoginAttempt) error {
+	_, err := LoginAudit.InsertOne(ctx, attempt)
+	return err
+}
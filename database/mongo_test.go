@@ -0,0 +1,61 @@
+package database_test
+
+import (
+	"context"
+	"goplay/config"
+	"goplay/database"
+	"os"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// mongoConfig points at the scratch database of GOPLAY_TEST_MONGO_URL, skipping the test without one
+func mongoConfig(t *testing.T) config.Database {
+	url := os.Getenv("GOPLAY_TEST_MONGO_URL")
+	if len(url) == 0 {
+		t.Skip("GOPLAY_TEST_MONGO_URL isn't set, set it to a scratch mongo to run the checks against mongo")
+	}
+
+	cfg := config.Default().Database
+	cfg.Driver = "mongo"
+	cfg.URL = url
+	cfg.Name = "goplay_conformance"
+	return cfg
+}
+
+func TestMongoConformance(t *testing.T) {
+	testConformance(t, mongoConfig(t))
+}
+
+func TestMongoLogsSortByOrderNumber(t *testing.T) {
+	openStore(t, mongoConfig(t))
+	ctx := context.Background()
+
+	// Nothing sets order_number anymore, but the logs of the clients that did keep their order
+	userID := primitive.NewObjectID()
+	for _, doc := range []bson.D{
+		{{"user_id", userID}, {"entry", "first numbered"}, {"order_number", 1}},
+		{{"user_id", userID}, {"entry", "older"}},
+		{{"user_id", userID}, {"entry", "second numbered"}, {"order_number", 2}},
+		{{"user_id", userID}, {"entry", "newer"}},
+	} {
+		if _, err := database.Logs.InsertOne(ctx, doc); err != nil {
+			t.Fatal("inserting log:", err)
+		}
+	}
+
+	logs, err := database.GetLogs(ctx, userID)
+	if err != nil {
+		t.Fatal("listing logs:", err)
+	}
+	var entries []string
+	for _, logEntry := range logs {
+		entries = append(entries, logEntry.Entry)
+	}
+	if got, want := strings.Join(entries, ","), "second numbered,first numbered,newer,older"; got != want {
+		t.Errorf("GetLogs = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,96 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"goplay/logging"
+	"goplay/metrics"
+	"goplay/tracing"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/event"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// commandMonitor times every command sent to mongo, including the ones the api
+// runs on the collections directly, and reports them per collection. At debug level every command is
+// also logged with the logger of the request it ran for, and every command gets
+// a span, a child of the request span when it is run with the request context.
+type commandMonitor struct {
+	mu      sync.Mutex
+	started map[int64]command
+}
+
+// command is a command that has been sent and not answered yet
+type command struct {
+	collection string
+	span       trace.Span
+}
+
+func newCommandMonitor() *event.CommandMonitor {
+	m := &commandMonitor{started: map[int64]command{}}
+	return &event.CommandMonitor{
+		Started:   m.onStarted,
+		Succeeded: m.onSucceeded,
+		Failed:    m.onFailed,
+	}
+}
+
+func (m *commandMonitor) onStarted(ctx context.Context, e *event.CommandStartedEvent) {
+	// CRUD commands name their collection in the first element, e.g. {"find": "logs", ...}
+	collection := "none"
+	if elements, err := e.Command.Elements(); err == nil && len(elements) > 0 {
+		if name, ok := elements[0].Value().StringValueOK(); ok {
+			collection = name
+		}
+	}
+
+	_, span := tracing.StartClient(ctx, e.CommandName+" "+collection,
+		semconv.DBSystemMongoDB,
+		semconv.DBName(e.DatabaseName),
+		semconv.DBOperation(e.CommandName),
+		semconv.DBMongoDBCollection(collection),
+	)
+
+	m.mu.Lock()
+	m.started[e.RequestID] = command{collection: collection, span: span}
+	m.mu.Unlock()
+}
+
+// finish records a command that got an answer. failure is empty when it succeeded.
+func (m *commandMonitor) finish(ctx context.Context, e event.CommandFinishedEvent, failure string) {
+	failed := len(failure) > 0
+
+	m.mu.Lock()
+	cmd, ok := m.started[e.RequestID]
+	delete(m.started, e.RequestID)
+	m.mu.Unlock()
+
+	collection := "none"
+	if ok {
+		collection = cmd.collection
+		if failed {
+			tracing.Fail(cmd.span, errors.New(failure))
+		}
+		cmd.span.End()
+	}
+
+	duration := time.Duration(e.DurationNanos)
+	metrics.ObserveDB(collection, e.CommandName, duration, failed)
+
+	logging.FromContext(ctx).Debug("database command",
+		"collection", collection,
+		"operation", e.CommandName,
+		"duration_ms", float64(duration.Microseconds())/1000,
+		"failed", failed)
+}
+
+func (m *commandMonitor) onSucceeded(ctx context.Context, e *event.CommandSucceededEvent) {
+	m.finish(ctx, e.CommandFinishedEvent, "")
+}
+
+func (m *commandMonitor) onFailed(ctx context.Context, e *event.CommandFailedEvent) {
+	m.finish(ctx, e.CommandFinishedEvent, e.Failure)
+}
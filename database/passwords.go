@@ -0,0 +1,83 @@
+package database
+
+import (
+	"context"
+	"goplay/model"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// GetUser finds a user by id
+func (mongoStore) GetUser(ctx context.Context, id primitive.ObjectID) (model.User, error) {
+	var user model.User
+	err := Users.FindOne(ctx, bson.D{{"_id", id}}).Decode(&user)
+	return user, err
+}
+
+// GetUserByEmail finds the user with an email address, ignoring case
+func (mongoStore) GetUserByEmail(ctx context.Context, email string) (model.User, error) {
+	var user model.User
+	opts := options.FindOne().SetCollation(usernameCollation)
+	err := Users.FindOne(ctx, bson.D{{"email", email}}, opts).Decode(&user)
+	return user, err
+}
+
+// SetPassword replaces the password hash of a user and revokes the tokens issued
+// up to sessionsValidAfter. It also lifts a lockout, since the user proved who they are.
+func (mongoStore) SetPassword(ctx context.Context, userID primitive.ObjectID, hash string, sessionsValidAfter time.Time) error {
+	update := bson.D{
+		{"$set", bson.D{
+			{"password", hash},
+			{"sessions_valid_after", sessionsValidAfter},
+		}},
+		{"$unset", bson.D{{"failed_logins", ""}, {"locked_until", ""}}},
+	}
+	_, err := Users.UpdateOne(ctx, bson.D{{"_id", userID}}, update)
+	return err
+}
+
+// RehashPassword replaces the password hash of a user with a stronger hash of the
+// same password, unless the password changed since old was read
+func (mongoStore) RehashPassword(ctx context.Context, userID primitive.ObjectID, old string, hash string) error {
+	filter := bson.D{{"_id", userID}, {"password", old}}
+	update := bson.D{{"$set", bson.D{{"password", hash}}}}
+	_, err := Users.UpdateOne(ctx, filter, update)
+	return err
+}
+
+// CreatePasswordReset stores a reset link, replacing the ones sent to the user before
+func (mongoStore) CreatePasswordReset(ctx context.Context, reset model.PasswordResetToken) error {
+	if _, err := PasswordResets.DeleteMany(ctx, bson.D{{"user_id", reset.UserID}}); err != nil {
+		return err
+	}
+	_, err := PasswordResets.InsertOne(ctx, reset)
+	return err
+}
+
+// GetPasswordReset returns whose password the unexpired reset link with the token hash resets
+func (mongoStore) GetPasswordReset(ctx context.Context, tokenHash string) (primitive.ObjectID, error) {
+	var reset model.PasswordResetToken
+	filter := bson.D{
+		{"token_hash", tokenHash},
+		{"expires_at", bson.D{{"$gt", time.Now()}}},
+	}
+	err := PasswordResets.FindOne(ctx, filter).Decode(&reset)
+	return reset.UserID, err
+}
+
+// UsePasswordReset consumes the unexpired reset link with the token hash and returns
+// whose password it resets. A link can only be used once.
+func (mongoStore) UsePasswordReset(ctx context.Context, tokenHash string) (primitive.ObjectID, error) {
+	var reset model.PasswordResetToken
+	filter := bson.D{
+		{"token_hash", tokenHash},
+		{"expires_at", bson.D{{"$gt", time.Now()}}},
+	}
+	if err := PasswordResets.FindOneAndDelete(ctx, filter).Decode(&reset); err != nil {
+		return reset.UserID, err
+	}
+	return reset.UserID, nil
+}
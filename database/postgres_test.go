@@ -0,0 +1,19 @@
+package database_test
+
+import (
+	"goplay/config"
+	"os"
+	"testing"
+)
+
+func TestPostgresConformance(t *testing.T) {
+	url := os.Getenv("GOPLAY_TEST_POSTGRES_URL")
+	if len(url) == 0 {
+		t.Skip("GOPLAY_TEST_POSTGRES_URL isn't set, set it to a scratch database to run the checks against PostgreSQL")
+	}
+
+	cfg := config.Default().Database
+	cfg.Driver = "postgres"
+	cfg.URL = url
+	testConformance(t, cfg)
+}
@@ -0,0 +1,975 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"goplay/logging"
+	"goplay/metrics"
+	"goplay/model"
+	"goplay/tracing"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+)
+
+// dialect is what differs between the SQL databases sqlStore runs on.
+// Queries are written with $1, $2... placeholders.
+type dialect struct {
+	// system names the database in spans
+	system attribute.KeyValue
+	// migrations create and change the schema, run in order, each in its own transaction.
+	// Append new ones; never edit one that was released.
+	migrations []string
+	// lock is run at the start of each migration transaction to keep other servers out
+	lock string
+	// isDuplicate reports whether err is a broken uniqueness rule
+	isDuplicate func(err error) bool
+}
+
+// querier runs statements, on the database or in a transaction
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// sqlStore keeps the data in the tables of a SQL database. Ids are the hex of mongo object ids,
+// so the api looks the same on every backend.
+type sqlStore struct {
+	db      *sql.DB
+	q       querier
+	dialect *dialect
+}
+
+func newSQLStore(db *sql.DB, d *dialect) *sqlStore {
+	return &sqlStore{db: db, q: db, dialect: d}
+}
+
+func (s *sqlStore) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+func (s *sqlStore) Close(ctx context.Context) error {
+	return s.db.Close()
+}
+
+// EnsureSchema runs the migrations of the dialect that haven't run yet
+func (s *sqlStore) EnsureSchema(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		applied_at TIMESTAMP NOT NULL
+	)`)
+	if err != nil {
+		return fmt.Errorf("creating schema_migrations: %v", err)
+	}
+
+	for i, migration := range s.dialect.migrations {
+		version := i + 1
+		err := s.inTx(ctx, func(tx *sqlStore) error {
+			if len(s.dialect.lock) > 0 {
+				if _, err := tx.q.ExecContext(ctx, s.dialect.lock); err != nil {
+					return err
+				}
+			}
+
+			// Checked under the lock, so a migration another server ran meanwhile is seen
+			var applied int
+			err := tx.q.QueryRowContext(ctx, "SELECT COUNT(*) FROM schema_migrations WHERE version = $1", version).Scan(&applied)
+			if err != nil || applied > 0 {
+				return err
+			}
+
+			if _, err := tx.q.ExecContext(ctx, migration); err != nil {
+				return err
+			}
+			_, err = tx.q.ExecContext(ctx, "INSERT INTO schema_migrations (version, applied_at) VALUES ($1, $2)", version, time.Now().UTC())
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("migrating the schema to version %d: %v", version, err)
+		}
+	}
+	return nil
+}
+
+// inTx runs fn with a store whose statements run in a transaction, committed when fn succeeds
+func (s *sqlStore) inTx(ctx context.Context, fn func(tx *sqlStore) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(&sqlStore{db: s.db, q: tx, dialect: s.dialect}); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// observe times a statement on table like the command monitor does for mongo.
+// The returned function ends it with its error.
+func (s *sqlStore) observe(ctx context.Context, table string, query string) func(err error) {
+	operation := strings.ToLower(strings.Fields(query)[0])
+	started := time.Now()
+	_, span := tracing.StartClient(ctx, operation+" "+table,
+		s.dialect.system,
+		semconv.DBOperation(operation),
+		semconv.DBSQLTable(table),
+	)
+
+	return func(err error) {
+		failed := err != nil && err != sql.ErrNoRows
+		if failed {
+			tracing.Fail(span, err)
+		}
+		span.End()
+
+		duration := time.Since(started)
+		metrics.ObserveDB(table, operation, duration, failed)
+		logging.FromContext(ctx).Debug("database command",
+			"collection", table,
+			"operation", operation,
+			"duration_ms", float64(duration.Microseconds())/1000,
+			"failed", failed)
+	}
+}
+
+// exec runs a statement and returns the number of rows it changed
+func (s *sqlStore) exec(ctx context.Context, table string, query string, args ...interface{}) (int64, error) {
+	done := s.observe(ctx, table, query)
+	result, err := s.q.ExecContext(ctx, query, args...)
+	var affected int64
+	if err == nil {
+		affected, err = result.RowsAffected()
+	}
+	done(err)
+	return affected, err
+}
+
+// get scans the first row of a query into dest. It returns ErrNotFound when there is none.
+func (s *sqlStore) get(ctx context.Context, table string, query string, args []interface{}, dest ...interface{}) error {
+	done := s.observe(ctx, table, query)
+	err := s.q.QueryRowContext(ctx, query, args...).Scan(dest...)
+	done(err)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	return err
+}
+
+// each calls fn with every row of a query
+func (s *sqlStore) each(ctx context.Context, table string, query string, args []interface{}, fn func(rows *sql.Rows) error) (err error) {
+	done := s.observe(ctx, table, query)
+	defer func() { done(err) }()
+
+	rows, err := s.q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err := fn(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// Conversions between the model and the columns
+
+// hexID scans a char(24) column into an object id, the zero one for NULL
+type hexID struct{ id *primitive.ObjectID }
+
+func (h hexID) Scan(value interface{}) error {
+	var text string
+	switch v := value.(type) {
+	case nil:
+		*h.id = primitive.NilObjectID
+		return nil
+	case string:
+		text = v
+	case []byte:
+		text = string(v)
+	default:
+		return fmt.Errorf("can't scan %T into an object id", value)
+	}
+
+	id, err := primitive.ObjectIDFromHex(strings.TrimSpace(text))
+	*h.id = id
+	return err
+}
+
+// newID is where a scanned id of a model with an optional id goes
+func newID(id **primitive.ObjectID) hexID {
+	*id = new(primitive.ObjectID)
+	return hexID{*id}
+}
+
+// nullID stores the zero object id as NULL
+func nullID(id primitive.ObjectID) interface{} {
+	if id.IsZero() {
+		return nil
+	}
+	return id.Hex()
+}
+
+// nullString stores the empty string as NULL, the way omitempty leaves a field out in mongo
+func nullString(s string) interface{} {
+	if len(s) == 0 {
+		return nil
+	}
+	return s
+}
+
+// textOf scans a nullable text column, NULL being the empty string
+type textOf struct{ s *string }
+
+func (t textOf) Scan(value interface{}) error {
+	var ns sql.NullString
+	err := ns.Scan(value)
+	*t.s = ns.String
+	return err
+}
+
+// nullTime stores the zero time as NULL and every other time in UTC
+func nullTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t.UTC()
+}
+
+// timeLayouts are the text forms drivers that don't parse timestamps hand them over in
+var timeLayouts = []string{
+	"2006-01-02 15:04:05.999999999-07:00",
+	"2006-01-02T15:04:05.999999999-07:00",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999",
+}
+
+// timeOf scans a nullable timestamp column, NULL being the zero time
+type timeOf struct{ t *time.Time }
+
+func (t timeOf) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*t.t = time.Time{}
+		return nil
+	case time.Time:
+		*t.t = v
+		return nil
+	case []byte:
+		value = string(v)
+	}
+
+	text, ok := value.(string)
+	if !ok {
+		return fmt.Errorf("can't scan %T into a time", value)
+	}
+	for _, layout := range timeLayouts {
+		if parsed, err := time.Parse(layout, text); err == nil {
+			*t.t = parsed
+			return nil
+		}
+	}
+	return fmt.Errorf("can't parse time %q", text)
+}
+
+// in returns placeholders for values starting at $first, with the values as arguments
+func in(first int, values []string) (string, []interface{}) {
+	placeholders := make([]string, len(values))
+	args := make([]interface{}, len(values))
+	for i, v := range values {
+		placeholders[i] = "$" + strconv.Itoa(first+i)
+		args[i] = v
+	}
+	return strings.Join(placeholders, ", "), args
+}
+
+func hexIDs(ids []primitive.ObjectID) []string {
+	hexes := make([]string, len(ids))
+	for i, id := range ids {
+		hexes[i] = id.Hex()
+	}
+	return hexes
+}
+
+// page appends LIMIT and OFFSET. Some databases only take an offset after a limit,
+// so skipping without a limit uses the largest one.
+func page(query string, skip int64, limit int64) string {
+	if skip <= 0 && limit <= 0 {
+		return query
+	}
+	if limit <= 0 {
+		limit = math.MaxInt64
+	}
+	if skip < 0 {
+		skip = 0
+	}
+	return query + fmt.Sprintf(" LIMIT %d OFFSET %d", limit, skip)
+}
+
+// Users
+
+const userColumns = `id, username, firstname, lastname, email, email_verified, password, timezone,
+	calendar_token, failed_logins, locked_until, sessions_valid_after,
+	totp_secret, pending_totp_secret, totp_last_step`
+
+func (s *sqlStore) getUser(ctx context.Context, where string, args ...interface{}) (model.User, error) {
+	var user model.User
+	err := s.get(ctx, "users", "SELECT "+userColumns+" FROM users WHERE "+where, args,
+		hexID{&user.OID}, &user.Username, &user.FirstName, &user.LastName, textOf{&user.Email},
+		&user.EmailVerified, &user.Password, &user.Preferences.TimeZone,
+		textOf{&user.CalendarToken}, &user.FailedLogins, timeOf{&user.LockedUntil}, timeOf{&user.SessionsValidAfter},
+		&user.TOTPSecret, &user.PendingTOTPSecret, &user.TOTPLastStep)
+	return user, err
+}
+
+func (s *sqlStore) CreateUser(ctx context.Context, user model.User) (primitive.ObjectID, error) {
+	id := primitive.NewObjectID()
+	_, err := s.exec(ctx, "users", `INSERT INTO users (`+userColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)`,
+		id.Hex(), user.Username, user.FirstName, user.LastName, nullString(user.Email),
+		user.EmailVerified, user.Password, user.Preferences.TimeZone,
+		nullString(user.CalendarToken), user.FailedLogins, nullTime(user.LockedUntil), nullTime(user.SessionsValidAfter),
+		user.TOTPSecret, user.PendingTOTPSecret, user.TOTPLastStep)
+	if err != nil && s.dialect.isDuplicate(err) {
+		if strings.Contains(err.Error(), emailIndex) {
+			return primitive.NilObjectID, ErrEmailTaken
+		}
+		return primitive.NilObjectID, ErrDuplicate
+	}
+	return id, err
+}
+
+func (s *sqlStore) GetUser(ctx context.Context, id primitive.ObjectID) (model.User, error) {
+	return s.getUser(ctx, "id = $1", id.Hex())
+}
+
+func (s *sqlStore) GetUserByUsername(ctx context.Context, username string) (model.User, error) {
+	return s.getUser(ctx, "lower(username) = lower($1)", username)
+}
+
+func (s *sqlStore) GetUserByEmail(ctx context.Context, email string) (model.User, error) {
+	return s.getUser(ctx, "lower(email) = lower($1)", email)
+}
+
+func (s *sqlStore) GetUserByCalendarToken(ctx context.Context, token string) (model.User, error) {
+	return s.getUser(ctx, "calendar_token = $1", token)
+}
+
+func (s *sqlStore) SetCalendarToken(ctx context.Context, userID primitive.ObjectID, token string) error {
+	_, err := s.exec(ctx, "users", "UPDATE users SET calendar_token = $1 WHERE id = $2", token, userID.Hex())
+	return err
+}
+
+func (s *sqlStore) UpdateAccount(ctx context.Context, userID primitive.ObjectID, update model.AccountUpdate) error {
+	var sets []string
+	var args []interface{}
+	set := func(column string, value interface{}) {
+		args = append(args, value)
+		sets = append(sets, fmt.Sprintf("%s = $%d", column, len(args)))
+	}
+
+	if update.FirstName != nil {
+		set("firstname", *update.FirstName)
+	}
+	if update.LastName != nil {
+		set("lastname", *update.LastName)
+	}
+	if update.Email != nil {
+		set("email", nullString(*update.Email))
+		set("email_verified", false)
+	}
+	if update.Preferences != nil {
+		set("timezone", update.Preferences.TimeZone)
+	}
+	if len(sets) == 0 {
+		return nil
+	}
+
+	args = append(args, userID.Hex())
+	query := fmt.Sprintf("UPDATE users SET %s WHERE id = $%d", strings.Join(sets, ", "), len(args))
+	_, err := s.exec(ctx, "users", query, args...)
+	if err != nil && s.dialect.isDuplicate(err) {
+		return ErrEmailTaken
+	}
+	return err
+}
+
+func (s *sqlStore) VerifyEmail(ctx context.Context, userID primitive.ObjectID, email string) (bool, error) {
+	matched, err := s.exec(ctx, "users",
+		"UPDATE users SET email_verified = $1 WHERE id = $2 AND email = $3", true, userID.Hex(), email)
+	return matched > 0, err
+}
+
+// DeleteAccount relies on the foreign keys to remove what the user owns
+func (s *sqlStore) DeleteAccount(ctx context.Context, userID primitive.ObjectID) error {
+	_, err := s.exec(ctx, "users", "DELETE FROM users WHERE id = $1", userID.Hex())
+	return err
+}
+
+// Passwords and logins
+
+func (s *sqlStore) SetPassword(ctx context.Context, userID primitive.ObjectID, hash string, sessionsValidAfter time.Time) error {
+	_, err := s.exec(ctx, "users",
+		`UPDATE users SET password = $1, sessions_valid_after = $2, failed_logins = 0, locked_until = NULL
+		WHERE id = $3`,
+		hash, sessionsValidAfter.UTC(), userID.Hex())
+	return err
+}
+
+func (s *sqlStore) RehashPassword(ctx context.Context, userID primitive.ObjectID, old string, hash string) error {
+	_, err := s.exec(ctx, "users",
+		"UPDATE users SET password = $1 WHERE id = $2 AND password = $3", hash, userID.Hex(), old)
+	return err
+}
+
+// CreatePasswordReset also drops the expired links of everyone, which mongo leaves to a TTL index
+func (s *sqlStore) CreatePasswordReset(ctx context.Context, reset model.PasswordResetToken) error {
+	return s.inTx(ctx, func(tx *sqlStore) error {
+		_, err := tx.exec(ctx, "password_resets",
+			"DELETE FROM password_resets WHERE user_id = $1 OR expires_at < $2", reset.UserID.Hex(), time.Now().UTC())
+		if err != nil {
+			return err
+		}
+		_, err = tx.exec(ctx, "password_resets",
+			"INSERT INTO password_resets (id, user_id, token_hash, expires_at) VALUES ($1, $2, $3, $4)",
+			primitive.NewObjectID().Hex(), reset.UserID.Hex(), reset.TokenHash, reset.ExpiresAt.UTC())
+		return err
+	})
+}
+
+func (s *sqlStore) GetPasswordReset(ctx context.Context, tokenHash string) (primitive.ObjectID, error) {
+	var userID primitive.ObjectID
+	err := s.get(ctx, "password_resets",
+		"SELECT user_id FROM password_resets WHERE token_hash = $1 AND expires_at > $2",
+		[]interface{}{tokenHash, time.Now().UTC()}, hexID{&userID})
+	return userID, err
+}
+
+func (s *sqlStore) UsePasswordReset(ctx context.Context, tokenHash string) (primitive.ObjectID, error) {
+	var userID primitive.ObjectID
+	err := s.get(ctx, "password_resets",
+		"DELETE FROM password_resets WHERE token_hash = $1 AND expires_at > $2 RETURNING user_id",
+		[]interface{}{tokenHash, time.Now().UTC()}, hexID{&userID})
+	return userID, err
+}
+
+func (s *sqlStore) RecordLoginFailure(ctx context.Context, userID primitive.ObjectID, threshold int, lockout time.Duration) (int, error) {
+	var failures int
+	err := s.get(ctx, "users", "UPDATE users SET failed_logins = failed_logins + 1 WHERE id = $1 RETURNING failed_logins",
+		[]interface{}{userID.Hex()}, &failures)
+	if err != nil || failures < threshold {
+		return failures, err
+	}
+
+	_, err = s.exec(ctx, "users", "UPDATE users SET locked_until = $1, failed_logins = 0 WHERE id = $2",
+		time.Now().Add(lockout).UTC(), userID.Hex())
+	return failures, err
+}
+
+func (s *sqlStore) RecordLoginSuccess(ctx context.Context, userID primitive.ObjectID) error {
+	_, err := s.exec(ctx, "users",
+		`UPDATE users SET failed_logins = 0, locked_until = NULL
+		WHERE id = $1 AND (failed_logins <> 0 OR locked_until IS NOT NULL)`, userID.Hex())
+	return err
+}
+
+func (s *sqlStore) AuditLogin(ctx context.Context, attempt model.LoginAttempt) error {
+	_, err := s.exec(ctx, "login_audit",
+		`INSERT INTO login_audit (id, user_id, username, success, reason, ip, user_agent, request_id, time)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		primitive.NewObjectID().Hex(), nullID(attempt.UserID), attempt.Username, attempt.Success,
+		nullString(attempt.Reason), attempt.IP, attempt.UserAgent, attempt.RequestID, attempt.Time.UTC())
+	return err
+}
+
+// Two-factor authentication
+
+func (s *sqlStore) SetPendingTOTP(ctx context.Context, userID primitive.ObjectID, secret string) error {
+	_, err := s.exec(ctx, "users", "UPDATE users SET pending_totp_secret = $1 WHERE id = $2", secret, userID.Hex())
+	return err
+}
+
+func (s *sqlStore) EnableTOTP(ctx context.Context, userID primitive.ObjectID, secret string, step int64, recoveryHashes []string) (bool, error) {
+	var matched int64
+	err := s.inTx(ctx, func(tx *sqlStore) error {
+		var err error
+		matched, err = tx.exec(ctx, "users",
+			`UPDATE users SET totp_secret = $1, totp_last_step = $2, pending_totp_secret = ''
+			WHERE id = $3 AND pending_totp_secret = $1 AND pending_totp_secret <> ''`,
+			secret, step, userID.Hex())
+		if err != nil || matched == 0 {
+			return err
+		}
+		return tx.replaceRecoveryCodes(ctx, userID, recoveryHashes)
+	})
+	return matched > 0, err
+}
+
+func (s *sqlStore) replaceRecoveryCodes(ctx context.Context, userID primitive.ObjectID, hashes []string) error {
+	if _, err := s.exec(ctx, "recovery_codes", "DELETE FROM recovery_codes WHERE user_id = $1", userID.Hex()); err != nil {
+		return err
+	}
+	for _, hash := range hashes {
+		_, err := s.exec(ctx, "recovery_codes",
+			"INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2)", userID.Hex(), hash)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *sqlStore) DisableTOTP(ctx context.Context, userID primitive.ObjectID) error {
+	return s.inTx(ctx, func(tx *sqlStore) error {
+		_, err := tx.exec(ctx, "users",
+			"UPDATE users SET totp_secret = '', pending_totp_secret = '', totp_last_step = 0 WHERE id = $1", userID.Hex())
+		if err != nil {
+			return err
+		}
+		return tx.replaceRecoveryCodes(ctx, userID, nil)
+	})
+}
+
+func (s *sqlStore) UseTOTPStep(ctx context.Context, userID primitive.ObjectID, step int64) (bool, error) {
+	matched, err := s.exec(ctx, "users",
+		"UPDATE users SET totp_last_step = $1 WHERE id = $2 AND totp_last_step < $1", step, userID.Hex())
+	return matched > 0, err
+}
+
+func (s *sqlStore) UseRecoveryCode(ctx context.Context, userID primitive.ObjectID, hash string) (bool, error) {
+	deleted, err := s.exec(ctx, "recovery_codes",
+		"DELETE FROM recovery_codes WHERE user_id = $1 AND code_hash = $2", userID.Hex(), hash)
+	return deleted > 0, err
+}
+
+// API keys
+
+const apiKeyColumns = "id, user_id, name, scope, logs_only, prefix, key_hash, created_at, last_used_at"
+
+func scanAPIKey(row interface{ Scan(...interface{}) error }) (model.APIKey, error) {
+	var key model.APIKey
+	var lastUsed time.Time
+	err := row.Scan(newID(&key.ID), hexID{&key.UserID}, &key.Name, &key.Scope, &key.LogsOnly,
+		&key.Prefix, &key.KeyHash, timeOf{&key.CreatedAt}, timeOf{&lastUsed})
+	if !lastUsed.IsZero() {
+		key.LastUsedAt = &lastUsed
+	}
+	return key, err
+}
+
+func (s *sqlStore) CreateAPIKey(ctx context.Context, key model.APIKey) (primitive.ObjectID, error) {
+	id := primitive.NewObjectID()
+	_, err := s.exec(ctx, "api_keys",
+		"INSERT INTO api_keys ("+apiKeyColumns+") VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)",
+		id.Hex(), key.UserID.Hex(), key.Name, key.Scope, key.LogsOnly, key.Prefix, key.KeyHash,
+		key.CreatedAt.UTC(), nil)
+	return id, err
+}
+
+func (s *sqlStore) GetAPIKeys(ctx context.Context, ownerID primitive.ObjectID) ([]*model.APIKey, error) {
+	keys := []*model.APIKey{}
+	err := s.each(ctx, "api_keys",
+		"SELECT "+apiKeyColumns+" FROM api_keys WHERE user_id = $1 ORDER BY created_at DESC, id DESC",
+		[]interface{}{ownerID.Hex()}, func(rows *sql.Rows) error {
+			key, err := scanAPIKey(rows)
+			keys = append(keys, &key)
+			return err
+		})
+	return keys, err
+}
+
+func (s *sqlStore) GetAPIKeyByHash(ctx context.Context, keyHash string) (model.APIKey, error) {
+	query := "SELECT " + apiKeyColumns + " FROM api_keys WHERE key_hash = $1"
+	done := s.observe(ctx, "api_keys", query)
+	key, err := scanAPIKey(s.q.QueryRowContext(ctx, query, keyHash))
+	done(err)
+	if err == sql.ErrNoRows {
+		return key, ErrNotFound
+	}
+	return key, err
+}
+
+func (s *sqlStore) TouchAPIKey(ctx context.Context, id primitive.ObjectID, t time.Time) error {
+	_, err := s.exec(ctx, "api_keys", "UPDATE api_keys SET last_used_at = $1 WHERE id = $2", t.UTC(), id.Hex())
+	return err
+}
+
+func (s *sqlStore) DeleteAPIKey(ctx context.Context, id primitive.ObjectID, ownerID primitive.ObjectID) (bool, error) {
+	return s.deleteOwned(ctx, "api_keys", id, ownerID)
+}
+
+// Logs
+
+// loadLogs reads the logs a query selects by id, in its order, with their habits
+// and the owner's habits of those names as habits_info
+func (s *sqlStore) loadLogs(ctx context.Context, query string, args []interface{}) ([]*model.Log, error) {
+	var results []*model.Log
+	byID := map[primitive.ObjectID]*model.Log{}
+	err := s.each(ctx, "logs", query, args, func(rows *sql.Rows) error {
+		// Like the lookup in mongo, which always adds the array
+		logEntry := &model.Log{HabitsInfo: []model.Habit{}}
+		if err := rows.Scan(newID(&logEntry.ID), hexID{&logEntry.UserID}, &logEntry.Entry); err != nil {
+			return err
+		}
+		results = append(results, logEntry)
+		byID[*logEntry.ID] = logEntry
+		return nil
+	})
+	if err != nil || len(results) == 0 {
+		return results, err
+	}
+
+	ids := make([]string, len(results))
+	for i, logEntry := range results {
+		ids[i] = logEntry.ID.Hex()
+	}
+	placeholders, idArgs := in(1, ids)
+
+	err = s.each(ctx, "log_habits",
+		"SELECT log_id, habit FROM log_habits WHERE log_id IN ("+placeholders+") ORDER BY log_id, position",
+		idArgs, func(rows *sql.Rows) error {
+			var logID primitive.ObjectID
+			var habit string
+			if err := rows.Scan(hexID{&logID}, &habit); err != nil {
+				return err
+			}
+			byID[logID].Habits = append(byID[logID].Habits, habit)
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.each(ctx, "habits", `SELECT DISTINCT l.id, h.id, h.user_id, h.name, h.description, h.identity_id
+		FROM logs l
+		JOIN log_habits lh ON lh.log_id = l.id
+		JOIN habits h ON h.user_id = l.user_id AND h.name = lh.habit
+		WHERE l.id IN (`+placeholders+`)
+		ORDER BY l.id, h.id`,
+		idArgs, func(rows *sql.Rows) error {
+			var logID primitive.ObjectID
+			var habit model.Habit
+			if err := rows.Scan(hexID{&logID}, newID(&habit.ID), hexID{&habit.UserID}, &habit.Name,
+				&habit.Description, hexID{&habit.IdentityID}); err != nil {
+				return err
+			}
+			byID[logID].HabitsInfo = append(byID[logID].HabitsInfo, habit)
+			return nil
+		})
+	return results, err
+}
+
+func (s *sqlStore) insertLogHabits(ctx context.Context, logID primitive.ObjectID, habits []string) error {
+	for i, habit := range habits {
+		_, err := s.exec(ctx, "log_habits",
+			"INSERT INTO log_habits (log_id, position, habit) VALUES ($1, $2, $3)", logID.Hex(), i, habit)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *sqlStore) CreateLog(ctx context.Context, logEntry model.Log) (primitive.ObjectID, error) {
+	id := primitive.NewObjectID()
+	err := s.inTx(ctx, func(tx *sqlStore) error {
+		_, err := tx.exec(ctx, "logs", "INSERT INTO logs (id, user_id, entry) VALUES ($1, $2, $3)",
+			id.Hex(), logEntry.UserID.Hex(), logEntry.Entry)
+		if err != nil {
+			return err
+		}
+		return tx.insertLogHabits(ctx, id, logEntry.Habits)
+	})
+	return id, err
+}
+
+func (s *sqlStore) GetLog(ctx context.Context, id primitive.ObjectID, ownerID primitive.ObjectID) (model.Log, error) {
+	results, err := s.loadLogs(ctx, "SELECT id, user_id, entry FROM logs WHERE id = $1 AND user_id = $2",
+		[]interface{}{id.Hex(), ownerID.Hex()})
+	if err != nil {
+		return model.Log{}, err
+	}
+	if len(results) == 0 {
+		return model.Log{}, ErrNotFound
+	}
+	return *results[0], nil
+}
+
+func (s *sqlStore) GetLogs(ctx context.Context, ownerID primitive.ObjectID) ([]*model.Log, error) {
+	return s.loadLogs(ctx, "SELECT id, user_id, entry FROM logs WHERE user_id = $1 ORDER BY id DESC",
+		[]interface{}{ownerID.Hex()})
+}
+
+func (s *sqlStore) FindLogs(ctx context.Context, ownerID primitive.ObjectID, filter LogFilter) ([]*model.Log, error) {
+	query := "SELECT id, user_id, entry FROM logs WHERE user_id = $1"
+	args := []interface{}{ownerID.Hex()}
+	if filter.Habits != nil {
+		if len(filter.Habits) == 0 {
+			return nil, nil
+		}
+		placeholders, habitArgs := in(2, filter.Habits)
+		query += " AND id IN (SELECT log_id FROM log_habits WHERE habit IN (" + placeholders + "))"
+		args = append(args, habitArgs...)
+	}
+	query = page(query+" ORDER BY id DESC", filter.Skip, filter.Limit)
+	return s.loadLogs(ctx, query, args)
+}
+
+func (s *sqlStore) GetRecentLogsByHabits(ctx context.Context, ownerID primitive.ObjectID, habits []string, limit int64) ([]*HabitLogs, error) {
+	if len(habits) == 0 {
+		return nil, nil
+	}
+	placeholders, args := in(2, habits)
+	args = append([]interface{}{ownerID.Hex()}, args...)
+
+	// The newest first, so the first limit of each habit are the ones to keep
+	var ids []string
+	logHabits := map[string][]string{}
+	kept := map[string]int64{}
+	err := s.each(ctx, "log_habits", `SELECT DISTINCT l.id, lh.habit
+		FROM logs l JOIN log_habits lh ON lh.log_id = l.id
+		WHERE l.user_id = $1 AND lh.habit IN (`+placeholders+`)
+		ORDER BY l.id DESC`,
+		args, func(rows *sql.Rows) error {
+			var id, habit string
+			if err := rows.Scan(&id, &habit); err != nil {
+				return err
+			}
+			if kept[habit] >= limit {
+				return nil
+			}
+			kept[habit]++
+			if _, ok := logHabits[id]; !ok {
+				ids = append(ids, id)
+			}
+			logHabits[id] = append(logHabits[id], habit)
+			return nil
+		})
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+
+	idPlaceholders, idArgs := in(1, ids)
+	logs, err := s.loadLogs(ctx, "SELECT id, user_id, entry FROM logs WHERE id IN ("+idPlaceholders+") ORDER BY id DESC", idArgs)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []*HabitLogs
+	byHabit := map[string]*HabitLogs{}
+	for _, logEntry := range logs {
+		for _, habit := range logHabits[logEntry.ID.Hex()] {
+			group, ok := byHabit[habit]
+			if !ok {
+				group = &HabitLogs{Habit: habit}
+				byHabit[habit] = group
+				results = append(results, group)
+			}
+			group.Logs = append(group.Logs, logEntry)
+		}
+	}
+	return results, nil
+}
+
+func (s *sqlStore) UpdateLog(ctx context.Context, id primitive.ObjectID, ownerID primitive.ObjectID, update LogUpdate) (bool, error) {
+	var matched int64
+	err := s.inTx(ctx, func(tx *sqlStore) error {
+		var err error
+		matched, err = tx.exec(ctx, "logs", "UPDATE logs SET entry = $1 WHERE id = $2 AND user_id = $3",
+			update.Entry, id.Hex(), ownerID.Hex())
+		if err != nil || matched == 0 || update.Habits == nil {
+			return err
+		}
+
+		if _, err := tx.exec(ctx, "log_habits", "DELETE FROM log_habits WHERE log_id = $1", id.Hex()); err != nil {
+			return err
+		}
+		return tx.insertLogHabits(ctx, id, update.Habits)
+	})
+	return matched > 0, err
+}
+
+func (s *sqlStore) DeleteLog(ctx context.Context, id primitive.ObjectID, ownerID primitive.ObjectID) (bool, error) {
+	return s.deleteOwned(ctx, "logs", id, ownerID)
+}
+
+// deleteOwned removes the row of table with id if it belongs to the owner.
+// It reports whether a row was removed.
+func (s *sqlStore) deleteOwned(ctx context.Context, table string, id primitive.ObjectID, ownerID primitive.ObjectID) (bool, error) {
+	deleted, err := s.exec(ctx, table, "DELETE FROM "+table+" WHERE id = $1 AND user_id = $2",
+		id.Hex(), ownerID.Hex())
+	return deleted > 0, err
+}
+
+// Habits
+
+const habitColumns = "id, user_id, name, description, identity_id"
+
+func (s *sqlStore) findHabits(ctx context.Context, query string, args []interface{}) ([]*model.Habit, error) {
+	var results []*model.Habit
+	err := s.each(ctx, "habits", query, args, func(rows *sql.Rows) error {
+		habit := &model.Habit{}
+		results = append(results, habit)
+		return rows.Scan(newID(&habit.ID), hexID{&habit.UserID}, &habit.Name, &habit.Description, hexID{&habit.IdentityID})
+	})
+	return results, err
+}
+
+func (s *sqlStore) CreateHabit(ctx context.Context, habit model.Habit) (primitive.ObjectID, error) {
+	id := primitive.NewObjectID()
+	_, err := s.exec(ctx, "habits",
+		"INSERT INTO habits ("+habitColumns+") VALUES ($1, $2, $3, $4, $5)",
+		id.Hex(), habit.UserID.Hex(), habit.Name, habit.Description, nullID(habit.IdentityID))
+	if err != nil && s.dialect.isDuplicate(err) {
+		return primitive.NilObjectID, ErrHabitNameTaken
+	}
+	return id, err
+}
+
+func (s *sqlStore) GetHabit(ctx context.Context, id primitive.ObjectID, ownerID primitive.ObjectID) (model.Habit, error) {
+	results, err := s.findHabits(ctx, "SELECT "+habitColumns+" FROM habits WHERE id = $1 AND user_id = $2",
+		[]interface{}{id.Hex(), ownerID.Hex()})
+	if err != nil {
+		return model.Habit{}, err
+	}
+	if len(results) == 0 {
+		return model.Habit{}, ErrNotFound
+	}
+	return *results[0], nil
+}
+
+func (s *sqlStore) GetHabits(ctx context.Context, ownerID primitive.ObjectID) ([]*model.Habit, error) {
+	return s.FindHabits(ctx, ownerID, HabitFilter{})
+}
+
+func (s *sqlStore) FindHabits(ctx context.Context, ownerID primitive.ObjectID, filter HabitFilter) ([]*model.Habit, error) {
+	query := "SELECT " + habitColumns + " FROM habits WHERE user_id = $1"
+	args := []interface{}{ownerID.Hex()}
+	if filter.IdentityIDs != nil {
+		if len(filter.IdentityIDs) == 0 {
+			return nil, nil
+		}
+		placeholders, idArgs := in(2, hexIDs(filter.IdentityIDs))
+		query += " AND identity_id IN (" + placeholders + ")"
+		args = append(args, idArgs...)
+	}
+	return s.findHabits(ctx, page(query+" ORDER BY id", filter.Skip, filter.Limit), args)
+}
+
+func (s *sqlStore) HabitNameTaken(ctx context.Context, ownerID primitive.ObjectID, name string, except *primitive.ObjectID) (bool, error) {
+	query := "SELECT COUNT(*) FROM habits WHERE user_id = $1 AND name = $2"
+	args := []interface{}{ownerID.Hex(), name}
+	if except != nil {
+		query += " AND id <> $3"
+		args = append(args, except.Hex())
+	}
+
+	var count int
+	err := s.get(ctx, "habits", query, args, &count)
+	return count > 0, err
+}
+
+func (s *sqlStore) UpdateHabit(ctx context.Context, id primitive.ObjectID, ownerID primitive.ObjectID, update HabitUpdate) (bool, error) {
+	query := "UPDATE habits SET name = $1"
+	args := []interface{}{update.Name}
+	if update.Description != nil {
+		args = append(args, *update.Description)
+		query += fmt.Sprintf(", description = $%d", len(args))
+	}
+	if update.IdentityID != nil {
+		args = append(args, nullID(*update.IdentityID))
+		query += fmt.Sprintf(", identity_id = $%d", len(args))
+	}
+	args = append(args, id.Hex(), ownerID.Hex())
+	query += fmt.Sprintf(" WHERE id = $%d AND user_id = $%d", len(args)-1, len(args))
+
+	matched, err := s.exec(ctx, "habits", query, args...)
+	if err != nil && s.dialect.isDuplicate(err) {
+		return false, ErrHabitNameTaken
+	}
+	return matched > 0, err
+}
+
+func (s *sqlStore) DeleteHabit(ctx context.Context, id primitive.ObjectID, ownerID primitive.ObjectID) (bool, error) {
+	return s.deleteOwned(ctx, "habits", id, ownerID)
+}
+
+// Identities
+
+const identityColumns = "id, user_id, name, description"
+
+func (s *sqlStore) findIdentities(ctx context.Context, query string, args []interface{}) ([]*model.Identity, error) {
+	var results []*model.Identity
+	err := s.each(ctx, "identities", query, args, func(rows *sql.Rows) error {
+		identity := &model.Identity{}
+		results = append(results, identity)
+		return rows.Scan(newID(&identity.ID), hexID{&identity.UserID}, &identity.Name, &identity.Description)
+	})
+	return results, err
+}
+
+func (s *sqlStore) CreateIdentity(ctx context.Context, identity model.Identity) (primitive.ObjectID, error) {
+	id := primitive.NewObjectID()
+	_, err := s.exec(ctx, "identities",
+		"INSERT INTO identities ("+identityColumns+") VALUES ($1, $2, $3, $4)",
+		id.Hex(), identity.UserID.Hex(), identity.Name, identity.Description)
+	return id, err
+}
+
+func (s *sqlStore) GetIdentity(ctx context.Context, id primitive.ObjectID, ownerID primitive.ObjectID) (model.Identity, error) {
+	results, err := s.findIdentities(ctx, "SELECT "+identityColumns+" FROM identities WHERE id = $1 AND user_id = $2",
+		[]interface{}{id.Hex(), ownerID.Hex()})
+	if err != nil {
+		return model.Identity{}, err
+	}
+	if len(results) == 0 {
+		return model.Identity{}, ErrNotFound
+	}
+	return *results[0], nil
+}
+
+func (s *sqlStore) GetIdentities(ctx context.Context, ownerID primitive.ObjectID) ([]*model.Identity, error) {
+	return s.FindIdentities(ctx, ownerID, IdentityFilter{})
+}
+
+func (s *sqlStore) FindIdentities(ctx context.Context, ownerID primitive.ObjectID, filter IdentityFilter) ([]*model.Identity, error) {
+	query := "SELECT " + identityColumns + " FROM identities WHERE user_id = $1"
+	args := []interface{}{ownerID.Hex()}
+	if filter.IDs != nil {
+		if len(filter.IDs) == 0 {
+			return nil, nil
+		}
+		placeholders, idArgs := in(2, hexIDs(filter.IDs))
+		query += " AND id IN (" + placeholders + ")"
+		args = append(args, idArgs...)
+	}
+	return s.findIdentities(ctx, page(query+" ORDER BY id", filter.Skip, filter.Limit), args)
+}
+
+func (s *sqlStore) UpdateIdentity(ctx context.Context, id primitive.ObjectID, ownerID primitive.ObjectID, update IdentityUpdate) (bool, error) {
+	query := "UPDATE identities SET name = $1"
+	args := []interface{}{update.Name}
+	if update.Description != nil {
+		args = append(args, *update.Description)
+		query += fmt.Sprintf(", description = $%d", len(args))
+	}
+	args = append(args, id.Hex(), ownerID.Hex())
+	query += fmt.Sprintf(" WHERE id = $%d AND user_id = $%d", len(args)-1, len(args))
+
+	matched, err := s.exec(ctx, "identities", query, args...)
+	return matched > 0, err
+}
+
+func (s *sqlStore) DeleteIdentity(ctx context.Context, id primitive.ObjectID, ownerID primitive.ObjectID) (bool, error) {
+	return s.deleteOwned(ctx, "identities", id, ownerID)
+}
@@ -0,0 +1,157 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"goplay/config"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	sqlitedriver "modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// sqlite keeps everything in a single file, for running without a database server.
+// The driver is pure Go, so the binary still builds with CGO_ENABLED=0.
+var sqlite = &dialect{
+	system: semconv.DBSystemSqlite,
+	// No lock, transactions take the write lock when they begin (see openSQLite)
+	isDuplicate: func(err error) bool {
+		var sqliteErr *sqlitedriver.Error
+		return errors.As(err, &sqliteErr) &&
+			(sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE || sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY)
+	},
+	migrations: []string{
+		// 1: the tables of postgres. The driver stores times as UTC text, which sorts in time order.
+		`CREATE TABLE users (
+			id CHAR(24) PRIMARY KEY,
+			username TEXT NOT NULL,
+			firstname TEXT NOT NULL DEFAULT '',
+			lastname TEXT NOT NULL DEFAULT '',
+			email TEXT,
+			email_verified BOOLEAN NOT NULL DEFAULT FALSE,
+			password TEXT NOT NULL,
+			timezone TEXT NOT NULL DEFAULT '',
+			calendar_token TEXT UNIQUE,
+			failed_logins INTEGER NOT NULL DEFAULT 0,
+			locked_until TIMESTAMP,
+			sessions_valid_after TIMESTAMP,
+			totp_secret TEXT NOT NULL DEFAULT '',
+			pending_totp_secret TEXT NOT NULL DEFAULT '',
+			totp_last_step INTEGER NOT NULL DEFAULT 0
+		);
+		-- lower() only folds ASCII, which is all usernames may contain
+		CREATE UNIQUE INDEX users_username_ci ON users (lower(username));
+		CREATE INDEX users_email ON users (email);
+
+		CREATE TABLE recovery_codes (
+			user_id CHAR(24) NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+			code_hash TEXT NOT NULL,
+			PRIMARY KEY (user_id, code_hash)
+		);
+
+		CREATE TABLE identities (
+			id CHAR(24) PRIMARY KEY,
+			user_id CHAR(24) NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+			name TEXT NOT NULL,
+			description TEXT NOT NULL DEFAULT ''
+		);
+		CREATE INDEX identities_user_id ON identities (user_id);
+
+		-- identity_id isn't a foreign key, deleting an identity leaves its habits as they are
+		CREATE TABLE habits (
+			id CHAR(24) PRIMARY KEY,
+			user_id CHAR(24) NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+			name TEXT NOT NULL,
+			description TEXT NOT NULL DEFAULT '',
+			identity_id CHAR(24)
+		);
+		CREATE INDEX habits_user_id_name ON habits (user_id, name);
+
+		CREATE TABLE logs (
+			id CHAR(24) PRIMARY KEY,
+			user_id CHAR(24) NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+			entry TEXT NOT NULL
+		);
+		CREATE INDEX logs_user_id ON logs (user_id);
+
+		CREATE TABLE log_habits (
+			log_id CHAR(24) NOT NULL REFERENCES logs (id) ON DELETE CASCADE,
+			position INTEGER NOT NULL,
+			habit TEXT NOT NULL,
+			PRIMARY KEY (log_id, position)
+		);
+		CREATE INDEX log_habits_habit ON log_habits (habit);
+
+		CREATE TABLE api_keys (
+			id CHAR(24) PRIMARY KEY,
+			user_id CHAR(24) NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+			name TEXT NOT NULL,
+			scope TEXT NOT NULL,
+			logs_only BOOLEAN NOT NULL DEFAULT FALSE,
+			prefix TEXT NOT NULL,
+			key_hash TEXT NOT NULL UNIQUE,
+			created_at TIMESTAMP NOT NULL,
+			last_used_at TIMESTAMP
+		);
+		CREATE INDEX api_keys_user_id ON api_keys (user_id);
+
+		CREATE TABLE password_resets (
+			id CHAR(24) PRIMARY KEY,
+			user_id CHAR(24) NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+			token_hash TEXT NOT NULL,
+			expires_at TIMESTAMP NOT NULL
+		);
+		CREATE INDEX password_resets_token_hash ON password_resets (token_hash);
+
+		CREATE TABLE login_audit (
+			id CHAR(24) PRIMARY KEY,
+			user_id CHAR(24),
+			username TEXT NOT NULL,
+			success BOOLEAN NOT NULL,
+			reason TEXT,
+			ip TEXT NOT NULL,
+			user_agent TEXT NOT NULL,
+			request_id TEXT NOT NULL,
+			time TIMESTAMP NOT NULL
+		);`,
+		// 2: reset links go to the email address, so it belongs to one account, ignoring case
+		`DROP INDEX users_email;
+		CREATE UNIQUE INDEX users_email_ci ON users (lower(email));`,
+		// 3: logs refer to habits by name, so the names of an owner's habits are unique. Habits
+		// created before it was checked keep their name with their id added.
+		`UPDATE habits SET name = name || ' (' || id || ')'
+			WHERE EXISTS (SELECT 1 FROM habits h WHERE h.user_id = habits.user_id AND h.name = habits.name AND h.id < habits.id);
+		DROP INDEX habits_user_id_name;
+		CREATE UNIQUE INDEX habits_user_id_name ON habits (user_id, name);`,
+	},
+}
+
+func openSQLite(ctx context.Context, cfg config.Database) (Store, error) {
+	if err := os.MkdirAll(filepath.Dir(cfg.Data), 0700); err != nil {
+		return nil, err
+	}
+
+	// Foreign keys are off unless asked for on every connection. Beginning transactions
+	// immediately takes the write lock up front, so two of them can't deadlock upgrading
+	// their read locks, and other processes wait for it instead of failing.
+	dsn := "file:" + (&url.URL{Path: cfg.Data}).EscapedPath() +
+		"?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_txlock=immediate"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite writes one at a time anyway
+	db.SetMaxOpenConns(1)
+
+	ctx, cancel := context.WithTimeout(ctx, attemptTimeout)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return newSQLStore(db, sqlite), nil
+}
@@ -0,0 +1,14 @@
+package database_test
+
+import (
+	"goplay/config"
+	"path/filepath"
+	"testing"
+)
+
+func TestSQLiteConformance(t *testing.T) {
+	cfg := config.Default().Database
+	cfg.Driver = "sqlite"
+	cfg.Data = filepath.Join(t.TempDir(), "goplay.db")
+	testConformance(t, cfg)
+}
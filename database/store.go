@@ -0,0 +1,561 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"goplay/metrics"
+	"goplay/model"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ErrNotFound is returned when nothing matches a lookup. It is mongo's error,
+// so comparing against either works whatever the backend.
+var ErrNotFound = mongo.ErrNoDocuments
+
+// ErrDuplicate is returned when an insert breaks a uniqueness rule, e.g. a taken username
+var ErrDuplicate = errors.New("database: duplicate key")
+
+// ErrEmailTaken is returned when a user would get the email address of another one, ignoring case
+var ErrEmailTaken = errors.New("database: email address already used")
+
+// ErrHabitNameTaken is returned when an owner would have two habits with the same name
+var ErrHabitNameTaken = errors.New("database: habit name already used")
+
+// emailIndex keeps email addresses unique ignoring case on every backend. The drivers name it
+// in their errors, telling a taken email apart from a taken username.
+const emailIndex = "users_email_ci"
+
+// Store is the data access of a storage backend. The functions of this package run
+// on the store Open selected, each within its deadline; see the function of the same name
+// for what each method does.
+// Lookups return ErrNotFound when nothing matches and ids are mongo object ids on every backend.
+type Store interface {
+	Ping(ctx context.Context) error
+	Close(ctx context.Context) error
+	EnsureSchema(ctx context.Context) error
+
+	CreateUser(ctx context.Context, user model.User) (primitive.ObjectID, error)
+	GetUser(ctx context.Context, id primitive.ObjectID) (model.User, error)
+	GetUserByUsername(ctx context.Context, username string) (model.User, error)
+	GetUserByEmail(ctx context.Context, email string) (model.User, error)
+	GetUserByCalendarToken(ctx context.Context, token string) (model.User, error)
+	SetCalendarToken(ctx context.Context, userID primitive.ObjectID, token string) error
+	UpdateAccount(ctx context.Context, userID primitive.ObjectID, update model.AccountUpdate) error
+	VerifyEmail(ctx context.Context, userID primitive.ObjectID, email string) (bool, error)
+	DeleteAccount(ctx context.Context, userID primitive.ObjectID) error
+
+	SetPassword(ctx context.Context, userID primitive.ObjectID, hash string, sessionsValidAfter time.Time) error
+	RehashPassword(ctx context.Context, userID primitive.ObjectID, old string, hash string) error
+	CreatePasswordReset(ctx context.Context, reset model.PasswordResetToken) error
+	GetPasswordReset(ctx context.Context, tokenHash string) (primitive.ObjectID, error)
+	UsePasswordReset(ctx context.Context, tokenHash string) (primitive.ObjectID, error)
+
+	RecordLoginFailure(ctx context.Context, userID primitive.ObjectID, threshold int, lockout time.Duration) (int, error)
+	RecordLoginSuccess(ctx context.Context, userID primitive.ObjectID) error
+	AuditLogin(ctx context.Context, attempt model.LoginAttempt) error
+
+	SetPendingTOTP(ctx context.Context, userID primitive.ObjectID, secret string) error
+	EnableTOTP(ctx context.Context, userID primitive.ObjectID, secret string, step int64, recoveryHashes []string) (bool, error)
+	DisableTOTP(ctx context.Context, userID primitive.ObjectID) error
+	UseTOTPStep(ctx context.Context, userID primitive.ObjectID, step int64) (bool, error)
+	UseRecoveryCode(ctx context.Context, userID primitive.ObjectID, hash string) (bool, error)
+
+	CreateAPIKey(ctx context.Context, key model.APIKey) (primitive.ObjectID, error)
+	GetAPIKeys(ctx context.Context, ownerID primitive.ObjectID) ([]*model.APIKey, error)
+	GetAPIKeyByHash(ctx context.Context, keyHash string) (model.APIKey, error)
+	TouchAPIKey(ctx context.Context, id primitive.ObjectID, t time.Time) error
+	DeleteAPIKey(ctx context.Context, id primitive.ObjectID, ownerID primitive.ObjectID) (bool, error)
+
+	CreateLog(ctx context.Context, logEntry model.Log) (primitive.ObjectID, error)
+	GetLog(ctx context.Context, id primitive.ObjectID, ownerID primitive.ObjectID) (model.Log, error)
+	GetLogs(ctx context.Context, ownerID primitive.ObjectID) ([]*model.Log, error)
+	FindLogs(ctx context.Context, ownerID primitive.ObjectID, filter LogFilter) ([]*model.Log, error)
+	GetRecentLogsByHabits(ctx context.Context, ownerID primitive.ObjectID, habits []string, limit int64) ([]*HabitLogs, error)
+	UpdateLog(ctx context.Context, id primitive.ObjectID, ownerID primitive.ObjectID, update LogUpdate) (bool, error)
+	DeleteLog(ctx context.Context, id primitive.ObjectID, ownerID primitive.ObjectID) (bool, error)
+
+	CreateHabit(ctx context.Context, habit model.Habit) (primitive.ObjectID, error)
+	GetHabit(ctx context.Context, id primitive.ObjectID, ownerID primitive.ObjectID) (model.Habit, error)
+	GetHabits(ctx context.Context, ownerID primitive.ObjectID) ([]*model.Habit, error)
+	FindHabits(ctx context.Context, ownerID primitive.ObjectID, filter HabitFilter) ([]*model.Habit, error)
+	HabitNameTaken(ctx context.Context, ownerID primitive.ObjectID, name string, except *primitive.ObjectID) (bool, error)
+	UpdateHabit(ctx context.Context, id primitive.ObjectID, ownerID primitive.ObjectID, update HabitUpdate) (bool, error)
+	DeleteHabit(ctx context.Context, id primitive.ObjectID, ownerID primitive.ObjectID) (bool, error)
+
+	CreateIdentity(ctx context.Context, identity model.Identity) (primitive.ObjectID, error)
+	GetIdentity(ctx context.Context, id primitive.ObjectID, ownerID primitive.ObjectID) (model.Identity, error)
+	GetIdentities(ctx context.Context, ownerID primitive.ObjectID) ([]*model.Identity, error)
+	FindIdentities(ctx context.Context, ownerID primitive.ObjectID, filter IdentityFilter) ([]*model.Identity, error)
+	UpdateIdentity(ctx context.Context, id primitive.ObjectID, ownerID primitive.ObjectID, update IdentityUpdate) (bool, error)
+	DeleteIdentity(ctx context.Context, id primitive.ObjectID, ownerID primitive.ObjectID) (bool, error)
+}
+
+// store is the backend Open selected
+var store Store
+
+// Current returns the store Open selected, nil before
+func Current() Store {
+	return store
+}
+
+// LogFilter narrows down and pages the logs returned by FindLogs
+type LogFilter struct {
+	// Habits keeps logs tagged with any of the habit names
+	Habits []string
+	Skip   int64
+	Limit  int64
+}
+
+// HabitFilter narrows down and pages the habits returned by FindHabits
+type HabitFilter struct {
+	// IdentityIDs keeps habits belonging to any of the identities
+	IdentityIDs []primitive.ObjectID
+	Skip        int64
+	Limit       int64
+}
+
+// IdentityFilter narrows down and pages the identities returned by FindIdentities
+type IdentityFilter struct {
+	IDs   []primitive.ObjectID
+	Skip  int64
+	Limit int64
+}
+
+// HabitLogs are the most recent logs tagged with a habit
+type HabitLogs struct {
+	Habit string       `bson:"_id"`
+	Logs  []*model.Log `bson:"logs"`
+}
+
+// LogUpdate is what UpdateLog changes. Nil habits are left as they are.
+type LogUpdate struct {
+	Entry  string
+	Habits []string
+}
+
+// HabitUpdate is what UpdateHabit changes. Nil fields are left as they are.
+type HabitUpdate struct {
+	Name        string
+	Description *string
+	IdentityID  *primitive.ObjectID
+}
+
+// IdentityUpdate is what UpdateIdentity changes. A nil description is left as it is.
+type IdentityUpdate struct {
+	Name        string
+	Description *string
+}
+
+// Ping checks that the database answers
+func Ping(ctx context.Context) error {
+	if store == nil {
+		return errors.New("not connected")
+	}
+	return store.Ping(ctx)
+}
+
+// Close lets go of the database, waiting for in-flight operations until ctx is done
+func Close(ctx context.Context) error {
+	if store == nil {
+		return nil
+	}
+	return store.Close(ctx)
+}
+
+// EnsureSchema creates the tables, on the backends that have them, and the indexes the queries
+// and uniqueness rules rely on. What exists is left alone, so it is safe to run on every start.
+func EnsureSchema(ctx context.Context) error {
+	return store.EnsureSchema(ctx)
+}
+
+// CreateUser inserts a new user and returns its id. It fails with ErrDuplicate when
+// the username is taken and with ErrEmailTaken when the email is, both ignoring case.
+func CreateUser(ctx context.Context, user model.User) (primitive.ObjectID, error) {
+	ctx, cancel := withDeadline(ctx, "create_user")
+	defer cancel()
+	result, err := store.CreateUser(ctx, user)
+	return result, interrupted(ctx, err)
+}
+
+// GetUser finds a user by id
+func GetUser(ctx context.Context, id primitive.ObjectID) (model.User, error) {
+	ctx, cancel := withDeadline(ctx, "get_user")
+	defer cancel()
+	result, err := store.GetUser(ctx, id)
+	return result, interrupted(ctx, err)
+}
+
+// GetUserByUsername finds a user by username, ignoring case
+func GetUserByUsername(ctx context.Context, username string) (model.User, error) {
+	ctx, cancel := withDeadline(ctx, "get_user_by_username")
+	defer cancel()
+	result, err := store.GetUserByUsername(ctx, username)
+	return result, interrupted(ctx, err)
+}
+
+// GetUserByEmail finds the user with an email address, ignoring case
+func GetUserByEmail(ctx context.Context, email string) (model.User, error) {
+	ctx, cancel := withDeadline(ctx, "get_user_by_email")
+	defer cancel()
+	result, err := store.GetUserByEmail(ctx, email)
+	return result, interrupted(ctx, err)
+}
+
+// GetUserByCalendarToken finds the owner of a calendar feed token
+func GetUserByCalendarToken(ctx context.Context, token string) (model.User, error) {
+	ctx, cancel := withDeadline(ctx, "get_user_by_calendar_token")
+	defer cancel()
+	result, err := store.GetUserByCalendarToken(ctx, token)
+	return result, interrupted(ctx, err)
+}
+
+// SetCalendarToken replaces the calendar feed token of a user
+func SetCalendarToken(ctx context.Context, userID primitive.ObjectID, token string) error {
+	ctx, cancel := withDeadline(ctx, "set_calendar_token")
+	defer cancel()
+	return interrupted(ctx, store.SetCalendarToken(ctx, userID, token))
+}
+
+// UpdateAccount applies the fields of update that are set to a user. A new email starts unverified;
+// it fails with ErrEmailTaken when another user has it.
+func UpdateAccount(ctx context.Context, userID primitive.ObjectID, update model.AccountUpdate) error {
+	ctx, cancel := withDeadline(ctx, "update_account")
+	defer cancel()
+	return interrupted(ctx, store.UpdateAccount(ctx, userID, update))
+}
+
+// VerifyEmail marks the email of a user verified, unless it changed from email.
+// It reports false when it did.
+func VerifyEmail(ctx context.Context, userID primitive.ObjectID, email string) (bool, error) {
+	ctx, cancel := withDeadline(ctx, "verify_email")
+	defer cancel()
+	result, err := store.VerifyEmail(ctx, userID, email)
+	return result, interrupted(ctx, err)
+}
+
+// DeleteAccount removes a user with everything they own. The user goes last,
+// so an account that failed to delete halfway can be deleted again. The login audit is kept.
+func DeleteAccount(ctx context.Context, userID primitive.ObjectID) error {
+	ctx, cancel := withDeadline(ctx, "delete_account")
+	defer cancel()
+	return interrupted(ctx, store.DeleteAccount(ctx, userID))
+}
+
+// SetPassword replaces the password hash of a user and revokes the tokens issued
+// up to sessionsValidAfter. It also lifts a lockout, since the user proved who they are.
+func SetPassword(ctx context.Context, userID primitive.ObjectID, hash string, sessionsValidAfter time.Time) error {
+	ctx, cancel := withDeadline(ctx, "set_password")
+	defer cancel()
+	return interrupted(ctx, store.SetPassword(ctx, userID, hash, sessionsValidAfter))
+}
+
+// RehashPassword replaces the password hash of a user with a stronger hash of the
+// same password, unless the password changed since old was read
+func RehashPassword(ctx context.Context, userID primitive.ObjectID, old string, hash string) error {
+	ctx, cancel := withDeadline(ctx, "rehash_password")
+	defer cancel()
+	return interrupted(ctx, store.RehashPassword(ctx, userID, old, hash))
+}
+
+// CreatePasswordReset stores a reset link, replacing the ones sent to the user before
+func CreatePasswordReset(ctx context.Context, reset model.PasswordResetToken) error {
+	ctx, cancel := withDeadline(ctx, "create_password_reset")
+	defer cancel()
+	return interrupted(ctx, store.CreatePasswordReset(ctx, reset))
+}
+
+// GetPasswordReset returns whose password the unexpired reset link with the token hash resets
+func GetPasswordReset(ctx context.Context, tokenHash string) (primitive.ObjectID, error) {
+	ctx, cancel := withDeadline(ctx, "get_password_reset")
+	defer cancel()
+	result, err := store.GetPasswordReset(ctx, tokenHash)
+	return result, interrupted(ctx, err)
+}
+
+// UsePasswordReset consumes the unexpired reset link with the token hash and returns
+// whose password it resets. A link can only be used once.
+func UsePasswordReset(ctx context.Context, tokenHash string) (primitive.ObjectID, error) {
+	ctx, cancel := withDeadline(ctx, "use_password_reset")
+	defer cancel()
+	result, err := store.UsePasswordReset(ctx, tokenHash)
+	return result, interrupted(ctx, err)
+}
+
+// RecordLoginFailure counts a failed login of the user. Once threshold failures in a row
+// are reached the user is locked for lockout and counting starts over.
+// It returns the failures in a row, including the one that caused a lockout.
+func RecordLoginFailure(ctx context.Context, userID primitive.ObjectID, threshold int, lockout time.Duration) (int, error) {
+	ctx, cancel := withDeadline(ctx, "record_login_failure")
+	defer cancel()
+	result, err := store.RecordLoginFailure(ctx, userID, threshold, lockout)
+	return result, interrupted(ctx, err)
+}
+
+// RecordLoginSuccess clears the failed logins and lockout of the user
+func RecordLoginSuccess(ctx context.Context, userID primitive.ObjectID) error {
+	ctx, cancel := withDeadline(ctx, "record_login_success")
+	defer cancel()
+	return interrupted(ctx, store.RecordLoginSuccess(ctx, userID))
+}
+
+// AuditLogin stores the record of a login attempt
+func AuditLogin(ctx context.Context, attempt model.LoginAttempt) error {
+	ctx, cancel := withDeadline(ctx, "audit_login")
+	defer cancel()
+	return interrupted(ctx, store.AuditLogin(ctx, attempt))
+}
+
+// SetPendingTOTP stores a secret that turns on two-factor authentication once confirmed
+// with a code. It replaces an unconfirmed one.
+func SetPendingTOTP(ctx context.Context, userID primitive.ObjectID, secret string) error {
+	ctx, cancel := withDeadline(ctx, "set_pending_totp")
+	defer cancel()
+	return interrupted(ctx, store.SetPendingTOTP(ctx, userID, secret))
+}
+
+// EnableTOTP turns on two-factor authentication with the pending secret and replaces the
+// recovery codes. It reports false when secret is no longer the pending one.
+func EnableTOTP(ctx context.Context, userID primitive.ObjectID, secret string, step int64, recoveryHashes []string) (bool, error) {
+	ctx, cancel := withDeadline(ctx, "enable_totp")
+	defer cancel()
+	result, err := store.EnableTOTP(ctx, userID, secret, step, recoveryHashes)
+	return result, interrupted(ctx, err)
+}
+
+// DisableTOTP turns off two-factor authentication and drops the recovery codes
+func DisableTOTP(ctx context.Context, userID primitive.ObjectID) error {
+	ctx, cancel := withDeadline(ctx, "disable_totp")
+	defer cancel()
+	return interrupted(ctx, store.DisableTOTP(ctx, userID))
+}
+
+// UseTOTPStep records that a code of the time step was accepted. It reports false when
+// a code of this or a later step was already used, i.e. the code is replayed.
+func UseTOTPStep(ctx context.Context, userID primitive.ObjectID, step int64) (bool, error) {
+	ctx, cancel := withDeadline(ctx, "use_totp_step")
+	defer cancel()
+	result, err := store.UseTOTPStep(ctx, userID, step)
+	return result, interrupted(ctx, err)
+}
+
+// UseRecoveryCode removes the recovery code with the hash. It reports false when the
+// user has no such code left.
+func UseRecoveryCode(ctx context.Context, userID primitive.ObjectID, hash string) (bool, error) {
+	ctx, cancel := withDeadline(ctx, "use_recovery_code")
+	defer cancel()
+	result, err := store.UseRecoveryCode(ctx, userID, hash)
+	return result, interrupted(ctx, err)
+}
+
+// CreateAPIKey stores a new API key and returns its id
+func CreateAPIKey(ctx context.Context, key model.APIKey) (primitive.ObjectID, error) {
+	ctx, cancel := withDeadline(ctx, "create_api_key")
+	defer cancel()
+	result, err := store.CreateAPIKey(ctx, key)
+	return result, interrupted(ctx, err)
+}
+
+// GetAPIKeys lists the API keys of a user, newest first
+func GetAPIKeys(ctx context.Context, ownerID primitive.ObjectID) ([]*model.APIKey, error) {
+	ctx, cancel := withDeadline(ctx, "get_api_keys")
+	defer cancel()
+	result, err := store.GetAPIKeys(ctx, ownerID)
+	return result, interrupted(ctx, err)
+}
+
+// GetAPIKeyByHash finds the API key with the hash
+func GetAPIKeyByHash(ctx context.Context, keyHash string) (model.APIKey, error) {
+	ctx, cancel := withDeadline(ctx, "get_api_key_by_hash")
+	defer cancel()
+	result, err := store.GetAPIKeyByHash(ctx, keyHash)
+	return result, interrupted(ctx, err)
+}
+
+// TouchAPIKey records that an API key was used at t
+func TouchAPIKey(ctx context.Context, id primitive.ObjectID, t time.Time) error {
+	ctx, cancel := withDeadline(ctx, "touch_api_key")
+	defer cancel()
+	return interrupted(ctx, store.TouchAPIKey(ctx, id, t))
+}
+
+// DeleteAPIKey revokes the API key with id if it belongs to the owner.
+// It reports whether a key was removed.
+func DeleteAPIKey(ctx context.Context, id primitive.ObjectID, ownerID primitive.ObjectID) (bool, error) {
+	ctx, cancel := withDeadline(ctx, "delete_api_key")
+	defer cancel()
+	result, err := store.DeleteAPIKey(ctx, id, ownerID)
+	return result, interrupted(ctx, err)
+}
+
+// CreateLog stores a new log and returns its id
+func CreateLog(ctx context.Context, logEntry model.Log) (primitive.ObjectID, error) {
+	ctx, cancel := withDeadline(ctx, "create_log")
+	defer cancel()
+	id, err := store.CreateLog(ctx, logEntry)
+	if err == nil {
+		metrics.LogsCreated.Inc()
+	}
+	return id, interrupted(ctx, err)
+}
+
+// GetLog returns the log with id if it belongs to the owner, with the habits it is tagged with
+func GetLog(ctx context.Context, id primitive.ObjectID, ownerID primitive.ObjectID) (model.Log, error) {
+	ctx, cancel := withDeadline(ctx, "get_log")
+	defer cancel()
+	result, err := store.GetLog(ctx, id, ownerID)
+	return result, interrupted(ctx, err)
+}
+
+// GetLogs returns all the logs of the owner with the habits they are tagged with
+func GetLogs(ctx context.Context, ownerID primitive.ObjectID) ([]*model.Log, error) {
+	ctx, cancel := withDeadline(ctx, "get_logs")
+	defer cancel()
+	result, err := store.GetLogs(ctx, ownerID)
+	return result, interrupted(ctx, err)
+}
+
+// FindLogs returns the owner's logs matching the filter, newest first
+func FindLogs(ctx context.Context, ownerID primitive.ObjectID, filter LogFilter) ([]*model.Log, error) {
+	ctx, cancel := withDeadline(ctx, "find_logs")
+	defer cancel()
+	result, err := store.FindLogs(ctx, ownerID, filter)
+	return result, interrupted(ctx, err)
+}
+
+// GetRecentLogsByHabits returns up to limit of the newest logs for each habit name in a single query
+func GetRecentLogsByHabits(ctx context.Context, ownerID primitive.ObjectID, habits []string, limit int64) ([]*HabitLogs, error) {
+	ctx, cancel := withDeadline(ctx, "get_recent_logs_by_habits")
+	defer cancel()
+	result, err := store.GetRecentLogsByHabits(ctx, ownerID, habits, limit)
+	return result, interrupted(ctx, err)
+}
+
+// UpdateLog changes the log with id if it belongs to the owner. It reports whether a log matched.
+func UpdateLog(ctx context.Context, id primitive.ObjectID, ownerID primitive.ObjectID, update LogUpdate) (bool, error) {
+	ctx, cancel := withDeadline(ctx, "update_log")
+	defer cancel()
+	result, err := store.UpdateLog(ctx, id, ownerID, update)
+	return result, interrupted(ctx, err)
+}
+
+// DeleteLog removes the log with id if it belongs to the owner. It reports whether a log was removed.
+func DeleteLog(ctx context.Context, id primitive.ObjectID, ownerID primitive.ObjectID) (bool, error) {
+	ctx, cancel := withDeadline(ctx, "delete_log")
+	defer cancel()
+	result, err := store.DeleteLog(ctx, id, ownerID)
+	return result, interrupted(ctx, err)
+}
+
+// CreateHabit stores a new habit and returns its id. A unique index makes it fail with
+// ErrHabitNameTaken when the owner has a habit with the same name.
+func CreateHabit(ctx context.Context, habit model.Habit) (primitive.ObjectID, error) {
+	ctx, cancel := withDeadline(ctx, "create_habit")
+	defer cancel()
+	id, err := store.CreateHabit(ctx, habit)
+	if err == nil {
+		metrics.HabitsCreated.Inc()
+	}
+	return id, interrupted(ctx, err)
+}
+
+// GetHabit returns the habit with id if it belongs to the owner
+func GetHabit(ctx context.Context, id primitive.ObjectID, ownerID primitive.ObjectID) (model.Habit, error) {
+	ctx, cancel := withDeadline(ctx, "get_habit")
+	defer cancel()
+	result, err := store.GetHabit(ctx, id, ownerID)
+	return result, interrupted(ctx, err)
+}
+
+// GetHabits returns all the habits of the owner
+func GetHabits(ctx context.Context, ownerID primitive.ObjectID) ([]*model.Habit, error) {
+	ctx, cancel := withDeadline(ctx, "get_habits")
+	defer cancel()
+	result, err := store.GetHabits(ctx, ownerID)
+	return result, interrupted(ctx, err)
+}
+
+// FindHabits returns the owner's habits matching the filter
+func FindHabits(ctx context.Context, ownerID primitive.ObjectID, filter HabitFilter) ([]*model.Habit, error) {
+	ctx, cancel := withDeadline(ctx, "find_habits")
+	defer cancel()
+	result, err := store.FindHabits(ctx, ownerID, filter)
+	return result, interrupted(ctx, err)
+}
+
+// HabitNameTaken reports whether the owner has another habit than except called name
+func HabitNameTaken(ctx context.Context, ownerID primitive.ObjectID, name string, except *primitive.ObjectID) (bool, error) {
+	ctx, cancel := withDeadline(ctx, "habit_name_taken")
+	defer cancel()
+	result, err := store.HabitNameTaken(ctx, ownerID, name, except)
+	return result, interrupted(ctx, err)
+}
+
+// UpdateHabit changes the habit with id if it belongs to the owner. It reports whether a habit matched,
+// and fails with ErrHabitNameTaken when the owner has another habit with the new name.
+func UpdateHabit(ctx context.Context, id primitive.ObjectID, ownerID primitive.ObjectID, update HabitUpdate) (bool, error) {
+	ctx, cancel := withDeadline(ctx, "update_habit")
+	defer cancel()
+	result, err := store.UpdateHabit(ctx, id, ownerID, update)
+	return result, interrupted(ctx, err)
+}
+
+// DeleteHabit removes the habit with id if it belongs to the owner. It reports whether a habit was removed.
+func DeleteHabit(ctx context.Context, id primitive.ObjectID, ownerID primitive.ObjectID) (bool, error) {
+	ctx, cancel := withDeadline(ctx, "delete_habit")
+	defer cancel()
+	result, err := store.DeleteHabit(ctx, id, ownerID)
+	return result, interrupted(ctx, err)
+}
+
+// CreateIdentity stores a new identity and returns its id
+func CreateIdentity(ctx context.Context, identity model.Identity) (primitive.ObjectID, error) {
+	ctx, cancel := withDeadline(ctx, "create_identity")
+	defer cancel()
+	result, err := store.CreateIdentity(ctx, identity)
+	return result, interrupted(ctx, err)
+}
+
+// GetIdentity returns the identity with id if it belongs to the owner
+func GetIdentity(ctx context.Context, id primitive.ObjectID, ownerID primitive.ObjectID) (model.Identity, error) {
+	ctx, cancel := withDeadline(ctx, "get_identity")
+	defer cancel()
+	result, err := store.GetIdentity(ctx, id, ownerID)
+	return result, interrupted(ctx, err)
+}
+
+// GetIdentities returns all the identities of the owner
+func GetIdentities(ctx context.Context, ownerID primitive.ObjectID) ([]*model.Identity, error) {
+	ctx, cancel := withDeadline(ctx, "get_identities")
+	defer cancel()
+	result, err := store.GetIdentities(ctx, ownerID)
+	return result, interrupted(ctx, err)
+}
+
+// FindIdentities returns the owner's identities matching the filter
+func FindIdentities(ctx context.Context, ownerID primitive.ObjectID, filter IdentityFilter) ([]*model.Identity, error) {
+	ctx, cancel := withDeadline(ctx, "find_identities")
+	defer cancel()
+	result, err := store.FindIdentities(ctx, ownerID, filter)
+	return result, interrupted(ctx, err)
+}
+
+// UpdateIdentity changes the identity with id if it belongs to the owner.
+// It reports whether an identity matched.
+func UpdateIdentity(ctx context.Context, id primitive.ObjectID, ownerID primitive.ObjectID, update IdentityUpdate) (bool, error) {
+	ctx, cancel := withDeadline(ctx, "update_identity")
+	defer cancel()
+	result, err := store.UpdateIdentity(ctx, id, ownerID, update)
+	return result, interrupted(ctx, err)
+}
+
+// DeleteIdentity removes the identity with id if it belongs to the owner.
+// It reports whether an identity was removed.
+func DeleteIdentity(ctx context.Context, id primitive.ObjectID, ownerID primitive.ObjectID) (bool, error) {
+	ctx, cancel := withDeadline(ctx, "delete_identity")
+	defer cancel()
+	result, err := store.DeleteIdentity(ctx, id, ownerID)
+	return result, interrupted(ctx, err)
+}
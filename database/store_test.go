@@ -0,0 +1,34 @@
+package database_test
+
+import (
+	"context"
+	"goplay/config"
+	"goplay/database"
+	"goplay/database/conformance"
+	"testing"
+	"time"
+)
+
+// openStore opens the database of cfg with its schema for the rest of the test
+func openStore(t *testing.T, cfg config.Database) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := database.Open(ctx, cfg); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := database.Close(context.Background()); err != nil {
+			t.Error(err)
+		}
+	})
+	if err := database.EnsureSchema(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testConformance opens the database of cfg with its schema and runs the conformance checks against it
+func testConformance(t *testing.T, cfg config.Database) {
+	openStore(t, cfg)
+	conformance.Run(t, database.Current())
+}
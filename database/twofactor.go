@@ -0,0 +1,77 @@
+package database
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// SetPendingTOTP stores a secret that turns on two-factor authentication once confirmed
+// with a code. It replaces an unconfirmed one.
+func (mongoStore) SetPendingTOTP(ctx context.Context, userID primitive.ObjectID, secret string) error {
+	update := bson.D{{"$set", bson.D{{"pending_totp_secret", secret}}}}
+	_, err := Users.UpdateOne(ctx, bson.D{{"_id", userID}}, update)
+	return err
+}
+
+// EnableTOTP turns on two-factor authentication with the pending secret and replaces the
+// recovery codes. It reports false when secret is no longer the pending one.
+func (mongoStore) EnableTOTP(ctx context.Context, userID primitive.ObjectID, secret string, step int64, recoveryHashes []string) (bool, error) {
+	filter := bson.D{{"_id", userID}, {"pending_totp_secret", secret}}
+	update := bson.D{
+		{"$set", bson.D{
+			{"totp_secret", secret},
+			{"totp_last_step", step},
+			{"recovery_codes", recoveryHashes},
+		}},
+		{"$unset", bson.D{{"pending_totp_secret", ""}}},
+	}
+	result, err := Users.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount > 0, nil
+}
+
+// DisableTOTP turns off two-factor authentication and drops the recovery codes
+func (mongoStore) DisableTOTP(ctx context.Context, userID primitive.ObjectID) error {
+	update := bson.D{{"$unset", bson.D{
+		{"totp_secret", ""},
+		{"pending_totp_secret", ""},
+		{"totp_last_step", ""},
+		{"recovery_codes", ""},
+	}}}
+	_, err := Users.UpdateOne(ctx, bson.D{{"_id", userID}}, update)
+	return err
+}
+
+// UseTOTPStep records that a code of the time step was accepted. It reports false when
+// a code of this or a later step was already used, i.e. the code is replayed.
+func (mongoStore) UseTOTPStep(ctx context.Context, userID primitive.ObjectID, step int64) (bool, error) {
+	filter := bson.D{
+		{"_id", userID},
+		{"$or", bson.A{
+			bson.D{{"totp_last_step", bson.D{{"$lt", step}}}},
+			bson.D{{"totp_last_step", bson.D{{"$exists", false}}}},
+		}},
+	}
+	update := bson.D{{"$set", bson.D{{"totp_last_step", step}}}}
+	result, err := Users.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount > 0, nil
+}
+
+// UseRecoveryCode removes the recovery code with the hash. It reports false when the
+// user has no such code left.
+func (mongoStore) UseRecoveryCode(ctx context.Context, userID primitive.ObjectID, hash string) (bool, error) {
+	filter := bson.D{{"_id", userID}, {"recovery_codes", hash}}
+	update := bson.D{{"$pull", bson.D{{"recovery_codes", hash}}}}
+	result, err := Users.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount > 0, nil
+}
@@ -0,0 +1,36 @@
+package database
+
+import (
+	"context"
+	"goplay/model"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// GetUserByUsername finds a user by username, ignoring case
+func (mongoStore) GetUserByUsername(ctx context.Context, username string) (model.User, error) {
+	var user model.User
+	opts := options.FindOne().SetCollation(usernameCollation)
+	err := Users.FindOne(ctx, bson.D{{"username", username}}, opts).Decode(&user)
+	return user, err
+}
+
+// CreateUser inserts a new user. The unique indexes make it fail with ErrDuplicate
+// when the username is taken and with ErrEmailTaken when the email is.
+func (mongoStore) CreateUser(ctx context.Context, user model.User) (primitive.ObjectID, error) {
+	result, err := Users.InsertOne(ctx, user)
+	if mongo.IsDuplicateKeyError(err) && strings.Contains(err.Error(), emailIndex) {
+		return primitive.NilObjectID, ErrEmailTaken
+	}
+	if mongo.IsDuplicateKeyError(err) {
+		return primitive.NilObjectID, ErrDuplicate
+	}
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	return result.InsertedID.(primitive.ObjectID), nil
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"goplay/api"
+	"goplay/model"
+	"goplay/openapi"
+	"goplay/version"
+	"net/http"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// graphQLRequest is the body accepted by /graphql
+type graphQLRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+}
+
+// graphQLResponse is the body returned by /graphql
+type graphQLResponse struct {
+	Data   interface{}   `json:"data"`
+	Errors []interface{} `json:"errors,omitempty"`
+}
+
+// describeRoutes documents every route registered in main.
+// The server refuses to start if a route is missing here.
+func describeRoutes(spec *openapi.Spec) {
+	// Public
+	spec.Describe(openapi.Operation{
+		Method: http.MethodPost, Path: "/register", Tag: "auth",
+		Summary:  "Create an account",
+		Request:  model.User{},
+		Response: model.ResponseResult{},
+	})
+	spec.Describe(openapi.Operation{
+		Method: http.MethodPost, Path: "/login", Tag: "auth",
+		Summary:  "Exchange a username and password for a bearer token, or for a challenge to post to /login/2fa",
+		Request:  model.User{},
+		Response: model.User{},
+	})
+	spec.Describe(openapi.Operation{
+		Method: http.MethodPost, Path: "/login/2fa", Tag: "auth",
+		Summary:  "Exchange a login challenge and an authenticator or recovery code for a bearer token",
+		Request:  model.TwoFactorLogin{},
+		Response: model.User{},
+	})
+	spec.Describe(openapi.Operation{
+		Method: http.MethodPost, Path: "/password/forgot", Tag: "auth",
+		Summary:  "Email a password reset link to the account using the address",
+		Request:  model.PasswordForgotten{},
+		Response: model.ResponseResult{},
+	})
+	spec.Describe(openapi.Operation{
+		Method: http.MethodPost, Path: "/password/reset", Tag: "auth",
+		Summary:  "Set a new password with the token of a reset link",
+		Request:  model.PasswordReset{},
+		Response: model.ResponseResult{},
+	})
+	spec.Describe(openapi.Operation{
+		Method: http.MethodPost, Path: "/email/verify", Tag: "auth",
+		Summary:  "Verify an email address with the token of a verification link",
+		Request:  model.EmailVerification{},
+		Response: model.ResponseResult{},
+	})
+	spec.Describe(openapi.Operation{
+		Method: http.MethodGet, Path: "/ical/{token}.ics", Tag: "calendar",
+		Summary:      "iCalendar feed of the habits and logs of the token owner",
+		ResponseType: "text/calendar",
+	})
+	spec.Describe(openapi.Operation{
+		Method: http.MethodPost, Path: "/graphql", Tag: "graphql", Secured: true,
+		Summary:  "GraphQL endpoint over identities, habits and logs",
+		Request:  graphQLRequest{},
+		Response: graphQLResponse{},
+	})
+	spec.Describe(openapi.Operation{
+		Method: http.MethodGet, Path: "/openapi.json", Tag: "docs",
+		Summary:  "This document",
+		Response: map[string]interface{}{},
+	})
+
+	// Probes
+	spec.Describe(openapi.Operation{
+		Method: http.MethodGet, Path: "/healthz", Tag: "probes",
+		Summary:  "Answers while the process is alive",
+		Response: map[string]string{},
+	})
+	spec.Describe(openapi.Operation{
+		Method: http.MethodGet, Path: "/readyz", Tag: "probes",
+		Summary:  "Answers 200 when the database and background workers are up, 503 otherwise",
+		Response: api.Readiness{},
+	})
+	spec.Describe(openapi.Operation{
+		Method: http.MethodGet, Path: "/version", Tag: "probes",
+		Summary:  "Commit, build time and go version of the server",
+		Response: version.Info{},
+	})
+	spec.Describe(openapi.Operation{
+		Method: http.MethodGet, Path: "/metrics", Tag: "probes",
+		Summary:      "Prometheus metrics",
+		ResponseType: "text/plain",
+	})
+
+	// Profile
+	spec.Describe(openapi.Operation{
+		Method: http.MethodGet, Path: "/api/profile", Tag: "account", Secured: true,
+		Summary:  "The requester's profile, same as GET /api/account",
+		Response: model.Account{},
+	})
+	spec.Describe(openapi.Operation{
+		Method: http.MethodGet, Path: "/api/account", Tag: "account", Secured: true,
+		Summary:  "The requester's profile and preferences",
+		Response: model.Account{},
+	})
+	spec.Describe(openapi.Operation{
+		Method: http.MethodPatch, Path: "/api/account", Tag: "account", Secured: true,
+		Summary:  "Change the profile fields and preferences present in the body",
+		Request:  model.AccountUpdate{},
+		Response: model.Account{},
+	})
+	spec.Describe(openapi.Operation{
+		Method: http.MethodDelete, Path: "/api/account", Tag: "account", Secured: true,
+		Summary:  "Delete the account with its identities, habits and logs after confirming the password",
+		Request:  model.AccountDeletion{},
+		Response: model.ResponseResult{},
+	})
+	spec.Describe(openapi.Operation{
+		Method: http.MethodPost, Path: "/api/account/email/verify", Tag: "account", Secured: true,
+		Summary:  "Send a new verification link to the requester's email",
+		Response: model.ResponseResult{},
+	})
+	spec.Describe(openapi.Operation{
+		Method: http.MethodPost, Path: "/api/account/password", Tag: "auth", Secured: true,
+		Summary:  "Change the password, logging out every other session",
+		Request:  model.PasswordChange{},
+		Response: model.User{},
+	})
+	spec.Describe(openapi.Operation{
+		Method: http.MethodPost, Path: "/api/account/2fa", Tag: "auth", Secured: true,
+		Summary:  "Enroll an authenticator app, to confirm with one of its codes",
+		Response: model.TwoFactorEnrollment{},
+	})
+	spec.Describe(openapi.Operation{
+		Method: http.MethodPost, Path: "/api/account/2fa/confirm", Tag: "auth", Secured: true,
+		Summary:  "Turn on two-factor authentication with a code of the enrolled authenticator",
+		Request:  model.TwoFactorCode{},
+		Response: model.RecoveryCodes{},
+	})
+	spec.Describe(openapi.Operation{
+		Method: http.MethodDelete, Path: "/api/account/2fa", Tag: "auth", Secured: true,
+		Summary:  "Turn off two-factor authentication with an authenticator or recovery code",
+		Request:  model.TwoFactorCode{},
+		Response: model.ResponseResult{},
+	})
+
+	// API keys
+	spec.Describe(openapi.Operation{
+		Method: http.MethodGet, Path: "/api/keys", Tag: "keys", Secured: true,
+		Summary:  "List the requester's API keys",
+		Response: []model.APIKey{},
+	})
+	spec.Describe(openapi.Operation{
+		Method: http.MethodPost, Path: "/api/keys", Tag: "keys", Secured: true,
+		Summary:  "Create an API key to send as the bearer token; the key is only returned here",
+		Request:  model.APIKey{},
+		Response: model.NewAPIKey{},
+	})
+	spec.Describe(openapi.Operation{
+		Method: http.MethodDelete, Path: "/api/keys/{_id}", Tag: "keys", Secured: true,
+		Summary:  "Revoke an API key",
+		Response: model.ResponseResult{},
+	})
+
+	// Logs
+	spec.Describe(openapi.Operation{
+		Method: http.MethodPost, Path: "/api/logs", Tag: "logs", Secured: true,
+		Summary:  "Create a log",
+		Request:  model.Log{},
+		Response: mongo.InsertOneResult{},
+	})
+	spec.Describe(openapi.Operation{
+		Method: http.MethodGet, Path: "/api/logs", Tag: "logs", Secured: true,
+		Summary:  "List the requester's logs",
+		Response: []model.Log{},
+	})
+	spec.Describe(openapi.Operation{
+		Method: http.MethodGet, Path: "/api/logs/{_id}", Tag: "logs", Secured: true,
+		Summary: "Get a log",
+		Query: []openapi.Parameter{
+			{Name: "render", Description: "Set to html to get the entry as sanitized text/html instead of json"},
+		},
+		Response: model.Log{},
+	})
+	spec.Describe(openapi.Operation{
+		Method: http.MethodPut, Path: "/api/logs/{_id}", Tag: "logs", Secured: true,
+		Summary:  "Update a log",
+		Request:  model.Log{},
+		Response: mongo.UpdateResult{},
+	})
+	spec.Describe(openapi.Operation{
+		Method: http.MethodDelete, Path: "/api/logs/{_id}", Tag: "logs", Secured: true,
+		Summary:  "Delete a log",
+		Response: mongo.DeleteResult{},
+	})
+
+	// Habits
+	spec.Describe(openapi.Operation{
+		Method: http.MethodGet, Path: "/api/habits", Tag: "habits", Secured: true,
+		Summary:  "List the requester's habits",
+		Response: []model.Habit{},
+	})
+	spec.Describe(openapi.Operation{
+		Method: http.MethodPost, Path: "/api/habits", Tag: "habits", Secured: true,
+		Summary:  "Create a habit",
+		Request:  model.Habit{},
+		Response: mongo.InsertOneResult{},
+	})
+	spec.Describe(openapi.Operation{
+		Method: http.MethodPut, Path: "/api/habits/{_id}", Tag: "habits", Secured: true,
+		Summary:  "Update a habit",
+		Request:  model.Habit{},
+		Response: model.Habit{},
+	})
+	spec.Describe(openapi.Operation{
+		Method: http.MethodDelete, Path: "/api/habits/{_id}", Tag: "habits", Secured: true,
+		Summary:  "Delete a habit",
+		Response: mongo.DeleteResult{},
+	})
+
+	// Identities
+	spec.Describe(openapi.Operation{
+		Method: http.MethodGet, Path: "/api/identities", Tag: "identities", Secured: true,
+		Summary:  "List the requester's identities",
+		Response: []model.Identity{},
+	})
+	spec.Describe(openapi.Operation{
+		Method: http.MethodPost, Path: "/api/identities", Tag: "identities", Secured: true,
+		Summary:  "Create an identity",
+		Request:  model.Identity{},
+		Response: mongo.InsertOneResult{},
+	})
+	spec.Describe(openapi.Operation{
+		Method: http.MethodPut, Path: "/api/identities/{_id}", Tag: "identities", Secured: true,
+		Summary:  "Update an identity",
+		Request:  model.Identity{},
+		Response: mongo.UpdateResult{},
+	})
+	spec.Describe(openapi.Operation{
+		Method: http.MethodDelete, Path: "/api/identities/{_id}", Tag: "identities", Secured: true,
+		Summary:  "Delete an identity",
+		Response: mongo.DeleteResult{},
+	})
+
+	// Export
+	spec.Describe(openapi.Operation{
+		Method: http.MethodGet, Path: "/api/export/markdown", Tag: "logs", Secured: true,
+		Summary: "Zip of markdown files, one per day, with the habits done in the front matter",
+		Query: []openapi.Parameter{
+			{Name: "tz", Description: "IANA time zone used to split days, by default the timezone preference or UTC"},
+		},
+		ResponseType: "application/zip",
+	})
+
+	// Calendar feed
+	spec.Describe(openapi.Operation{
+		Method: http.MethodGet, Path: "/api/ical/token", Tag: "calendar", Secured: true,
+		Summary:  "Path of the requester's calendar feed, 404 until one is created with POST",
+		Response: model.ResponseResult{},
+	})
+	spec.Describe(openapi.Operation{
+		Method: http.MethodPost, Path: "/api/ical/token", Tag: "calendar", Secured: true,
+		Summary:  "Create the requester's calendar feed path, or replace it invalidating the old one",
+		Response: model.ResponseResult{},
+	})
+}
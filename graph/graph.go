@@ -0,0 +1,437 @@
+// Package graph serves a GraphQL api over the requester's identities, habits and logs
+package graph
+
+import (
+	"context"
+	"errors"
+	"goplay/api"
+	"goplay/database"
+	"goplay/model"
+	"goplay/validation"
+	"net/http"
+
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/graph-gophers/graphql-go/relay"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+var (
+	errInvalidID = errors.New("invalid id")
+	errNotFound  = errors.New("not found")
+)
+
+type contextKey int
+
+const requestKey contextKey = 0
+
+// request holds what the resolvers of a single request share
+type request struct {
+	owner   model.User
+	loaders *loaders
+}
+
+func fromContext(ctx context.Context) *request {
+	return ctx.Value(requestKey).(*request)
+}
+
+// Handler returns the /graphql endpoint. It expects the request to have passed the jwt middleware.
+func Handler() http.Handler {
+	s := graphql.MustParseSchema(schema, &Resolver{}, graphql.MaxDepth(8))
+	h := &relay.Handler{Schema: s}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		owner, ok := api.CurrentUser(r)
+		if !ok {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), requestKey, &request{
+			owner:   owner,
+			loaders: newLoaders(owner.OID),
+		})
+		h.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Resolver is the root resolver for queries and mutations
+type Resolver struct{}
+
+type pageArgs struct {
+	First  *int32
+	Offset *int32
+}
+
+func (p pageArgs) skipLimit() (int64, int64) {
+	var skip, limit int64 = 0, defaultPageSize
+	if p.Offset != nil && *p.Offset > 0 {
+		skip = int64(*p.Offset)
+	}
+	if p.First != nil && *p.First >= 0 {
+		limit = int64(*p.First)
+	}
+	if limit > maxPageSize {
+		limit = maxPageSize
+	}
+	return skip, limit
+}
+
+func (*Resolver) Me(ctx context.Context) *userResolver {
+	return &userResolver{fromContext(ctx).owner}
+}
+
+func (*Resolver) Identities(ctx context.Context, args pageArgs) ([]*identityResolver, error) {
+	req := fromContext(ctx)
+	skip, limit := args.skipLimit()
+
+	// A zero limit would mean no limit at all to the database
+	if limit == 0 {
+		return []*identityResolver{}, nil
+	}
+
+	identities, err := database.FindIdentities(ctx, req.owner.OID, database.IdentityFilter{Skip: skip, Limit: limit})
+	if err != nil {
+		return nil, err
+	}
+	return wrapIdentities(req, identities), nil
+}
+
+func (*Resolver) Habits(ctx context.Context, args struct {
+	IdentityID *graphql.ID
+	First      *int32
+	Offset     *int32
+}) ([]*habitResolver, error) {
+	req := fromContext(ctx)
+	skip, limit := pageArgs{args.First, args.Offset}.skipLimit()
+
+	if limit == 0 {
+		return []*habitResolver{}, nil
+	}
+
+	filter := database.HabitFilter{Skip: skip, Limit: limit}
+	if args.IdentityID != nil {
+		identityID, err := objectID(*args.IdentityID)
+		if err != nil {
+			return nil, err
+		}
+		filter.IdentityIDs = []primitive.ObjectID{identityID}
+	}
+
+	habits, err := database.FindHabits(ctx, req.owner.OID, filter)
+	if err != nil {
+		return nil, err
+	}
+	return wrapHabits(req, habits), nil
+}
+
+func (*Resolver) Logs(ctx context.Context, args struct {
+	Habit  *string
+	First  *int32
+	Offset *int32
+}) ([]*logResolver, error) {
+	req := fromContext(ctx)
+	skip, limit := pageArgs{args.First, args.Offset}.skipLimit()
+
+	if limit == 0 {
+		return []*logResolver{}, nil
+	}
+
+	filter := database.LogFilter{Skip: skip, Limit: limit}
+	if args.Habit != nil {
+		filter.Habits = []string{*args.Habit}
+	}
+
+	logs, err := database.FindLogs(ctx, req.owner.OID, filter)
+	if err != nil {
+		return nil, err
+	}
+	return wrapLogs(req, logs), nil
+}
+
+func (*Resolver) Log(ctx context.Context, args struct{ ID graphql.ID }) (*logResolver, error) {
+	req := fromContext(ctx)
+	id, err := objectID(args.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	logEntry, err := database.GetLog(ctx, id, req.owner.OID)
+	if err == database.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return wrapLogs(req, []*model.Log{&logEntry})[0], nil
+}
+
+type logInput struct {
+	Entry  string
+	Habits *[]string
+}
+
+func (in logInput) habits() []string {
+	if in.Habits == nil {
+		return []string{}
+	}
+	return *in.Habits
+}
+
+func (*Resolver) CreateLog(ctx context.Context, args struct{ Input logInput }) (*logResolver, error) {
+	req := fromContext(ctx)
+
+	logEntry := model.Log{
+		Entry:  args.Input.Entry,
+		Habits: args.Input.habits(),
+		UserID: req.owner.OID,
+	}
+	if err := validation.Log(logEntry); err != nil {
+		return nil, err
+	}
+
+	id, err := database.CreateLog(ctx, logEntry)
+	if err != nil {
+		return nil, err
+	}
+
+	logEntry, err = database.GetLog(ctx, id, req.owner.OID)
+	if err != nil {
+		return nil, err
+	}
+	return wrapLogs(req, []*model.Log{&logEntry})[0], nil
+}
+
+func (*Resolver) UpdateLog(ctx context.Context, args struct {
+	ID    graphql.ID
+	Input logInput
+}) (*logResolver, error) {
+	req := fromContext(ctx)
+	id, err := objectID(args.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validation.Log(model.Log{Entry: args.Input.Entry, Habits: args.Input.habits()}); err != nil {
+		return nil, err
+	}
+
+	update := database.LogUpdate{Entry: args.Input.Entry, Habits: args.Input.habits()}
+	if err := found(database.UpdateLog(ctx, id, req.owner.OID, update)); err != nil {
+		return nil, err
+	}
+
+	logEntry, err := database.GetLog(ctx, id, req.owner.OID)
+	if err != nil {
+		return nil, err
+	}
+	return wrapLogs(req, []*model.Log{&logEntry})[0], nil
+}
+
+func (*Resolver) DeleteLog(ctx context.Context, args struct{ ID graphql.ID }) (bool, error) {
+	return deleteOwned(ctx, database.DeleteLog, args.ID)
+}
+
+type habitInput struct {
+	Name        string
+	Description *string
+	IdentityID  *graphql.ID
+}
+
+func (*Resolver) CreateHabit(ctx context.Context, args struct{ Input habitInput }) (*habitResolver, error) {
+	req := fromContext(ctx)
+
+	habit := model.Habit{
+		Name:   args.Input.Name,
+		UserID: req.owner.OID,
+	}
+	if args.Input.Description != nil {
+		habit.Description = *args.Input.Description
+	}
+	if args.Input.IdentityID != nil {
+		identityID, err := ownedIdentityID(ctx, req, *args.Input.IdentityID)
+		if err != nil {
+			return nil, err
+		}
+		habit.IdentityID = identityID
+	}
+
+	if err := validation.Habit(ctx, habit, req.owner.OID, nil); err != nil {
+		return nil, err
+	}
+
+	id, err := database.CreateHabit(ctx, habit)
+	if err == database.ErrHabitNameTaken {
+		return nil, validation.HabitNameTaken
+	}
+	if err != nil {
+		return nil, err
+	}
+	habit.ID = &id
+
+	return wrapHabits(req, []*model.Habit{&habit})[0], nil
+}
+
+func (*Resolver) UpdateHabit(ctx context.Context, args struct {
+	ID    graphql.ID
+	Input habitInput
+}) (*habitResolver, error) {
+	req := fromContext(ctx)
+	id, err := objectID(args.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	habit := model.Habit{Name: args.Input.Name}
+	update := database.HabitUpdate{Name: args.Input.Name, Description: args.Input.Description}
+	if args.Input.Description != nil {
+		habit.Description = *args.Input.Description
+	}
+	if err := validation.Habit(ctx, habit, req.owner.OID, &id); err != nil {
+		return nil, err
+	}
+	if args.Input.IdentityID != nil {
+		identityID, err := ownedIdentityID(ctx, req, *args.Input.IdentityID)
+		if err != nil {
+			return nil, err
+		}
+		update.IdentityID = &identityID
+	}
+
+	err = found(database.UpdateHabit(ctx, id, req.owner.OID, update))
+	if err == database.ErrHabitNameTaken {
+		return nil, validation.HabitNameTaken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	habit, err = database.GetHabit(ctx, id, req.owner.OID)
+	if err != nil {
+		return nil, err
+	}
+	return wrapHabits(req, []*model.Habit{&habit})[0], nil
+}
+
+func (*Resolver) DeleteHabit(ctx context.Context, args struct{ ID graphql.ID }) (bool, error) {
+	return deleteOwned(ctx, database.DeleteHabit, args.ID)
+}
+
+type identityInput struct {
+	Name        string
+	Description *string
+}
+
+func (*Resolver) CreateIdentity(ctx context.Context, args struct{ Input identityInput }) (*identityResolver, error) {
+	req := fromContext(ctx)
+
+	identity := model.Identity{
+		Name:   args.Input.Name,
+		UserID: req.owner.OID,
+	}
+	if args.Input.Description != nil {
+		identity.Description = *args.Input.Description
+	}
+
+	if err := validation.Identity(identity); err != nil {
+		return nil, err
+	}
+
+	id, err := database.CreateIdentity(ctx, identity)
+	if err != nil {
+		return nil, err
+	}
+	identity.ID = &id
+
+	return wrapIdentities(req, []*model.Identity{&identity})[0], nil
+}
+
+func (*Resolver) UpdateIdentity(ctx context.Context, args struct {
+	ID    graphql.ID
+	Input identityInput
+}) (*identityResolver, error) {
+	req := fromContext(ctx)
+	id, err := objectID(args.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	identity := model.Identity{Name: args.Input.Name}
+	update := database.IdentityUpdate{Name: args.Input.Name, Description: args.Input.Description}
+	if args.Input.Description != nil {
+		identity.Description = *args.Input.Description
+	}
+	if err := validation.Identity(identity); err != nil {
+		return nil, err
+	}
+
+	if err := found(database.UpdateIdentity(ctx, id, req.owner.OID, update)); err != nil {
+		return nil, err
+	}
+
+	identity, err = database.GetIdentity(ctx, id, req.owner.OID)
+	if err != nil {
+		return nil, err
+	}
+	return wrapIdentities(req, []*model.Identity{&identity})[0], nil
+}
+
+func (*Resolver) DeleteIdentity(ctx context.Context, args struct{ ID graphql.ID }) (bool, error) {
+	return deleteOwned(ctx, database.DeleteIdentity, args.ID)
+}
+
+func objectID(id graphql.ID) (primitive.ObjectID, error) {
+	oid, err := primitive.ObjectIDFromHex(string(id))
+	if err != nil {
+		return oid, errInvalidID
+	}
+	return oid, nil
+}
+
+// ownedIdentityID parses an identity id and makes sure the requester owns it
+func ownedIdentityID(ctx context.Context, req *request, id graphql.ID) (primitive.ObjectID, error) {
+	identityID, err := objectID(id)
+	if err != nil {
+		return identityID, err
+	}
+
+	identity, err := req.loaders.identities.load(ctx, identityID)
+	if err != nil {
+		return identityID, err
+	}
+	if identity == nil {
+		return identityID, errNotFound
+	}
+	return identityID, nil
+}
+
+// found turns the result of an update into errNotFound when nothing matched
+func found(matched bool, err error) error {
+	if err != nil {
+		return err
+	}
+	if !matched {
+		return errNotFound
+	}
+	return nil
+}
+
+func deleteOwned(ctx context.Context, remove func(ctx context.Context, id primitive.ObjectID, ownerID primitive.ObjectID) (bool, error), id graphql.ID) (bool, error) {
+	oid, err := objectID(id)
+	if err != nil {
+		return false, err
+	}
+
+	deleted, err := remove(ctx, oid, fromContext(ctx).owner.OID)
+	if err != nil {
+		return false, err
+	}
+	if !deleted {
+		return false, errNotFound
+	}
+	return true, nil
+}
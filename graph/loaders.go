@@ -0,0 +1,184 @@
+package graph
+
+import (
+	"context"
+	"goplay/database"
+	"goplay/model"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// maxRecentLogs caps how many logs are batched per habit for Habit.recentLogs
+const maxRecentLogs = 100
+
+// The loaders below batch the lookups of nested fields to avoid N+1 queries.
+// List resolvers prime a loader with the keys of every item they return, so the
+// first nested load fetches all of them at once and the rest are served from cache.
+// Loaders live for a single request.
+type loaders struct {
+	habitsByIdentity *habitsByIdentityLoader
+	identities       *identityLoader
+	recentLogs       *recentLogsLoader
+}
+
+func newLoaders(owner primitive.ObjectID) *loaders {
+	return &loaders{
+		habitsByIdentity: &habitsByIdentityLoader{
+			owner:   owner,
+			pending: map[primitive.ObjectID]bool{},
+			loaded:  map[primitive.ObjectID][]*model.Habit{},
+		},
+		identities: &identityLoader{
+			owner:   owner,
+			pending: map[primitive.ObjectID]bool{},
+			loaded:  map[primitive.ObjectID]*model.Identity{},
+		},
+		recentLogs: &recentLogsLoader{
+			owner:   owner,
+			pending: map[string]bool{},
+			loaded:  map[string][]*model.Log{},
+		},
+	}
+}
+
+type habitsByIdentityLoader struct {
+	owner   primitive.ObjectID
+	mu      sync.Mutex
+	pending map[primitive.ObjectID]bool
+	loaded  map[primitive.ObjectID][]*model.Habit
+}
+
+func (l *habitsByIdentityLoader) prime(ids ...primitive.ObjectID) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, id := range ids {
+		if _, ok := l.loaded[id]; !ok {
+			l.pending[id] = true
+		}
+	}
+}
+
+func (l *habitsByIdentityLoader) load(ctx context.Context, id primitive.ObjectID) ([]*model.Habit, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if habits, ok := l.loaded[id]; ok {
+		return habits, nil
+	}
+
+	l.pending[id] = true
+	keys := make([]primitive.ObjectID, 0, len(l.pending))
+	for key := range l.pending {
+		keys = append(keys, key)
+	}
+
+	habits, err := database.FindHabits(ctx, l.owner, database.HabitFilter{IdentityIDs: keys})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, key := range keys {
+		l.loaded[key] = []*model.Habit{}
+	}
+	for _, habit := range habits {
+		l.loaded[habit.IdentityID] = append(l.loaded[habit.IdentityID], habit)
+	}
+	l.pending = map[primitive.ObjectID]bool{}
+
+	return l.loaded[id], nil
+}
+
+type identityLoader struct {
+	owner   primitive.ObjectID
+	mu      sync.Mutex
+	pending map[primitive.ObjectID]bool
+	loaded  map[primitive.ObjectID]*model.Identity
+}
+
+func (l *identityLoader) prime(ids ...primitive.ObjectID) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, id := range ids {
+		if _, ok := l.loaded[id]; !ok {
+			l.pending[id] = true
+		}
+	}
+}
+
+func (l *identityLoader) load(ctx context.Context, id primitive.ObjectID) (*model.Identity, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if identity, ok := l.loaded[id]; ok {
+		return identity, nil
+	}
+
+	l.pending[id] = true
+	keys := make([]primitive.ObjectID, 0, len(l.pending))
+	for key := range l.pending {
+		keys = append(keys, key)
+	}
+
+	identities, err := database.FindIdentities(ctx, l.owner, database.IdentityFilter{IDs: keys})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, key := range keys {
+		l.loaded[key] = nil
+	}
+	for _, identity := range identities {
+		l.loaded[*identity.ID] = identity
+	}
+	l.pending = map[primitive.ObjectID]bool{}
+
+	return l.loaded[id], nil
+}
+
+type recentLogsLoader struct {
+	owner   primitive.ObjectID
+	mu      sync.Mutex
+	pending map[string]bool
+	loaded  map[string][]*model.Log
+}
+
+func (l *recentLogsLoader) prime(habits ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, habit := range habits {
+		if _, ok := l.loaded[habit]; !ok {
+			l.pending[habit] = true
+		}
+	}
+}
+
+func (l *recentLogsLoader) load(ctx context.Context, habit string) ([]*model.Log, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if logs, ok := l.loaded[habit]; ok {
+		return logs, nil
+	}
+
+	l.pending[habit] = true
+	keys := make([]string, 0, len(l.pending))
+	for key := range l.pending {
+		keys = append(keys, key)
+	}
+
+	results, err := database.GetRecentLogsByHabits(ctx, l.owner, keys, maxRecentLogs)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, key := range keys {
+		l.loaded[key] = []*model.Log{}
+	}
+	for _, result := range results {
+		l.loaded[result.Habit] = result.Logs
+	}
+	l.pending = map[string]bool{}
+
+	return l.loaded[habit], nil
+}
@@ -0,0 +1,178 @@
+package graph
+
+import (
+	"context"
+	"goplay/model"
+	"time"
+
+	graphql "github.com/graph-gophers/graphql-go"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func hexID(id *primitive.ObjectID) graphql.ID {
+	if id == nil {
+		return ""
+	}
+	return graphql.ID(id.Hex())
+}
+
+// wrapIdentities primes the loaders of the nested fields and wraps each identity in a resolver
+func wrapIdentities(req *request, identities []*model.Identity) []*identityResolver {
+	resolvers := make([]*identityResolver, 0, len(identities))
+	for _, identity := range identities {
+		if identity.ID != nil {
+			req.loaders.habitsByIdentity.prime(*identity.ID)
+		}
+		resolvers = append(resolvers, &identityResolver{req, identity})
+	}
+	return resolvers
+}
+
+// wrapHabits primes the loaders of the nested fields and wraps each habit in a resolver
+func wrapHabits(req *request, habits []*model.Habit) []*habitResolver {
+	resolvers := make([]*habitResolver, 0, len(habits))
+	for _, habit := range habits {
+		if !habit.IdentityID.IsZero() {
+			req.loaders.identities.prime(habit.IdentityID)
+		}
+		req.loaders.recentLogs.prime(habit.Name)
+		resolvers = append(resolvers, &habitResolver{req, habit})
+	}
+	return resolvers
+}
+
+func wrapLogs(req *request, logs []*model.Log) []*logResolver {
+	resolvers := make([]*logResolver, 0, len(logs))
+	for _, logEntry := range logs {
+		resolvers = append(resolvers, &logResolver{req, logEntry})
+	}
+	return resolvers
+}
+
+type userResolver struct {
+	user model.User
+}
+
+func (r *userResolver) ID() graphql.ID {
+	return graphql.ID(r.user.OID.Hex())
+}
+
+func (r *userResolver) Username() string {
+	return r.user.Username
+}
+
+func (r *userResolver) Firstname() string {
+	return r.user.FirstName
+}
+
+func (r *userResolver) Lastname() string {
+	return r.user.LastName
+}
+
+type identityResolver struct {
+	req      *request
+	identity *model.Identity
+}
+
+func (r *identityResolver) ID() graphql.ID {
+	return hexID(r.identity.ID)
+}
+
+func (r *identityResolver) Name() string {
+	return r.identity.Name
+}
+
+func (r *identityResolver) Description() string {
+	return r.identity.Description
+}
+
+func (r *identityResolver) Habits(ctx context.Context) ([]*habitResolver, error) {
+	if r.identity.ID == nil {
+		return []*habitResolver{}, nil
+	}
+
+	habits, err := r.req.loaders.habitsByIdentity.load(ctx, *r.identity.ID)
+	if err != nil {
+		return nil, err
+	}
+	return wrapHabits(r.req, habits), nil
+}
+
+type habitResolver struct {
+	req   *request
+	habit *model.Habit
+}
+
+func (r *habitResolver) ID() graphql.ID {
+	return hexID(r.habit.ID)
+}
+
+func (r *habitResolver) Name() string {
+	return r.habit.Name
+}
+
+func (r *habitResolver) Description() string {
+	return r.habit.Description
+}
+
+func (r *habitResolver) Identity(ctx context.Context) (*identityResolver, error) {
+	if r.habit.IdentityID.IsZero() {
+		return nil, nil
+	}
+
+	identity, err := r.req.loaders.identities.load(ctx, r.habit.IdentityID)
+	if err != nil || identity == nil {
+		return nil, err
+	}
+	return wrapIdentities(r.req, []*model.Identity{identity})[0], nil
+}
+
+func (r *habitResolver) RecentLogs(ctx context.Context, args struct{ First int32 }) ([]*logResolver, error) {
+	logs, err := r.req.loaders.recentLogs.load(ctx, r.habit.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	if args.First < 0 {
+		args.First = 0
+	}
+	if int(args.First) < len(logs) {
+		logs = logs[:args.First]
+	}
+	return wrapLogs(r.req, logs), nil
+}
+
+type logResolver struct {
+	req      *request
+	logEntry *model.Log
+}
+
+func (r *logResolver) ID() graphql.ID {
+	return hexID(r.logEntry.ID)
+}
+
+func (r *logResolver) Entry() string {
+	return r.logEntry.Entry
+}
+
+func (r *logResolver) Habits() []string {
+	if r.logEntry.Habits == nil {
+		return []string{}
+	}
+	return r.logEntry.Habits
+}
+
+func (r *logResolver) HabitsInfo() []*habitResolver {
+	habits := make([]*model.Habit, 0, len(r.logEntry.HabitsInfo))
+	for i := range r.logEntry.HabitsInfo {
+		habits = append(habits, &r.logEntry.HabitsInfo[i])
+	}
+	return wrapHabits(r.req, habits)
+}
+
+func (r *logResolver) CreatedAt() string {
+	if r.logEntry.ID == nil {
+		return ""
+	}
+	return r.logEntry.ID.Timestamp().UTC().Format(time.RFC3339)
+}
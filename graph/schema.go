@@ -0,0 +1,78 @@
+package graph
+
+const schema = `
+schema {
+	query: Query
+	mutation: Mutation
+}
+
+type Query {
+	me: User!
+	identities(first: Int, offset: Int): [Identity!]!
+	habits(identityId: ID, first: Int, offset: Int): [Habit!]!
+	logs(habit: String, first: Int, offset: Int): [Log!]!
+	log(id: ID!): Log
+}
+
+type Mutation {
+	createLog(input: LogInput!): Log!
+	updateLog(id: ID!, input: LogInput!): Log!
+	deleteLog(id: ID!): Boolean!
+
+	createHabit(input: HabitInput!): Habit!
+	updateHabit(id: ID!, input: HabitInput!): Habit!
+	deleteHabit(id: ID!): Boolean!
+
+	createIdentity(input: IdentityInput!): Identity!
+	updateIdentity(id: ID!, input: IdentityInput!): Identity!
+	deleteIdentity(id: ID!): Boolean!
+}
+
+type User {
+	id: ID!
+	username: String!
+	firstname: String!
+	lastname: String!
+}
+
+type Identity {
+	id: ID!
+	name: String!
+	description: String!
+	habits: [Habit!]!
+}
+
+type Habit {
+	id: ID!
+	name: String!
+	description: String!
+	identity: Identity
+	# Newest logs tagged with this habit, at most 100
+	recentLogs(first: Int = 10): [Log!]!
+}
+
+type Log {
+	id: ID!
+	entry: String!
+	habits: [String!]!
+	habitsInfo: [Habit!]!
+	# RFC 3339 time the log was created
+	createdAt: String!
+}
+
+input LogInput {
+	entry: String!
+	habits: [String!]
+}
+
+input HabitInput {
+	name: String!
+	description: String
+	identityId: ID
+}
+
+input IdentityInput {
+	name: String!
+	description: String
+}
+`
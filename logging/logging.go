@@ -0,0 +1,133 @@
+// Package logging writes structured JSON logs and carries a request scoped logger
+// tagged with the request id through the request context
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"goplay/response"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// RequestIDHeader is read from incoming requests and set on every response
+const RequestIDHeader = "X-Request-ID"
+
+// Setup makes JSON at the given level the default for slog and the standard log package
+func Setup(w io.Writer, level string) error {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return err
+	}
+
+	slog.SetDefault(slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: l})))
+	return nil
+}
+
+type contextKey int
+
+const requestKey contextKey = 0
+
+// request is filled in by the layers a request passes through and logged once it is done
+type request struct {
+	id     string
+	logger *slog.Logger
+	route  string
+	userID string
+}
+
+// FromContext returns the logger of the request ctx belongs to, or the default logger
+func FromContext(ctx context.Context) *slog.Logger {
+	if req, ok := ctx.Value(requestKey).(*request); ok {
+		return req.logger
+	}
+	return slog.Default()
+}
+
+// RequestID returns the id of the request ctx belongs to, or an empty string
+func RequestID(ctx context.Context) string {
+	if req, ok := ctx.Value(requestKey).(*request); ok {
+		return req.id
+	}
+	return ""
+}
+
+// SetUserID records who made the request for the access log
+func SetUserID(ctx context.Context, userID string) {
+	if req, ok := ctx.Value(requestKey).(*request); ok {
+		req.userID = userID
+		req.logger = req.logger.With("user_id", userID)
+	}
+}
+
+// Middleware assigns or propagates the request id and writes one access log line per request.
+// It should wrap everything else.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+
+		req := &request{id: id, logger: slog.Default().With("request_id", id)}
+		rec := response.Record(w)
+		next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), requestKey, req)))
+
+		route := req.route
+		if len(route) == 0 {
+			route = "unmatched"
+		}
+
+		req.logger.LogAttrs(r.Context(), slog.LevelInfo, "request",
+			slog.String("method", r.Method),
+			slog.String("route", route),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", rec.Status),
+			slog.Int64("bytes", rec.Bytes),
+			slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.String("remote_addr", r.RemoteAddr),
+		)
+	})
+}
+
+// Route records the template of the matched mux route for the access log.
+// Use it with Router.Use; with nested routers the innermost match wins.
+func Route(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if req, ok := r.Context().Value(requestKey).(*request); ok {
+			if route := mux.CurrentRoute(r); route != nil {
+				if template, err := route.GetPathTemplate(); err == nil {
+					req.route = template
+				}
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// validRequestID accepts short printable ids so clients can't inject into the logs
+func validRequestID(id string) bool {
+	if len(id) == 0 || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
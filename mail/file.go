@@ -0,0 +1,36 @@
+package mail
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+// FileSender writes every message to an .eml file instead of sending it,
+// so links can be followed during local development
+type FileSender struct {
+	dir  string
+	from string
+}
+
+// NewFileSender writes messages into dir, creating it when needed
+func NewFileSender(dir string, from string) *FileSender {
+	return &FileSender{dir: dir, from: from}
+}
+
+var unsafeFilename = regexp.MustCompile(`[^a-zA-Z0-9@._-]`)
+
+// Send implements Sender
+func (s *FileSender) Send(ctx context.Context, msg Message) error {
+	// Messages can hold reset links, so only the server's user may read them
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	name := now.Format("20060102T150405.000000000") + "-" + unsafeFilename.ReplaceAllString(msg.To, "_") + ".eml"
+	return ioutil.WriteFile(filepath.Join(s.dir, name), format(s.from, msg, now), 0600)
+}
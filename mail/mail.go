@@ -0,0 +1,61 @@
+// Package mail sends the emails of the server, such as password reset links,
+// through an SMTP server or into files for local development
+package mail
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"goplay/config"
+	"mime"
+	"mime/quotedprintable"
+	"strings"
+	"time"
+)
+
+// Message is a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Sender delivers messages
+type Sender interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// New returns the sender named by cfg.Driver
+func New(cfg config.Mail) (Sender, error) {
+	switch cfg.Driver {
+	case "file":
+		return NewFileSender(cfg.Dir, cfg.From), nil
+	case "smtp":
+		return NewSMTPSender(cfg.SMTP, cfg.From)
+	}
+	return nil, fmt.Errorf("mail: unknown driver %q", cfg.Driver)
+}
+
+// headerValue keeps user supplied values from adding headers
+var headerValue = strings.NewReplacer("\r", "", "\n", "")
+
+// format renders msg as an RFC 5322 message with a quoted-printable utf-8 body
+func format(from string, msg Message, now time.Time) []byte {
+	var buf bytes.Buffer
+	header := func(key, value string) {
+		fmt.Fprintf(&buf, "%s: %s\r\n", key, headerValue.Replace(value))
+	}
+	header("From", from)
+	header("To", msg.To)
+	header("Subject", mime.QEncoding.Encode("utf-8", msg.Subject))
+	header("Date", now.Format(time.RFC1123Z))
+	header("MIME-Version", "1.0")
+	header("Content-Type", "text/plain; charset=utf-8")
+	header("Content-Transfer-Encoding", "quoted-printable")
+	buf.WriteString("\r\n")
+
+	body := quotedprintable.NewWriter(&buf)
+	body.Write([]byte(strings.ReplaceAll(msg.Body, "\n", "\r\n")))
+	body.Close()
+	return buf.Bytes()
+}
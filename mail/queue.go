@@ -0,0 +1,64 @@
+package mail
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+// sendTimeout bounds the delivery of a queued message
+const sendTimeout = 30 * time.Second
+
+// ErrQueueFull is returned when messages come in faster than they are delivered
+var ErrQueueFull = errors.New("mail: queue full")
+
+// Queue sends messages in the background, so requests don't wait for the mail
+// server and response times don't tell whether a message was sent
+type Queue struct {
+	sender   Sender
+	messages chan Message
+}
+
+// NewQueue buffers up to size messages for sender
+func NewQueue(sender Sender, size int) *Queue {
+	return &Queue{sender: sender, messages: make(chan Message, size)}
+}
+
+// Send implements Sender by queueing msg. Delivery errors are only logged.
+func (q *Queue) Send(ctx context.Context, msg Message) error {
+	select {
+	case q.messages <- msg:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Run delivers the queued messages until ctx is done, then delivers what is left
+func (q *Queue) Run(ctx context.Context) error {
+	for {
+		select {
+		case msg := <-q.messages:
+			q.deliver(msg)
+		case <-ctx.Done():
+			for {
+				select {
+				case msg := <-q.messages:
+					q.deliver(msg)
+				default:
+					return nil
+				}
+			}
+		}
+	}
+}
+
+func (q *Queue) deliver(msg Message) {
+	ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+	defer cancel()
+
+	if err := q.sender.Send(ctx, msg); err != nil {
+		slog.Error("sending mail", "subject", msg.Subject, "error", err)
+	}
+}
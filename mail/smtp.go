@@ -0,0 +1,45 @@
+package mail
+
+import (
+	"context"
+	"goplay/config"
+	netmail "net/mail"
+	"net/smtp"
+	"strconv"
+	"time"
+)
+
+// SMTPSender sends messages through an SMTP server, using STARTTLS when the server offers it
+type SMTPSender struct {
+	addr string
+	auth smtp.Auth
+	from string
+	// envelope is the bare address of from
+	envelope string
+}
+
+// NewSMTPSender sends as from through the server in cfg
+func NewSMTPSender(cfg config.SMTP, from string) (*SMTPSender, error) {
+	address, err := netmail.ParseAddress(from)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &SMTPSender{
+		addr:     cfg.Host + ":" + strconv.Itoa(cfg.Port),
+		from:     from,
+		envelope: address.Address,
+	}
+	if len(cfg.Username) > 0 {
+		s.auth = smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.Host)
+	}
+	return s, nil
+}
+
+// Send implements Sender. net/smtp has no deadlines, so ctx is only checked before sending.
+func (s *SMTPSender) Send(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return smtp.SendMail(s.addr, s.auth, s.envelope, []string{msg.To}, format(s.from, msg, time.Now()))
+}
@@ -1,27 +1,178 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"goplay/api"
+	"goplay/config"
+	"goplay/database"
+	"goplay/graph"
+	"goplay/logging"
+	"goplay/mail"
+	"goplay/metrics"
+	"goplay/openapi"
+	"goplay/ratelimit"
+	"goplay/tracing"
+	"goplay/worker"
 	"log"
 	"net/http"
 	"os"
-	"time"
+	"os/signal"
+	"strconv"
+	"syscall"
 
-	jwtmiddleware "github.com/auth0/go-jwt-middleware"
-	"github.com/dgrijalva/jwt-go"
 	"github.com/gorilla/mux"
 	"github.com/rs/cors"
 	"github.com/urfave/negroni"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(migrateCommand(os.Args[2:]))
+	}
+
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := logging.Setup(os.Stdout, cfg.Log.Level); err != nil {
+		log.Fatal(err)
+	}
+
+	if cfg.Auth.JWTSecret == config.DefaultJWTSecret {
+		log.Println("Warning: tokens are signed with the default secret, set auth.jwt_secret or GOPLAY_JWT_SECRET")
+	}
+
+	// ctx is cancelled on SIGINT/SIGTERM, which starts the shutdown
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		log.Println("Received", sig, "shutting down")
+		cancel()
+	}()
+
+	shutdownTracing, err := tracing.Setup(ctx, cfg.Tracing)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	openCtx, cancelOpen := context.WithTimeout(ctx, cfg.Database.ConnectTimeout)
+	err = database.Open(openCtx, cfg.Database)
+	if err == nil {
+		err = database.EnsureSchema(openCtx)
+	}
+	if err == nil && cfg.Database.Driver == "mongo" {
+		err = warnPendingMigrations(openCtx)
+	}
+	cancelOpen()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	workers := worker.NewGroup()
+
+	handler, spec, err := newRouter(cfg, workers)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := spec.Check(); err != nil {
+		log.Println("Warning: the OpenAPI document is incomplete,", err)
+	}
+
+	port := strconv.Itoa(cfg.Server.Port)
+	fmt.Println("Listening on: http://localhost:" + port)
+
+	srv := &http.Server{
+		Handler: handler,
+		Addr:    ":" + port,
+		// Good practice: enforce timeouts for servers you create!
+		WriteTimeout: cfg.Server.WriteTimeout,
+		ReadTimeout:  cfg.Server.ReadTimeout,
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	exitCode := 0
+	select {
+	case err := <-serveErr:
+		log.Println("Server stopped:", err)
+		exitCode = 1
+	case <-ctx.Done():
+	}
+
+	// Drain requests first, then stop the workers, then let go of the database they all use.
+	// Spans go last so the ones recorded during shutdown are flushed too.
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancelShutdown()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Println("Error draining requests:", err)
+	}
+	if err := workers.Stop(shutdownCtx); err != nil {
+		log.Println("Error stopping workers:", err)
+	}
+	if err := database.Close(shutdownCtx); err != nil {
+		log.Println("Error disconnecting from the database:", err)
+	}
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		log.Println("Error flushing spans:", err)
+	}
+	log.Println("Bye")
+
+	if exitCode != 0 {
+		os.Exit(exitCode)
+	}
+}
+
+// newRouter registers every route behind the middleware the config asks for, and
+// returns the OpenAPI document loaded from them
+func newRouter(cfg config.Config, workers *worker.Group) (http.Handler, *openapi.Spec, error) {
+	mailer, err := mail.New(cfg.Mail)
+	if err != nil {
+		return nil, nil, err
+	}
+	mailQueue := mail.NewQueue(mailer, 100)
+	workers.Go("mail", mailQueue.Run)
+
+	// The rate limit store also counts the failed logins of unknown usernames, so it's
+	// needed with the limits off too
+	limits, err := ratelimit.NewStore(cfg.RateLimit)
+	if err != nil {
+		return nil, nil, err
+	}
+	workers.Go("ratelimit", limits.Run)
+
+	auth := api.NewAuth(cfg.Auth, mailQueue, limits)
+
 	r := mux.NewRouter()
+	r.Use(metrics.Middleware, logging.Route, tracing.Route)
 	r.Use(mux.CORSMethodMiddleware(r))
 
 	authenticatedRouter := mux.NewRouter().PathPrefix("/api").Subrouter().StrictSlash(true)
+	authenticatedRouter.Use(metrics.Middleware, logging.Route, tracing.Route)
+
+	authenticatedRouter.HandleFunc("/profile", api.GetAccountHandler).Methods(http.MethodGet, http.MethodOptions)
+	authenticatedRouter.HandleFunc("/account", api.GetAccountHandler).Methods(http.MethodGet, http.MethodOptions)
+	authenticatedRouter.HandleFunc("/account", auth.UpdateAccountHandler).Methods(http.MethodPatch, http.MethodOptions)
+	authenticatedRouter.HandleFunc("/account", auth.DeleteAccountHandler).Methods(http.MethodDelete, http.MethodOptions)
+	authenticatedRouter.HandleFunc("/account/email/verify", auth.ResendVerificationHandler).Methods(http.MethodPost, http.MethodOptions)
+	authenticatedRouter.HandleFunc("/account/password", auth.ChangePasswordHandler).Methods(http.MethodPost, http.MethodOptions)
+	authenticatedRouter.HandleFunc("/account/2fa", auth.EnrollTwoFactorHandler).Methods(http.MethodPost, http.MethodOptions)
+	authenticatedRouter.HandleFunc("/account/2fa", auth.DisableTwoFactorHandler).Methods(http.MethodDelete, http.MethodOptions)
+	authenticatedRouter.HandleFunc("/account/2fa/confirm", auth.ConfirmTwoFactorHandler).Methods(http.MethodPost, http.MethodOptions)
 
-	authenticatedRouter.HandleFunc("/profile", api.ProfileHandler).Methods(http.MethodGet, http.MethodOptions)
+	// API keys
+	authenticatedRouter.HandleFunc("/keys", api.GetAPIKeysHandler).Methods(http.MethodGet, http.MethodOptions)
+	authenticatedRouter.HandleFunc("/keys", auth.RequireVerified(api.CreateAPIKeyHandler)).Methods(http.MethodPost, http.MethodOptions)
+	authenticatedRouter.HandleFunc("/keys/{_id}", api.DeleteAPIKeyHandler).Methods(http.MethodDelete, http.MethodOptions)
 
 	// Logs
 	authenticatedRouter.HandleFunc("/logs", api.CreateLogHandler).Methods(http.MethodPost, http.MethodOptions)
@@ -42,50 +193,70 @@ func main() {
 	authenticatedRouter.HandleFunc("/identities/{_id}", api.UpdateIdentityHandler).Methods(http.MethodPut, http.MethodOptions)
 	authenticatedRouter.HandleFunc("/identities/{_id}", api.DeleteIdentityHandler).Methods(http.MethodDelete, http.MethodOptions)
 
-	r.HandleFunc("/register", api.RegisterHandler).Methods(http.MethodPost, http.MethodOptions)
-	r.HandleFunc("/login", api.LoginHandler).Methods(http.MethodPost, http.MethodOptions)
-
-	// Middleware: https://github.com/urfave/negroni
-	jwtMiddleware := jwtmiddleware.New(jwtmiddleware.Options{
-		Debug: false,
-		ValidationKeyGetter: func(token *jwt.Token) (interface{}, error) {
-			return []byte("jonapi"), nil
-		},
-		// When set, the middleware verifies that tokens are signed with the specific signing algorithm
-		// If the signing method is not constant the ValidationKeyGetter callback can be used to implement additional checks
-		// Important to avoid security issues described here: https://auth0.com/blog/2015/03/31/critical-vulnerabilities-in-json-web-token-libraries/
-		SigningMethod: jwt.SigningMethodHS256,
-	})
+	// Export
+	authenticatedRouter.HandleFunc("/export/markdown", auth.RequireVerified(api.ExportMarkdownHandler)).Methods(http.MethodGet, http.MethodOptions)
+
+	// Calendar feed
+	authenticatedRouter.HandleFunc("/ical/token", auth.RequireVerified(api.GetCalendarTokenHandler)).Methods(http.MethodGet, http.MethodOptions)
+	authenticatedRouter.HandleFunc("/ical/token", auth.RequireVerified(api.RotateCalendarTokenHandler)).Methods(http.MethodPost, http.MethodOptions)
+
+	// Anonymous auth routes are limited per client ip, the api per user
+	limitAuth, limitAPI := noLimit, noLimit
+	if cfg.RateLimit.Enabled {
+		limitAuth = ratelimit.New("auth", limits, cfg.RateLimit.Auth, ratelimit.ClientIP(cfg.RateLimit.TrustProxy)).Middleware
+		limitAPI = ratelimit.New("api", limits, cfg.RateLimit.API, api.TokenUsername).Middleware
+	}
+
+	r.Handle("/register", limitAuth(http.HandlerFunc(auth.RegisterHandler))).Methods(http.MethodPost, http.MethodOptions)
+	r.Handle("/login", limitAuth(http.HandlerFunc(auth.LoginHandler))).Methods(http.MethodPost, http.MethodOptions)
+	r.Handle("/login/2fa", limitAuth(http.HandlerFunc(auth.LoginTwoFactorHandler))).Methods(http.MethodPost, http.MethodOptions)
+	r.Handle("/password/forgot", limitAuth(http.HandlerFunc(auth.ForgotPasswordHandler))).Methods(http.MethodPost, http.MethodOptions)
+	r.Handle("/password/reset", limitAuth(http.HandlerFunc(auth.ResetPasswordHandler))).Methods(http.MethodPost, http.MethodOptions)
+	r.Handle("/email/verify", limitAuth(http.HandlerFunc(auth.VerifyEmailHandler))).Methods(http.MethodPost, http.MethodOptions)
+	r.HandleFunc("/ical/{token}.ics", api.CalendarHandler).Methods(http.MethodGet)
 
 	n := negroni.New(
-		negroni.HandlerFunc(jwtMiddleware.HandlerWithNext),
-		negroni.Wrap(authenticatedRouter))
+		auth.Middleware(),
+		negroni.Wrap(limitAPI(authenticatedRouter)))
 
 	r.PathPrefix("/api").Handler(n)
 
-	port := os.Getenv("SERVER_PORT")
-
-	if len(port) == 0 {
-		port = "5000"
-	}
+	r.Handle("/graphql", negroni.New(
+		auth.Middleware(),
+		negroni.Wrap(limitAPI(graph.Handler())))).Methods(http.MethodPost, http.MethodOptions)
 
-	fmt.Println("Listening on: http://localhost:" + port)
+	// Every route registered above has to be described in docs.go, main_test.go checks it
+	spec := openapi.New("goplay", "1.0.0")
+	describeRoutes(spec)
+	r.Handle("/openapi.json", spec).Methods(http.MethodGet, http.MethodOptions)
 
 	c := cors.New(cors.Options{
-		AllowedOrigins:   []string{"http://localhost:8080", "http://frontend:8080"},
+		AllowedOrigins:   cfg.CORS.AllowedOrigins,
 		AllowCredentials: true,
-		AllowedHeaders:   []string{"Authorization", "Content-Type"},
-		AllowedMethods:   []string{"GET", "PUT", "POST", "DELETE"},
+		AllowedHeaders:   []string{"Authorization", "Content-Type", "traceparent", "tracestate"},
+		AllowedMethods:   []string{"GET", "PUT", "PATCH", "POST", "DELETE"},
+		ExposedHeaders:   []string{"Retry-After", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "RateLimit-Policy"},
 		Debug:            false,
 	})
 
-	srv := &http.Server{
-		Handler: c.Handler(r),
-		Addr:    ":" + port,
-		// Good practice: enforce timeouts for servers you create!
-		WriteTimeout: 15 * time.Second,
-		ReadTimeout:  15 * time.Second,
+	// Probes and metrics for the orchestrator stay outside of the CORS and JWT chain
+	probes := mux.NewRouter()
+	probes.Use(metrics.Middleware, logging.Route, tracing.Route)
+	probes.HandleFunc("/healthz", api.HealthzHandler).Methods(http.MethodGet)
+	probes.HandleFunc("/readyz", api.ReadyzHandler(workers)).Methods(http.MethodGet)
+	probes.HandleFunc("/version", api.VersionHandler).Methods(http.MethodGet)
+	probes.Handle("/metrics", metrics.Handler()).Methods(http.MethodGet)
+	probes.NotFoundHandler = c.Handler(r)
+
+	if err := spec.Load(r, authenticatedRouter, probes); err != nil {
+		return nil, nil, err
 	}
 
-	log.Fatal(srv.ListenAndServe())
+	// Every request gets an id, an access log line and a span, whichever router ends up serving it
+	return logging.Middleware(tracing.Middleware(probes)), spec, nil
+}
+
+// noLimit stands in for the rate limits when they are disabled
+func noLimit(next http.Handler) http.Handler {
+	return next
 }
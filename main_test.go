@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"goplay/config"
+	"goplay/openapi"
+	"goplay/worker"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestEveryRouteIsDescribed(t *testing.T) {
+	cfg := config.Default()
+	cfg.Mail.Dir = t.TempDir()
+	cfg.RateLimit.Enabled = false
+
+	workers := worker.NewGroup()
+	defer workers.Stop(context.Background())
+
+	_, spec, err := newRouter(cfg, workers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The server only warns about the routes docs.go misses, this is where they fail
+	if err := spec.Check(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUndescribedRouteFailsCheck(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {}
+
+	spec := openapi.New("goplay", "test")
+	spec.Describe(openapi.Operation{Method: http.MethodGet, Path: "/described", Summary: "Described"})
+
+	r := mux.NewRouter()
+	r.HandleFunc("/described", handler).Methods(http.MethodGet)
+	r.HandleFunc("/undescribed", handler).Methods(http.MethodGet, http.MethodOptions)
+
+	if err := spec.Load(r); err != nil {
+		t.Fatal(err)
+	}
+
+	err := spec.Check()
+	if err == nil {
+		t.Fatal("Check with an undescribed route succeeded")
+	}
+	if !strings.Contains(err.Error(), "GET /undescribed") {
+		t.Errorf("Check error %q doesn't name GET /undescribed", err)
+	}
+
+	// The document still serves the described routes
+	rec := httptest.NewRecorder()
+	spec.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	if body := rec.Body.String(); !strings.Contains(body, `"/described"`) || strings.Contains(body, `"/undescribed"`) {
+		t.Errorf("document %s should have /described and not /undescribed", body)
+	}
+}
@@ -0,0 +1,119 @@
+// Package metrics exposes Prometheus metrics for http handlers, database calls and business events
+package metrics
+
+import (
+	"context"
+	"goplay/response"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "goplay_http_requests_total",
+		Help: "HTTP requests by route template, method and status code.",
+	}, []string{"route", "method", "code"})
+
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "goplay_http_request_duration_seconds",
+		Help:    "HTTP request latency by route template and method.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	dbDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "goplay_db_operation_duration_seconds",
+		Help:    "Database operation latency by collection and operation.",
+		Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"collection", "operation"})
+
+	dbErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "goplay_db_operation_errors_total",
+		Help: "Failed database operations by collection and operation.",
+	}, []string{"collection", "operation"})
+
+	// LogsCreated counts new logs, use increase(goplay_logs_created_total[1d]) for logs per day
+	LogsCreated = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "goplay_logs_created_total",
+		Help: "Logs created.",
+	})
+
+	// HabitsCreated counts new habits
+	HabitsCreated = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "goplay_habits_created_total",
+		Help: "Habits created.",
+	})
+
+	// UsersRegistered counts successful registrations
+	UsersRegistered = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "goplay_users_registered_total",
+		Help: "Users registered.",
+	})
+
+	// RateLimited counts requests refused with a 429 by policy
+	RateLimited = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "goplay_rate_limited_total",
+		Help: "Requests refused by the rate limiter by policy.",
+	}, []string{"policy"})
+)
+
+func init() {
+	prometheus.MustRegister(httpRequests, httpDuration, dbDuration, dbErrors, LogsCreated, HabitsCreated, UsersRegistered, RateLimited)
+}
+
+// Handler serves the metrics in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// recordedKey marks in the request context whether a nested router recorded the request
+type recordedKey struct{}
+
+// Middleware records every request matched by a mux router under its route template,
+// so ids in paths don't blow up the number of series. Use it with Router.Use on
+// every router: when routers are nested the innermost match wins, so requests
+// rejected before reaching the inner router (e.g. 401s) still count under the prefix.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := mux.CurrentRoute(r)
+		if route == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		template, err := route.GetPathTemplate()
+		if err != nil {
+			template = "unknown"
+		}
+
+		parent, _ := r.Context().Value(recordedKey{}).(*bool)
+		recorded := new(bool)
+		r = r.WithContext(context.WithValue(r.Context(), recordedKey{}, recorded))
+
+		start := time.Now()
+		rec := response.Record(w)
+		next.ServeHTTP(rec, r)
+
+		if *recorded {
+			return
+		}
+		if parent != nil {
+			*parent = true
+		}
+
+		httpDuration.WithLabelValues(template, r.Method).Observe(time.Since(start).Seconds())
+		httpRequests.WithLabelValues(template, r.Method, strconv.Itoa(rec.Status)).Inc()
+	})
+}
+
+// ObserveDB records the duration and outcome of a database operation
+func ObserveDB(collection string, operation string, duration time.Duration, failed bool) {
+	dbDuration.WithLabelValues(collection, operation).Observe(duration.Seconds())
+	if failed {
+		dbErrors.WithLabelValues(collection, operation).Inc()
+	}
+}
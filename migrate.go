@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"goplay/config"
+	"goplay/database"
+	"goplay/migrations"
+	"log"
+	"os"
+	"time"
+)
+
+const migrateUsage = `usage: %s migrate [flags] [status|up|down]
+
+  status   list the migrations and whether they are applied (default)
+  up       apply the pending migrations, up to -to
+  down     revert the latest migration, or every one above -to
+
+flags:
+`
+
+// migrateCommand runs the migrate subcommand and returns the exit code
+func migrateCommand(args []string) int {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), migrateUsage, os.Args[0])
+		fs.PrintDefaults()
+	}
+	to := fs.Int("to", migrations.Latest, "target version")
+	dryRun := fs.Bool("dry-run", false, "print the steps and the documents they would change without running them")
+	timeout := fs.Duration("timeout", time.Hour, "give up after this long")
+
+	cfg, err := config.LoadFlags(fs, args)
+	if err == flag.ErrHelp {
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	if cfg.Database.Driver != "mongo" {
+		fmt.Fprintf(os.Stderr, "migrate only applies to mongo, the %s schema is migrated when the server starts\n", cfg.Database.Driver)
+		return 2
+	}
+
+	action := "status"
+	if fs.NArg() > 0 {
+		action = fs.Arg(0)
+	}
+	if fs.NArg() > 1 || (action != "status" && action != "up" && action != "down") {
+		fs.Usage()
+		return 2
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	openCtx, cancelOpen := context.WithTimeout(ctx, cfg.Database.ConnectTimeout)
+	err = database.Open(openCtx, cfg.Database)
+	cancelOpen()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer database.Close(context.Background())
+
+	runner, err := migrations.New(database.DB, migrations.All)
+	if err == nil {
+		err = migrate(ctx, runner, action, *to, *dryRun)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// warnPendingMigrations logs the migrations the server expects but the database hasn't had.
+// The server doesn't run them itself, so replicas of different versions can't fight over them.
+func warnPendingMigrations(ctx context.Context) error {
+	runner, err := migrations.New(database.DB, migrations.All)
+	if err != nil {
+		return err
+	}
+	steps, err := runner.Plan(ctx, true, migrations.Latest)
+	if err != nil {
+		return err
+	}
+	for _, step := range steps {
+		log.Printf("Warning: migration %04d %s is pending, run the migrate subcommand", step.Migration.Version, step.Migration.Name)
+	}
+	return nil
+}
+
+func migrate(ctx context.Context, runner *migrations.Runner, action string, to int, dryRun bool) error {
+	if action == "status" {
+		applied, err := runner.Applied(ctx)
+		if err != nil {
+			return err
+		}
+		for _, m := range runner.Migrations() {
+			state := "pending"
+			if record, ok := applied[m.Version]; ok {
+				state = "applied " + record.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d  %-40s %s\n", m.Version, m.Name, state)
+		}
+		return nil
+	}
+
+	up := action == "up"
+	target := to
+	if !up && target == migrations.Latest {
+		previous, err := runner.Previous(ctx)
+		if err != nil {
+			return err
+		}
+		target = previous
+	}
+
+	if dryRun {
+		steps, err := runner.DryRun(ctx, up, target)
+		if err != nil {
+			return err
+		}
+		if len(steps) == 0 {
+			fmt.Println("Nothing to do")
+		}
+		for _, step := range steps {
+			fmt.Println("would", step)
+		}
+		return nil
+	}
+
+	count := 0
+	err := runner.Run(ctx, up, target, func(step migrations.Step) {
+		count++
+		fmt.Println("done:", step)
+	})
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		fmt.Println("Nothing to do")
+	}
+	return nil
+}
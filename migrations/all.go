@@ -0,0 +1,90 @@
+package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// All is every migration of the goplay database. Append new ones with the next version;
+// never renumber or edit one that was released.
+var All = []Migration{
+	{
+		Version: 1,
+		Name:    "drop stored tokens from users",
+		// Registration stored the empty token field of the request body
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			_, err := db.Collection("users").UpdateMany(ctx, storedToken, bson.D{{"$unset", bson.D{{"token", ""}}}})
+			return err
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			// Nothing worth restoring, tokens were never read back
+			return nil
+		},
+		Affected: func(ctx context.Context, db *mongo.Database) (int64, error) {
+			return db.Collection("users").CountDocuments(ctx, storedToken)
+		},
+	},
+	{
+		Version: 2,
+		Name:    "rename habits sharing a name",
+		// Logs refer to habits by name and the habits of an owner now have a unique index on it.
+		// The oldest habit keeps its name, the later ones get their id added to it.
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			duplicates, err := sharedHabitNames(ctx, db)
+			if err != nil {
+				return err
+			}
+			for _, duplicate := range duplicates {
+				for _, id := range duplicate.IDs[1:] {
+					name := duplicate.Key.Name + " (" + id.Hex() + ")"
+					if _, err := db.Collection("habits").UpdateOne(ctx, bson.D{{"_id", id}}, bson.D{{"$set", bson.D{{"name", name}}}}); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		},
+		// Nothing tells the renamed habits apart from ones named like that, and the index
+		// would keep the old names from coming back anyway
+		Down: nil,
+		Affected: func(ctx context.Context, db *mongo.Database) (int64, error) {
+			duplicates, err := sharedHabitNames(ctx, db)
+			var affected int64
+			for _, duplicate := range duplicates {
+				affected += int64(len(duplicate.IDs) - 1)
+			}
+			return affected, err
+		},
+	},
+}
+
+var storedToken = bson.D{{"token", bson.D{{"$exists", true}}}}
+
+// sharedHabitName is a name used by several habits of the same owner, with their ids oldest first
+type sharedHabitName struct {
+	Key struct {
+		UserID primitive.ObjectID `bson:"user_id"`
+		Name   string             `bson:"name"`
+	} `bson:"_id"`
+	IDs []primitive.ObjectID `bson:"ids"`
+}
+
+func sharedHabitNames(ctx context.Context, db *mongo.Database) ([]sharedHabitName, error) {
+	cur, err := db.Collection("habits").Aggregate(ctx, mongo.Pipeline{
+		{{"$sort", bson.D{{"_id", 1}}}},
+		{{"$group", bson.D{
+			{"_id", bson.D{{"user_id", "$user_id"}, {"name", "$name"}}},
+			{"ids", bson.D{{"$push", "$_id"}}},
+		}}},
+		{{"$match", bson.D{{"ids.1", bson.D{{"$exists", true}}}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var results []sharedHabitName
+	err = cur.All(ctx, &results)
+	return results, err
+}
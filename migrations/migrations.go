@@ -0,0 +1,261 @@
+// Package migrations applies and reverts versioned changes to the documents in the database.
+// Applied versions are recorded in the migrations collection, and a lease in migrations_lock
+// keeps replicas starting at the same time from running them twice.
+package migrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Latest is the target version that applies every migration
+const Latest = -1
+
+// lockTTL is how long the lock is held without being renewed. It is renewed before every step,
+// so a single step running longer than this could let another runner in.
+const lockTTL = 10 * time.Minute
+
+// lockID is the _id of the lock document
+const lockID = "lock"
+
+// ErrLocked is returned when another runner holds the lock
+var ErrLocked = errors.New("migrations: another migration is running")
+
+// Migration is a versioned change to the documents in the database
+type Migration struct {
+	// Version orders migrations, starting at 1
+	Version int
+	Name    string
+	Up      func(ctx context.Context, db *mongo.Database) error
+	// Down reverts Up. It is nil for migrations that can't be reverted.
+	Down func(ctx context.Context, db *mongo.Database) error
+	// Affected counts the documents Up would change, for dry runs. It is optional.
+	Affected func(ctx context.Context, db *mongo.Database) (int64, error)
+}
+
+// Record is what the migrations collection stores of an applied migration
+type Record struct {
+	Version   int       `bson:"_id"`
+	Name      string    `bson:"name"`
+	AppliedAt time.Time `bson:"applied_at"`
+}
+
+// Step is a migration to apply or revert
+type Step struct {
+	Migration Migration
+	// Up is false when the migration is reverted
+	Up bool
+	// Affected is the number of documents Up would change, -1 when unknown
+	Affected int64
+}
+
+func (s Step) String() string {
+	direction := "revert"
+	if s.Up {
+		direction = "apply"
+	}
+	text := fmt.Sprintf("%s %04d %s", direction, s.Migration.Version, s.Migration.Name)
+	if s.Affected >= 0 {
+		text += fmt.Sprintf(" (%d documents)", s.Affected)
+	}
+	return text
+}
+
+// Runner applies migrations to a database
+type Runner struct {
+	db         *mongo.Database
+	migrations []Migration
+	records    *mongo.Collection
+	lock       *mongo.Collection
+	owner      string
+}
+
+// New creates a runner for migrations, which need distinct positive versions
+func New(db *mongo.Database, migrations []Migration) (*Runner, error) {
+	sorted := append([]Migration(nil), migrations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+	for i, m := range sorted {
+		if m.Version < 1 || m.Up == nil {
+			return nil, fmt.Errorf("migrations: %04d %s needs a positive version and an Up step", m.Version, m.Name)
+		}
+		if i > 0 && sorted[i-1].Version == m.Version {
+			return nil, fmt.Errorf("migrations: version %04d is used twice", m.Version)
+		}
+	}
+
+	host, _ := os.Hostname()
+	return &Runner{
+		db:         db,
+		migrations: sorted,
+		records:    db.Collection("migrations"),
+		lock:       db.Collection("migrations_lock"),
+		owner:      fmt.Sprintf("%s/%d/%d", host, os.Getpid(), time.Now().UnixNano()),
+	}, nil
+}
+
+// Applied returns the records of the applied migrations by version
+func (r *Runner) Applied(ctx context.Context) (map[int]Record, error) {
+	cur, err := r.records.Find(ctx, bson.D{})
+	if err != nil {
+		return nil, err
+	}
+	var records []Record
+	if err := cur.All(ctx, &records); err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int]Record, len(records))
+	for _, record := range records {
+		applied[record.Version] = record
+	}
+	return applied, nil
+}
+
+// Migrations returns the known migrations by increasing version
+func (r *Runner) Migrations() []Migration {
+	return r.migrations
+}
+
+// Previous returns the version below the latest applied one, the target that reverts
+// the latest migration. It is 0 when at most one migration is applied.
+func (r *Runner) Previous(ctx context.Context) (int, error) {
+	applied, err := r.Applied(ctx)
+	if err != nil {
+		return 0, err
+	}
+	versions := make([]int, 0, len(applied))
+	for version := range applied {
+		versions = append(versions, version)
+	}
+	sort.Ints(versions)
+	if len(versions) < 2 {
+		return 0, nil
+	}
+	return versions[len(versions)-2], nil
+}
+
+// Plan lists the steps that bring the database to target. Going up applies the pending
+// migrations up to target, oldest first; use Latest for all of them. Going down reverts the
+// applied migrations above target, newest first.
+func (r *Runner) Plan(ctx context.Context, up bool, target int) ([]Step, error) {
+	applied, err := r.Applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var steps []Step
+	if up {
+		for _, m := range r.migrations {
+			if _, ok := applied[m.Version]; !ok && (target == Latest || m.Version <= target) {
+				steps = append(steps, Step{Migration: m, Up: true, Affected: -1})
+			}
+		}
+		return steps, nil
+	}
+
+	for i := len(r.migrations) - 1; i >= 0; i-- {
+		m := r.migrations[i]
+		if _, ok := applied[m.Version]; ok && m.Version > target {
+			if m.Down == nil {
+				return nil, fmt.Errorf("migrations: %04d %s can't be reverted", m.Version, m.Name)
+			}
+			steps = append(steps, Step{Migration: m, Affected: -1})
+		}
+	}
+	return steps, nil
+}
+
+// DryRun plans the steps to target and counts the documents each would change, changing nothing
+func (r *Runner) DryRun(ctx context.Context, up bool, target int) ([]Step, error) {
+	steps, err := r.Plan(ctx, up, target)
+	if err != nil {
+		return nil, err
+	}
+	for i, step := range steps {
+		if step.Up && step.Migration.Affected != nil {
+			if steps[i].Affected, err = step.Migration.Affected(ctx, r.db); err != nil {
+				return nil, fmt.Errorf("migrations: counting %04d %s: %v", step.Migration.Version, step.Migration.Name, err)
+			}
+		}
+	}
+	return steps, nil
+}
+
+// Run brings the database to target while holding the lock. done is called after each step.
+// It stops at the first failing step; the steps before it stay applied.
+func (r *Runner) Run(ctx context.Context, up bool, target int, done func(Step)) error {
+	if err := r.acquire(ctx); err != nil {
+		return err
+	}
+	defer r.release()
+
+	// Planned under the lock, so another runner's steps are seen
+	steps, err := r.Plan(ctx, up, target)
+	if err != nil {
+		return err
+	}
+
+	for _, step := range steps {
+		if err := r.acquire(ctx); err != nil {
+			return err
+		}
+		if err := r.run(ctx, step); err != nil {
+			return fmt.Errorf("migrations: %s: %v", step, err)
+		}
+		if done != nil {
+			done(step)
+		}
+	}
+	return nil
+}
+
+func (r *Runner) run(ctx context.Context, step Step) error {
+	m := step.Migration
+	if !step.Up {
+		if err := m.Down(ctx, r.db); err != nil {
+			return err
+		}
+		_, err := r.records.DeleteOne(ctx, bson.D{{"_id", m.Version}})
+		return err
+	}
+
+	if err := m.Up(ctx, r.db); err != nil {
+		return err
+	}
+	_, err := r.records.InsertOne(ctx, Record{Version: m.Version, Name: m.Name, AppliedAt: time.Now().UTC()})
+	return err
+}
+
+// acquire takes or renews the lock. Locks left behind by crashed runners expire after lockTTL.
+func (r *Runner) acquire(ctx context.Context) error {
+	now := time.Now()
+	filter := bson.D{
+		{"_id", lockID},
+		{"$or", bson.A{
+			bson.D{{"owner", r.owner}},
+			bson.D{{"expires_at", bson.D{{"$lt", now}}}},
+		}},
+	}
+	update := bson.D{{"$set", bson.D{{"owner", r.owner}, {"expires_at", now.Add(lockTTL)}}}}
+
+	// When the lock is held the filter doesn't match and the upsert collides with it
+	_, err := r.lock.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrLocked
+	}
+	return err
+}
+
+func (r *Runner) release() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	r.lock.DeleteOne(ctx, bson.D{{"_id", lockID}, {"owner", r.owner}})
+}
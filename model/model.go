@@ -1,27 +1,204 @@
 package model
 
-import "go.mongodb.org/mongo-driver/bson/primitive"
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
 
 // User
 type User struct {
 	OID       primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
-	Username  string             `json:"username"`
-	FirstName string             `json:"firstname"`
-	LastName  string             `json:"lastname"`
-	Password  string             `json:"password"`
-	Token     string             `json:"token"`
+	Username  string             `json:"username" validate:"required,min=3,max=32,username"`
+	FirstName string             `json:"firstname" validate:"max=64"`
+	LastName  string             `json:"lastname" validate:"max=64"`
+	Email     string             `json:"email,omitempty" bson:"email,omitempty" validate:"omitempty,email,max=254"`
+	Password  string             `json:"password" validate:"required"`
+	// Token is only ever sent, never stored
+	Token string `json:"token" bson:"-"`
+
+	// EmailVerified is set once the owner of Email followed a verification link
+	EmailVerified bool `json:"-" bson:"email_verified,omitempty"`
+
+	Preferences Preferences `json:"preferences" bson:"preferences,omitempty"`
+
+	CalendarToken string `json:"-" bson:"calendar_token,omitempty"`
+
+	// FailedLogins counts the failed logins since the last success or lockout
+	FailedLogins int       `json:"-" bson:"failed_logins,omitempty"`
+	LockedUntil  time.Time `json:"-" bson:"locked_until,omitempty"`
+
+	// SessionsValidAfter revokes the tokens issued up to it, to the millisecond
+	SessionsValidAfter time.Time `json:"-" bson:"sessions_valid_after,omitempty"`
+
+	// TOTPSecret turns on two-factor authentication. PendingTOTPSecret waits for its first code.
+	TOTPSecret        string `json:"-" bson:"totp_secret,omitempty"`
+	PendingTOTPSecret string `json:"-" bson:"pending_totp_secret,omitempty"`
+	// TOTPLastStep is the time step of the last accepted code, so codes can't be replayed
+	TOTPLastStep int64 `json:"-" bson:"totp_last_step,omitempty"`
+	// RecoveryCodes are the hashes of the unused recovery codes
+	RecoveryCodes []string `json:"-" bson:"recovery_codes,omitempty"`
+}
+
+// TwoFactorEnrollment is a new authenticator secret to add to an app and confirm with a code
+type TwoFactorEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+	// QRCode is the otpauth URI as a base64 encoded PNG
+	QRCode []byte `json:"qr_png"`
+}
+
+// TwoFactorCode is a code of the authenticator app, or a recovery code
+type TwoFactorCode struct {
+	Code string `json:"code"`
+}
+
+// RecoveryCodes are shown once, when two-factor authentication is turned on
+type RecoveryCodes struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// LoginChallenge answers a correct password when the account uses two-factor authentication.
+// The challenge and a code are exchanged for a token at /login/2fa.
+type LoginChallenge struct {
+	TwoFactorRequired bool   `json:"two_factor_required"`
+	Challenge         string `json:"challenge"`
+}
+
+// TwoFactorLogin completes a login challenge
+type TwoFactorLogin struct {
+	Challenge string `json:"challenge"`
+	Code      string `json:"code"`
+}
+
+// Preferences are the settings a user picks for themselves
+type Preferences struct {
+	// TimeZone is the IANA time zone days are split in, UTC when empty
+	TimeZone string `json:"timezone" bson:"timezone,omitempty" validate:"omitempty,timezone"`
+}
+
+// Account is the profile of a user as they see it, without any secrets
+type Account struct {
+	ID               primitive.ObjectID `json:"id"`
+	Username         string             `json:"username"`
+	FirstName        string             `json:"firstname"`
+	LastName         string             `json:"lastname"`
+	Email            string             `json:"email,omitempty"`
+	EmailVerified    bool               `json:"email_verified"`
+	Preferences      Preferences        `json:"preferences"`
+	TwoFactorEnabled bool               `json:"two_factor_enabled"`
+}
+
+// AccountUpdate changes the fields of an account that are set
+type AccountUpdate struct {
+	FirstName *string `json:"firstname" validate:"omitempty,max=64"`
+	LastName  *string `json:"lastname" validate:"omitempty,max=64"`
+	// Email is removed when set to "". A new address has to be verified again.
+	Email       *string      `json:"email" validate:"omitempty,email,max=254"`
+	Preferences *Preferences `json:"preferences"`
+	// CurrentPassword confirms a change of the email, which reset links are sent to
+	CurrentPassword string `json:"current_password,omitempty"`
+	// Code of the authenticator, or a recovery code, also confirms it when two-factor authentication is on
+	Code string `json:"code,omitempty"`
+}
+
+// EmailVerification verifies an email address with the token of a verification link
+type EmailVerification struct {
+	Token string `json:"token"`
+}
+
+// AccountDeletion confirms the deletion of an account
+type AccountDeletion struct {
+	Password string `json:"password"`
+	// Code of the authenticator, or a recovery code, when two-factor authentication is on
+	Code string `json:"code,omitempty"`
+}
+
+// PasswordChange is the body of a password change by a logged in user
+type PasswordChange struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+// PasswordForgotten asks for a reset link to be sent to the email of an account
+type PasswordForgotten struct {
+	Email string `json:"email"`
+}
+
+// PasswordReset sets a new password with the token of a reset link
+type PasswordReset struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// PasswordResetToken is a single use reset link. Only a hash of the token is stored.
+type PasswordResetToken struct {
+	ID        *primitive.ObjectID `bson:"_id,omitempty"`
+	UserID    primitive.ObjectID  `bson:"user_id"`
+	TokenHash string              `bson:"token_hash"`
+	ExpiresAt time.Time           `bson:"expires_at"`
+}
+
+// Scopes of API keys
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+)
+
+// APIKey lets scripts call the api as its owner without a password. Only a hash of the key is stored.
+type APIKey struct {
+	ID     *primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserID primitive.ObjectID  `json:"user_id" bson:"user_id"`
+	Name   string              `json:"name" validate:"notblank,max=64"`
+	// Scope is read for GET requests only, or write for every method
+	Scope string `json:"scope" validate:"oneof=read write"`
+	// LogsOnly limits the key to /api/logs
+	LogsOnly bool `json:"logs_only" bson:"logs_only"`
+	// Prefix is the start of the key, to tell keys apart
+	Prefix     string     `json:"prefix"`
+	KeyHash    string     `json:"-" bson:"key_hash"`
+	CreatedAt  time.Time  `json:"created_at" bson:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at" bson:"last_used_at,omitempty"`
+}
+
+// NewAPIKey answers the creation of a key, the only time the key itself is shown
+type NewAPIKey struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+// LoginAttempt is the audit record of a login
+type LoginAttempt struct {
+	ID       *primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserID   primitive.ObjectID  `json:"user_id,omitempty" bson:"user_id,omitempty"`
+	Username string              `json:"username"`
+	Success  bool                `json:"success"`
+	// Reason says why a login failed: unknown_user, wrong_password, wrong_code or locked
+	Reason    string    `json:"reason,omitempty" bson:"reason,omitempty"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent" bson:"user_agent"`
+	RequestID string    `json:"request_id" bson:"request_id"`
+	Time      time.Time `json:"time"`
 }
 
 // ResponseResult
 type ResponseResult struct {
 	Error  string `json:"error"`
 	Result string `json:"result"`
+	// Fields explains which fields of the request were invalid
+	Fields []FieldError `json:"fields,omitempty"`
+}
+
+// FieldError is a field of a request that broke a validation rule
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
 }
 
 type Habit struct {
 	ID          *primitive.ObjectID `json:"id" bson:"_id,omitempty"`
-	Description string              `json:"description" bson:"description,omitempty"`
-	Name        string              `json:"name"`
+	Description string              `json:"description" bson:"description,omitempty" validate:"max=1000"`
+	Name        string              `json:"name" validate:"notblank,max=64"`
 	UserID      primitive.ObjectID  `json:"user_id" bson:"user_id,omitempty"`
 	IdentityID  primitive.ObjectID  `json:"identity_id,omitempty" bson:"identity_id,omitempty"`
 }
@@ -29,16 +206,16 @@ type Habit struct {
 // Log is the type for collection item
 type Log struct {
 	ID         *primitive.ObjectID `json:"id" bson:"_id,omitempty"`
-	Entry      string              `json:"entry"`
+	Entry      string              `json:"entry" validate:"notblank,max=10000"`
 	UserID     primitive.ObjectID  `json:"user_id" bson:"user_id,omitempty"`
-	Habits     []string            `json:"habits" bson:"habits,omitempty"`
+	Habits     []string            `json:"habits" bson:"habits,omitempty" validate:"max=20,dive,notblank,max=64"`
 	HabitsInfo []Habit             `json:"habits_info" bson:"habits_info,omitempty"`
 }
 
 // Identity is a parent of both Habit and Log
 type Identity struct {
 	ID          *primitive.ObjectID `json:"id" bson:"_id,omitempty"`
-	Description string              `json:"description" bson:"description,omitempty"`
-	Name        string              `json:"name"`
+	Description string              `json:"description" bson:"description,omitempty" validate:"max=1000"`
+	Name        string              `json:"name" validate:"notblank,max=64"`
 	UserID      primitive.ObjectID  `json:"user_id" bson:"user_id,omitempty"`
 }
@@ -0,0 +1,287 @@
+// Package openapi builds an OpenAPI 3 document from the registered mux routes
+// and the operations described for them
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Operation describes one method on one route
+type Operation struct {
+	Method  string
+	Path    string
+	Summary string
+	Tag     string
+	// Secured operations need the bearer token from /login
+	Secured bool
+	Query   []Parameter
+	// Request and Response are example values whose types are turned into schemas
+	Request  interface{}
+	Response interface{}
+	// ResponseType overrides the application/json content type of the response
+	ResponseType string
+}
+
+// Parameter is an optional query string parameter
+type Parameter struct {
+	Name        string
+	Description string
+}
+
+// Spec collects the operations and serves the document built from them
+type Spec struct {
+	title      string
+	version    string
+	operations map[string]Operation
+	components map[string]*Schema
+	document   []byte
+	// gaps is what Load found undescribed or unregistered, reported by Check
+	gaps error
+}
+
+// New creates an empty Spec
+func New(title string, version string) *Spec {
+	return &Spec{
+		title:      title,
+		version:    version,
+		operations: map[string]Operation{},
+		components: map[string]*Schema{},
+	}
+}
+
+// Describe adds an operation to the spec
+func (s *Spec) Describe(op Operation) {
+	s.operations[operationKey(op.Method, op.Path)] = op
+}
+
+func operationKey(method string, path string) string {
+	return strings.ToUpper(method) + " " + path
+}
+
+// Route is a method and path template registered on a router
+type Route struct {
+	Method string
+	Path   string
+}
+
+// Routes lists the routes of the routers, leaving out CORS preflights and
+// routes without methods such as mounted subrouters
+func Routes(routers ...*mux.Router) ([]Route, error) {
+	var routes []Route
+
+	for _, router := range routers {
+		err := router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+			methods, err := route.GetMethods()
+			if err != nil {
+				return nil
+			}
+			path, err := route.GetPathTemplate()
+			if err != nil {
+				return err
+			}
+
+			for _, method := range methods {
+				if method != http.MethodOptions {
+					routes = append(routes, Route{method, path})
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return routes, nil
+}
+
+// Load builds the document for the described routes of the routers, leaving the
+// others out. Check tells which routes or descriptions didn't match.
+func (s *Spec) Load(routers ...*mux.Router) error {
+	routes, err := Routes(routers...)
+	if err != nil {
+		return err
+	}
+
+	var undescribed []string
+	registered := map[string]bool{}
+
+	paths := map[string]map[string]*operation{}
+	for _, route := range routes {
+		key := operationKey(route.Method, route.Path)
+		registered[key] = true
+
+		op, ok := s.operations[key]
+		if !ok {
+			undescribed = append(undescribed, key)
+			continue
+		}
+
+		path := pathParam.ReplaceAllString(route.Path, "{$1}")
+		if paths[path] == nil {
+			paths[path] = map[string]*operation{}
+		}
+		paths[path][strings.ToLower(route.Method)] = s.operation(op, path)
+	}
+
+	var unregistered []string
+	for key := range s.operations {
+		if !registered[key] {
+			unregistered = append(unregistered, key)
+		}
+	}
+
+	s.gaps = nil
+	if len(undescribed) > 0 || len(unregistered) > 0 {
+		sort.Strings(undescribed)
+		sort.Strings(unregistered)
+		s.gaps = fmt.Errorf("openapi: routes without a description: [%s], descriptions without a route: [%s]",
+			strings.Join(undescribed, ", "), strings.Join(unregistered, ", "))
+	}
+
+	s.document, err = json.Marshal(document{
+		OpenAPI: "3.0.3",
+		Info:    info{Title: s.title, Version: s.version},
+		Paths:   paths,
+		Components: components{
+			Schemas: s.components,
+			SecuritySchemes: map[string]securityScheme{
+				"bearerAuth": {Type: "http", Scheme: "bearer", BearerFormat: "JWT"},
+			},
+		},
+	})
+	return err
+}
+
+// Check fails when the last Load found a route that has not been described or a
+// description that has no route
+func (s *Spec) Check() error {
+	return s.gaps
+}
+
+// ServeHTTP writes the document built by Load
+func (s *Spec) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(s.document)
+}
+
+// pathParam matches mux variables, which may carry a regexp after a colon
+var pathParam = regexp.MustCompile(`\{([^}:]+)(?::[^}]*)?\}`)
+
+func (s *Spec) operation(op Operation, path string) *operation {
+	o := &operation{
+		Summary:   op.Summary,
+		Responses: map[string]response{},
+	}
+	if len(op.Tag) > 0 {
+		o.Tags = []string{op.Tag}
+	}
+	if op.Secured {
+		o.Security = []map[string][]string{{"bearerAuth": {}}}
+	}
+
+	for _, match := range pathParam.FindAllStringSubmatch(path, -1) {
+		o.Parameters = append(o.Parameters, parameter{
+			Name:     match[1],
+			In:       "path",
+			Required: true,
+			Schema:   &Schema{Type: "string"},
+		})
+	}
+	for _, q := range op.Query {
+		o.Parameters = append(o.Parameters, parameter{
+			Name:        q.Name,
+			In:          "query",
+			Description: q.Description,
+			Schema:      &Schema{Type: "string"},
+		})
+	}
+
+	if op.Request != nil {
+		o.RequestBody = &requestBody{
+			Required: true,
+			Content:  map[string]mediaType{"application/json": {s.schemaOf(op.Request)}},
+		}
+	}
+
+	contentType := op.ResponseType
+	if len(contentType) == 0 {
+		contentType = "application/json"
+	}
+	schema := &Schema{Type: "string"}
+	if op.Response != nil {
+		schema = s.schemaOf(op.Response)
+	} else if !strings.HasPrefix(contentType, "text/") {
+		schema.Format = "binary"
+	}
+	o.Responses["200"] = response{
+		Description: "OK",
+		Content:     map[string]mediaType{contentType: {schema}},
+	}
+	if op.Secured {
+		o.Responses["401"] = response{Description: "Missing or invalid bearer token"}
+	}
+
+	return o
+}
+
+type document struct {
+	OpenAPI    string                           `json:"openapi"`
+	Info       info                             `json:"info"`
+	Paths      map[string]map[string]*operation `json:"paths"`
+	Components components                       `json:"components"`
+}
+
+type info struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type components struct {
+	Schemas         map[string]*Schema        `json:"schemas"`
+	SecuritySchemes map[string]securityScheme `json:"securitySchemes"`
+}
+
+type securityScheme struct {
+	Type         string `json:"type"`
+	Scheme       string `json:"scheme"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+}
+
+type operation struct {
+	Summary     string                `json:"summary,omitempty"`
+	Tags        []string              `json:"tags,omitempty"`
+	Security    []map[string][]string `json:"security,omitempty"`
+	Parameters  []parameter           `json:"parameters,omitempty"`
+	RequestBody *requestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]response   `json:"responses"`
+}
+
+type parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type requestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]mediaType `json:"content"`
+}
+
+type response struct {
+	Description string               `json:"description"`
+	Content     map[string]mediaType `json:"content,omitempty"`
+}
+
+type mediaType struct {
+	Schema *Schema `json:"schema"`
+}
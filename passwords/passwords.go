@@ -0,0 +1,126 @@
+// Package passwords hashes passwords with bcrypt or argon2id and tells when a stored
+// hash should be upgraded to the configured algorithm and parameters
+package passwords
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"goplay/config"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Algorithms accepted in auth.password_hash
+const (
+	Bcrypt   = "bcrypt"
+	Argon2id = "argon2id"
+)
+
+const (
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+)
+
+// ErrUnknownHash is returned for stored hashes that are neither bcrypt nor argon2id
+var ErrUnknownHash = errors.New("passwords: unknown hash format")
+
+// Hasher hashes new passwords with the configured algorithm and checks stored hashes of either
+type Hasher struct {
+	algorithm  string
+	bcryptCost int
+	argon2     config.Argon2
+}
+
+// New creates a hasher from the auth config
+func New(cfg config.Auth) *Hasher {
+	return &Hasher{
+		algorithm:  cfg.PasswordHash,
+		bcryptCost: cfg.BcryptCost,
+		argon2:     cfg.Argon2,
+	}
+}
+
+// Hash encodes password with the configured algorithm. The parameters are part of the
+// encoded string, so hashes stay verifiable when the config changes.
+func (h *Hasher) Hash(password string) (string, error) {
+	if h.algorithm == Argon2id {
+		salt := make([]byte, argon2SaltLength)
+		if _, err := rand.Read(salt); err != nil {
+			return "", err
+		}
+		key := argon2.IDKey([]byte(password), salt, h.argon2.Iterations, h.argon2.Memory, h.argon2.Parallelism, argon2KeyLength)
+		return encodeArgon2id(h.argon2, salt, key), nil
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.bcryptCost)
+	return string(hash), err
+}
+
+// Verify reports whether password matches the stored hash, and whether the hash should
+// be replaced because it uses another algorithm or weaker parameters than configured
+func (h *Hasher) Verify(password string, encoded string) (match bool, rehash bool, err error) {
+	if strings.HasPrefix(encoded, "$argon2id$") {
+		params, salt, key, err := decodeArgon2id(encoded)
+		if err != nil {
+			return false, false, err
+		}
+
+		other := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+		if subtle.ConstantTimeCompare(key, other) != 1 {
+			return false, false, nil
+		}
+
+		weaker := params.Memory < h.argon2.Memory || params.Iterations < h.argon2.Iterations ||
+			params.Parallelism < h.argon2.Parallelism || len(key) < argon2KeyLength
+		return true, h.algorithm != Argon2id || weaker, nil
+	}
+
+	// bcrypt hashes start with $2a$, $2b$ or $2y$ and carry their cost
+	cost, err := bcrypt.Cost([]byte(encoded))
+	if err != nil {
+		return false, false, ErrUnknownHash
+	}
+	err = bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, false, nil
+	}
+	if err != nil {
+		return false, false, err
+	}
+	return true, h.algorithm != Bcrypt || cost < h.bcryptCost, nil
+}
+
+// encodeArgon2id uses the PHC string format, e.g. $argon2id$v=19$m=65536,t=3,p=2$salt$key
+func encodeArgon2id(params config.Argon2, salt []byte, key []byte) string {
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, params.Memory, params.Iterations, params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+}
+
+func decodeArgon2id(encoded string) (params config.Argon2, salt []byte, key []byte, err error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return params, nil, nil, ErrUnknownHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("passwords: unsupported argon2 version %q", parts[2])
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return params, nil, nil, ErrUnknownHash
+	}
+
+	if salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return params, nil, nil, ErrUnknownHash
+	}
+	if key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return params, nil, nil, ErrUnknownHash
+	}
+	return params, salt, key, nil
+}
@@ -0,0 +1,91 @@
+package passwords
+
+import (
+	"goplay/config"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// light parameters, so the tests don't take the time real hashes should
+var light = config.Argon2{Memory: 1024, Iterations: 1, Parallelism: 1}
+
+func hasher(algorithm string, bcryptCost int, argon2 config.Argon2) *Hasher {
+	return New(config.Auth{PasswordHash: algorithm, BcryptCost: bcryptCost, Argon2: argon2})
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, h := range []*Hasher{
+		hasher(Bcrypt, bcrypt.MinCost, light),
+		hasher(Argon2id, bcrypt.MinCost, light),
+	} {
+		encoded, err := h.Hash("correct horse")
+		if err != nil {
+			t.Fatalf("hashing with %s: %v", h.algorithm, err)
+		}
+		if h.algorithm == Argon2id && !strings.HasPrefix(encoded, "$argon2id$v=19$m=1024,t=1,p=1$") {
+			t.Errorf("argon2id hash %q doesn't carry its parameters", encoded)
+		}
+
+		match, rehash, err := h.Verify("correct horse", encoded)
+		if !match || rehash || err != nil {
+			t.Errorf("%s: Verify of the password = %t, %t, %v, want a match without rehash", h.algorithm, match, rehash, err)
+		}
+		match, _, err = h.Verify("correct horse!", encoded)
+		if match || err != nil {
+			t.Errorf("%s: Verify of another password = %t, %v, want no match", h.algorithm, match, err)
+		}
+	}
+}
+
+func TestRehash(t *testing.T) {
+	// Hashed with more than the minimums, so lowering the parameters can be checked too
+	strong := config.Argon2{Memory: 2048, Iterations: 2, Parallelism: 1}
+	old := hasher(Bcrypt, bcrypt.MinCost+1, strong)
+	oldBcrypt, err := old.Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldArgon2, err := hasher(Argon2id, bcrypt.MinCost+1, strong).Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	moreMemory, moreIterations := strong, strong
+	moreMemory.Memory *= 2
+	moreIterations.Iterations++
+	for _, tt := range []struct {
+		name    string
+		h       *Hasher
+		encoded string
+		rehash  bool
+	}{
+		{"same bcrypt cost", old, oldBcrypt, false},
+		{"higher bcrypt cost", hasher(Bcrypt, bcrypt.MinCost+2, strong), oldBcrypt, true},
+		{"lower bcrypt cost", hasher(Bcrypt, bcrypt.MinCost, strong), oldBcrypt, false},
+		{"bcrypt to argon2id", hasher(Argon2id, bcrypt.MinCost+1, strong), oldBcrypt, true},
+		{"argon2id to bcrypt", old, oldArgon2, true},
+		{"same argon2id parameters", hasher(Argon2id, bcrypt.MinCost, strong), oldArgon2, false},
+		{"more argon2id memory", hasher(Argon2id, bcrypt.MinCost, moreMemory), oldArgon2, true},
+		{"more argon2id iterations", hasher(Argon2id, bcrypt.MinCost, moreIterations), oldArgon2, true},
+		{"weaker argon2id parameters", hasher(Argon2id, bcrypt.MinCost, light), oldArgon2, false},
+	} {
+		match, rehash, err := tt.h.Verify("correct horse", tt.encoded)
+		if !match || err != nil {
+			t.Errorf("%s: Verify = %t, %v, want a match", tt.name, match, err)
+		}
+		if rehash != tt.rehash {
+			t.Errorf("%s: rehash = %t, want %t", tt.name, rehash, tt.rehash)
+		}
+	}
+}
+
+func TestUnknownHash(t *testing.T) {
+	h := hasher(Bcrypt, bcrypt.MinCost, light)
+	for _, encoded := range []string{"", "plain", "$argon2id$v=19$m=1024", "$argon2id$v=18$m=1024,t=1,p=1$c2FsdA$a2V5"} {
+		if match, _, err := h.Verify("plain", encoded); match || err == nil {
+			t.Errorf("Verify against %q = %t, %v, want an error", encoded, match, err)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package ratelimit
+
+import (
+	"container/list"
+	"context"
+	"goplay/config"
+	"math"
+	"sync"
+	"time"
+)
+
+// maxFailureKeys caps the failure counts kept in memory. Their keys come from clients,
+// so once there are this many the least recently failed are forgotten first.
+const maxFailureKeys = 100000
+
+// MemoryStore keeps the buckets in the process, so every replica counts on its own
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	// failures indexes failureOrder, which runs from the least to the most recently failed
+	failures     map[string]*list.Element
+	failureOrder *list.List
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	// full is when the bucket is full again and can be forgotten
+	full time.Time
+}
+
+type failures struct {
+	key         string
+	count       int
+	lockedUntil time.Time
+	// forget is when neither the count nor the lock matter anymore
+	forget time.Time
+}
+
+// NewMemoryStore creates an empty store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		buckets:      map[string]*bucket{},
+		failures:     map[string]*list.Element{},
+		failureOrder: list.New(),
+	}
+}
+
+// Take implements Store
+func (s *MemoryStore) Take(ctx context.Context, key string, limit config.Limit) (Result, error) {
+	now := time.Now()
+	capacity := float64(limit.Requests)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: capacity, updated: now}
+		s.buckets[key] = b
+	}
+
+	b.tokens = math.Min(capacity, b.tokens+now.Sub(b.updated).Seconds()*rate(limit))
+	b.updated = now
+
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+	b.full = now.Add(seconds((capacity - b.tokens) / rate(limit)))
+
+	return newResult(limit, b.tokens, allowed), nil
+}
+
+// Fail implements Store
+func (s *MemoryStore) Fail(ctx context.Context, key string, threshold int, lockout time.Duration, forget time.Duration) (int, error) {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	elem, ok := s.failures[key]
+	if ok {
+		s.failureOrder.MoveToBack(elem)
+	} else {
+		elem = s.failureOrder.PushBack(&failures{key: key})
+		s.failures[key] = elem
+		if s.failureOrder.Len() > maxFailureKeys {
+			oldest := s.failureOrder.Front()
+			s.failureOrder.Remove(oldest)
+			delete(s.failures, oldest.Value.(*failures).key)
+		}
+	}
+
+	f := elem.Value.(*failures)
+	f.count++
+	count := f.count
+	if count >= threshold {
+		f.lockedUntil = now.Add(lockout)
+		f.count = 0
+	}
+
+	f.forget = now.Add(forget)
+	if f.lockedUntil.After(f.forget) {
+		f.forget = f.lockedUntil
+	}
+	return count, nil
+}
+
+// LockedUntil implements Store
+func (s *MemoryStore) LockedUntil(ctx context.Context, key string) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if elem, ok := s.failures[key]; ok {
+		return elem.Value.(*failures).lockedUntil, nil
+	}
+	return time.Time{}, nil
+}
+
+// Run forgets the buckets that refilled and the failures past their time every minute,
+// a full bucket is the same as none
+func (s *MemoryStore) Run(ctx context.Context) error {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case now := <-ticker.C:
+			s.sweep(now)
+		}
+	}
+}
+
+func (s *MemoryStore) sweep(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, b := range s.buckets {
+		if now.After(b.full) {
+			delete(s.buckets, key)
+		}
+	}
+	for key, elem := range s.failures {
+		if now.After(elem.Value.(*failures).forget) {
+			s.failureOrder.Remove(elem)
+			delete(s.failures, key)
+		}
+	}
+}
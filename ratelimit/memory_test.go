@@ -0,0 +1,63 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestFailLocksAtThreshold(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore()
+
+	for i := 1; i <= 3; i++ {
+		count, err := s.Fail(ctx, "login:sam", 3, time.Minute, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != i {
+			t.Errorf("failure %d counted as %d", i, count)
+		}
+	}
+	locked, _ := s.LockedUntil(ctx, "login:sam")
+	if until := time.Until(locked); until <= 0 || until > time.Minute {
+		t.Errorf("locked for %s after 3 failures, want a minute", until)
+	}
+	if locked, _ := s.LockedUntil(ctx, "login:other"); !locked.IsZero() {
+		t.Errorf("a key without failures is locked until %v", locked)
+	}
+
+	// The lock outlives forget, so sweeping after forget keeps it
+	s.Fail(ctx, "login:sam", 3, time.Minute, time.Second)
+	s.sweep(time.Now().Add(30 * time.Second))
+	if locked, _ := s.LockedUntil(ctx, "login:sam"); locked.IsZero() {
+		t.Errorf("sweeping during the lockout forgot it")
+	}
+	s.sweep(time.Now().Add(2 * time.Minute))
+	if count, _ := s.Fail(ctx, "login:sam", 3, time.Minute, time.Hour); count != 1 {
+		t.Errorf("after sweeping the failure counted as %d, want 1", count)
+	}
+}
+
+func TestFailForgetsLeastRecentKeys(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore()
+
+	for i := 0; i < maxFailureKeys; i++ {
+		s.Fail(ctx, fmt.Sprint("login:", i), 1, time.Hour, time.Hour)
+	}
+	// Failing again makes login:0 the most recent, so login:1 is forgotten first
+	s.Fail(ctx, "login:0", 5, time.Hour, time.Hour)
+	s.Fail(ctx, "login:new", 1, time.Hour, time.Hour)
+
+	if len(s.failures) != maxFailureKeys || s.failureOrder.Len() != maxFailureKeys {
+		t.Errorf("the store keeps %d keys, want at most %d", len(s.failures), maxFailureKeys)
+	}
+	for key, want := range map[string]bool{"login:0": true, "login:1": false, "login:2": true, "login:new": true} {
+		locked, _ := s.LockedUntil(ctx, key)
+		if !locked.IsZero() != want {
+			t.Errorf("%s locked = %t, want %t", key, !locked.IsZero(), want)
+		}
+	}
+}
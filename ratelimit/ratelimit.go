@@ -0,0 +1,162 @@
+// Package ratelimit throttles clients with token buckets and locks them out after failures
+// in a row, kept in memory or in a Redis compatible server shared by every replica
+package ratelimit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"goplay/config"
+	"goplay/logging"
+	"goplay/metrics"
+	"goplay/model"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Store keeps the buckets, and failures counted in a row
+type Store interface {
+	// Take removes a token from the bucket named key, creating it full if needed
+	Take(ctx context.Context, key string, limit config.Limit) (Result, error)
+	// Fail counts a failure of key. Once threshold failures in a row are reached key is
+	// locked for lockout and counting starts over. It returns the failures in a row,
+	// including the one that locked it. The count is forgotten forget after the last failure.
+	Fail(ctx context.Context, key string, threshold int, lockout time.Duration, forget time.Duration) (int, error)
+	// LockedUntil returns until when Fail locked key, the zero time when it isn't
+	LockedUntil(ctx context.Context, key string) (time.Time, error)
+	// Run maintains the store until ctx is done
+	Run(ctx context.Context) error
+}
+
+// NewStore returns the Redis store when cfg names a server, the memory store otherwise.
+// It is needed even with the limits disabled, for the failures it counts.
+func NewStore(cfg config.RateLimit) (Store, error) {
+	if len(cfg.RedisURL) > 0 {
+		return NewRedisStore(cfg.RedisURL)
+	}
+	return NewMemoryStore(), nil
+}
+
+// Result is the state of a bucket after a request took from it
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is how long until the bucket is full again
+	Reset time.Duration
+	// RetryAfter is how long until the next token when the request was refused
+	RetryAfter time.Duration
+}
+
+// rate returns how many tokens a bucket gets back per second
+func rate(limit config.Limit) float64 {
+	return float64(limit.Requests) / limit.Per.Seconds()
+}
+
+func newResult(limit config.Limit, tokens float64, allowed bool) Result {
+	res := Result{
+		Allowed:   allowed,
+		Limit:     limit.Requests,
+		Remaining: int(tokens),
+		Reset:     seconds((float64(limit.Requests) - tokens) / rate(limit)),
+	}
+	if !allowed {
+		res.RetryAfter = seconds((1 - tokens) / rate(limit))
+	}
+	return res
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// KeyFunc names the client a request counts against. Requests it returns false for are not limited.
+type KeyFunc func(r *http.Request) (string, bool)
+
+// Limiter applies one policy
+type Limiter struct {
+	name  string
+	store Store
+	limit config.Limit
+	key   KeyFunc
+}
+
+// New creates a limiter for the policy called name. Each policy has its own buckets.
+func New(name string, store Store, limit config.Limit, key KeyFunc) *Limiter {
+	return &Limiter{name: name, store: store, limit: limit, key: key}
+}
+
+// Middleware answers 429 once the client ran out of tokens and sets the RateLimit-*
+// headers on every limited response. When the store fails requests are let through.
+func (l *Limiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Preflight requests are answered by CORS and don't count
+		if r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key, ok := l.key(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		res, err := l.store.Take(r.Context(), l.name+":"+key, l.limit)
+		if err != nil {
+			logging.FromContext(r.Context()).Warn("rate limit store failed, not limiting", "policy", l.name, "error", err)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h := w.Header()
+		h.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", l.limit.Requests, ceilSeconds(l.limit.Per)))
+		h.Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+		h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+
+		if !res.Allowed {
+			metrics.RateLimited.WithLabelValues(l.name).Inc()
+
+			retryAfter := ceilSeconds(res.RetryAfter)
+			h.Set("Retry-After", strconv.Itoa(retryAfter))
+			h.Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusTooManyRequests)
+
+			var result model.ResponseResult
+			result.Error = fmt.Sprintf("Too many requests, try again in %d seconds", retryAfter)
+			json.NewEncoder(w).Encode(result)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ceilSeconds rounds up so clients never retry too early
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// ClientIP keys requests by the address of the client. Behind a reverse proxy
+// trustProxy takes it from the last X-Forwarded-For entry, the one the proxy added.
+func ClientIP(trustProxy bool) KeyFunc {
+	return func(r *http.Request) (string, bool) {
+		if trustProxy {
+			if forwarded := r.Header.Get("X-Forwarded-For"); len(forwarded) > 0 {
+				hops := strings.Split(forwarded, ",")
+				return strings.TrimSpace(hops[len(hops)-1]), true
+			}
+		}
+
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			return r.RemoteAddr, true
+		}
+		return host, true
+	}
+}
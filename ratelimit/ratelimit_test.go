@@ -0,0 +1,121 @@
+package ratelimit
+
+import (
+	"context"
+	"goplay/config"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// rewind makes the bucket named key last updated d earlier, as if that much time had passed
+func (s *MemoryStore) rewind(key string, d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.buckets[key].updated = s.buckets[key].updated.Add(-d)
+}
+
+func TestTakeRefills(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore()
+	// One token back every 5 seconds
+	limit := config.Limit{Requests: 2, Per: 10 * time.Second}
+
+	for i, want := range []bool{true, true, false} {
+		res, err := s.Take(ctx, "client", limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Allowed != want {
+			t.Errorf("take %d: allowed = %t, want %t", i+1, res.Allowed, want)
+		}
+	}
+
+	s.rewind("client", 5*time.Second)
+	res, _ := s.Take(ctx, "client", limit)
+	if !res.Allowed || res.Remaining != 0 {
+		t.Errorf("after 5s: allowed = %t with %d remaining, want the one token back", res.Allowed, res.Remaining)
+	}
+
+	// Refilling stops at the limit
+	s.rewind("client", time.Hour)
+	res, _ = s.Take(ctx, "client", limit)
+	if !res.Allowed || res.Remaining != 1 {
+		t.Errorf("after an hour: allowed = %t with %d remaining, want 1 left of 2", res.Allowed, res.Remaining)
+	}
+
+	if res, _ := s.Take(ctx, "other", limit); !res.Allowed || res.Remaining != 1 {
+		t.Errorf("another key: allowed = %t with %d remaining, want a full bucket of its own", res.Allowed, res.Remaining)
+	}
+}
+
+func TestNewResult(t *testing.T) {
+	limit := config.Limit{Requests: 10, Per: time.Minute}
+
+	res := newResult(limit, 0.5, false)
+	if res.Allowed || res.Remaining != 0 || res.RetryAfter != 3*time.Second || res.Reset != 57*time.Second {
+		t.Errorf("half a token left = %+v, want refused, retry after 3s and full after 57s", res)
+	}
+	res = newResult(limit, 4, true)
+	if !res.Allowed || res.Remaining != 4 || res.RetryAfter != 0 || res.Reset != 36*time.Second {
+		t.Errorf("4 tokens left = %+v, want allowed and full after 36s", res)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	limiter := New("test", NewMemoryStore(), config.Limit{Requests: 2, Per: 10 * time.Second}, ClientIP(false))
+	handler := limiter.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	serve := func(method string, remoteAddr string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/login", nil)
+		r.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	for i := 0; i < 2; i++ {
+		if w := serve(http.MethodPost, "192.0.2.1:1234"); w.Code != http.StatusOK {
+			t.Fatalf("request %d answered %d", i+1, w.Code)
+		}
+	}
+	// Preflights are let through without taking a token
+	if w := serve(http.MethodOptions, "192.0.2.1:1234"); w.Code != http.StatusOK || w.Header().Get("RateLimit-Limit") != "" {
+		t.Errorf("a preflight answered %d with RateLimit-Limit %q", w.Code, w.Header().Get("RateLimit-Limit"))
+	}
+
+	w := serve(http.MethodPost, "192.0.2.1:5678")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("the third request answered %d, want 429", w.Code)
+	}
+	// The next token is 5s away, less the moment the requests took
+	for header, want := range map[string]string{
+		"Retry-After":         "5",
+		"RateLimit-Limit":     "2",
+		"RateLimit-Remaining": "0",
+		"RateLimit-Reset":     "10",
+		"RateLimit-Policy":    "2;w=10",
+	} {
+		if got := w.Header().Get(header); got != want {
+			t.Errorf("%s = %q, want %q", header, got, want)
+		}
+	}
+
+	if w := serve(http.MethodPost, "192.0.2.2:1234"); w.Code != http.StatusOK {
+		t.Errorf("another client answered %d", w.Code)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+	r.Header.Set("X-Forwarded-For", "198.51.100.1, 203.0.113.7")
+
+	if key, _ := ClientIP(false)(r); key != "192.0.2.1" {
+		t.Errorf("without a proxy the key is %q, want the remote address", key)
+	}
+	if key, _ := ClientIP(true)(r); key != "203.0.113.7" {
+		t.Errorf("behind a proxy the key is %q, want the address the proxy added", key)
+	}
+}
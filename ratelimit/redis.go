@@ -0,0 +1,107 @@
+package ratelimit
+
+import (
+	"context"
+	"goplay/config"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// takeScript refills and takes from a bucket atomically. It uses the server clock so
+// replicas with skewed clocks agree, and lets the key expire once the bucket is full.
+var takeScript = redis.NewScript(`
+local capacity = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+
+local time = redis.call("TIME")
+local now = tonumber(time[1]) + tonumber(time[2]) / 1000000
+
+local bucket = redis.call("HMGET", KEYS[1], "tokens", "updated")
+local tokens = tonumber(bucket[1]) or capacity
+local updated = tonumber(bucket[2]) or now
+
+tokens = math.min(capacity, tokens + (now - updated) * rate)
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "updated", tostring(now))
+redis.call("PEXPIRE", KEYS[1], math.ceil((capacity - tokens) / rate * 1000) + 1000)
+
+-- Lua numbers would be truncated to integers in the reply
+return {allowed, tostring(tokens)}
+`)
+
+// failScript counts a failure and swaps the count for a lock once it reaches the threshold.
+// The lock expires with the lockout, so its TTL tells how long it has left.
+var failScript = redis.NewScript(`
+local failures = redis.call("INCR", KEYS[1])
+if failures >= tonumber(ARGV[1]) then
+	redis.call("DEL", KEYS[1])
+	redis.call("SET", KEYS[2], "1", "PX", ARGV[2])
+else
+	redis.call("PEXPIRE", KEYS[1], ARGV[3])
+end
+return failures
+`)
+
+// RedisStore keeps the buckets in a Redis compatible server so every replica shares them
+type RedisStore struct {
+	client *redis.Client
+}
+
+// NewRedisStore connects lazily to the server at url, e.g. redis://localhost:6379/0
+func NewRedisStore(url string) (*RedisStore, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+	return &RedisStore{client: redis.NewClient(opts)}, nil
+}
+
+// Take implements Store
+func (s *RedisStore) Take(ctx context.Context, key string, limit config.Limit) (Result, error) {
+	reply, err := takeScript.Run(ctx, s.client, []string{"goplay:ratelimit:" + key},
+		limit.Requests, strconv.FormatFloat(rate(limit), 'f', -1, 64)).Slice()
+	if err != nil {
+		return Result{}, err
+	}
+
+	allowed, _ := reply[0].(int64)
+	left, _ := reply[1].(string)
+	tokens, err := strconv.ParseFloat(left, 64)
+	if err != nil {
+		return Result{}, err
+	}
+	return newResult(limit, tokens, allowed == 1), nil
+}
+
+// Fail implements Store
+func (s *RedisStore) Fail(ctx context.Context, key string, threshold int, lockout time.Duration, forget time.Duration) (int, error) {
+	failures, err := failScript.Run(ctx, s.client, []string{"goplay:failures:" + key, "goplay:locked:" + key},
+		threshold, lockout.Milliseconds(), forget.Milliseconds()).Int()
+	if err != nil {
+		return 0, err
+	}
+	return failures, nil
+}
+
+// LockedUntil implements Store
+func (s *RedisStore) LockedUntil(ctx context.Context, key string) (time.Time, error) {
+	// PTTL answers negative values when the lock doesn't exist
+	left, err := s.client.PTTL(ctx, "goplay:locked:"+key).Result()
+	if err != nil || left <= 0 {
+		return time.Time{}, err
+	}
+	return time.Now().Add(left), nil
+}
+
+// Run closes the connections once ctx is done
+func (s *RedisStore) Run(ctx context.Context) error {
+	<-ctx.Done()
+	return s.client.Close()
+}
@@ -0,0 +1,37 @@
+// Package response records what handlers write, for the middleware that logs,
+// measures and traces requests
+package response
+
+import "net/http"
+
+// Recorder remembers the status code and size of a response
+type Recorder struct {
+	http.ResponseWriter
+	Status int
+	Bytes  int64
+}
+
+// Record returns a Recorder writing to w. When an outer middleware already wraps the
+// response in one it is shared, so every middleware of a request sees the same response.
+func Record(w http.ResponseWriter) *Recorder {
+	if rec, ok := w.(*Recorder); ok {
+		return rec
+	}
+	return &Recorder{ResponseWriter: w, Status: http.StatusOK}
+}
+
+func (r *Recorder) WriteHeader(status int) {
+	r.Status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *Recorder) Write(b []byte) (int, error) {
+	n, err := r.ResponseWriter.Write(b)
+	r.Bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the writer underneath
+func (r *Recorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,124 @@
+// Package twofactor enrolls TOTP authenticators, checks their codes and issues the
+// one-time recovery codes that replace a lost authenticator
+package twofactor
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"image/png"
+	"strings"
+	"time"
+
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+)
+
+const (
+	// period is the number of seconds a code is valid for, what authenticator apps expect
+	period = 30
+	// skew accepts codes of the steps before and after the current one, for clocks that drift
+	skew = 1
+	// qrSize is the width and height of the enrollment QR code in pixels
+	qrSize = 256
+
+	recoveryCodes      = 10
+	recoveryCodeLength = 10
+	// recoveryAlphabet leaves out characters that are easy to misread. Its 32 characters
+	// divide 256, so every one is as likely to be drawn from a random byte.
+	recoveryAlphabet = "abcdefghjkmnpqrstuvwxyz123456789"
+)
+
+var validateOpts = totp.ValidateOpts{
+	Period:    period,
+	Skew:      skew,
+	Digits:    otp.DigitsSix,
+	Algorithm: otp.AlgorithmSHA1,
+}
+
+// Enrollment is a new authenticator secret with the ways to add it to an app
+type Enrollment struct {
+	Secret string
+	URI    string
+	QRCode []byte
+}
+
+// Enroll generates a secret for account and renders its otpauth URI as a PNG QR code
+func Enroll(issuer string, account string) (Enrollment, error) {
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      issuer,
+		AccountName: account,
+		Period:      period,
+		Digits:      otp.DigitsSix,
+		Algorithm:   otp.AlgorithmSHA1,
+	})
+	if err != nil {
+		return Enrollment{}, err
+	}
+
+	img, err := key.Image(qrSize, qrSize)
+	if err != nil {
+		return Enrollment{}, err
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return Enrollment{}, err
+	}
+
+	return Enrollment{Secret: key.Secret(), URI: key.URL(), QRCode: buf.Bytes()}, nil
+}
+
+// Validate checks code against secret at t. It returns the time step the code belongs to,
+// which callers store so the same code can't be used twice.
+func Validate(secret string, code string, t time.Time) (int64, bool) {
+	code = strings.TrimSpace(code)
+	if len(code) != validateOpts.Digits.Length() {
+		return 0, false
+	}
+
+	counter := t.Unix() / period
+	for step := counter - skew; step <= counter+skew; step++ {
+		expected, err := totp.GenerateCodeCustom(secret, time.Unix(step*period, 0), validateOpts)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// RecoveryCodes generates a new set of recovery codes and the hashes to store of them
+func RecoveryCodes() (codes []string, hashes []string, err error) {
+	for i := 0; i < recoveryCodes; i++ {
+		raw := make([]byte, recoveryCodeLength)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, nil, err
+		}
+		for j := range raw {
+			raw[j] = recoveryAlphabet[int(raw[j])%len(recoveryAlphabet)]
+		}
+
+		code := string(raw[:recoveryCodeLength/2]) + "-" + string(raw[recoveryCodeLength/2:])
+		codes = append(codes, code)
+		hashes = append(hashes, HashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// HashRecoveryCode is what is stored of a recovery code. Case, spaces and dashes
+// don't matter, so codes can be typed the way they were written down.
+func HashRecoveryCode(code string) string {
+	code = strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToLower(code))
+
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,90 @@
+package twofactor
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pquerna/otp/totp"
+)
+
+func codeAt(t *testing.T, secret string, at time.Time) string {
+	t.Helper()
+	code, err := totp.GenerateCodeCustom(secret, at, validateOpts)
+	if err != nil {
+		t.Fatal("generating code:", err)
+	}
+	return code
+}
+
+func TestValidateSkew(t *testing.T) {
+	enrollment, err := Enroll("goplay", "sam")
+	if err != nil {
+		t.Fatal("enrolling:", err)
+	}
+	now := time.Unix(1700000000, 0)
+	counter := now.Unix() / period
+
+	for _, tt := range []struct {
+		steps int64
+		ok    bool
+	}{{-2, false}, {-1, true}, {0, true}, {1, true}, {2, false}} {
+		code := codeAt(t, enrollment.Secret, time.Unix((counter+tt.steps)*period, 0))
+		step, ok := Validate(enrollment.Secret, code, now)
+		if ok != tt.ok {
+			t.Errorf("a code %d steps away: ok = %t, want %t", tt.steps, ok, tt.ok)
+		}
+		if ok && step != counter+tt.steps {
+			t.Errorf("a code %d steps away is of step %d, want %d", tt.steps, step, counter+tt.steps)
+		}
+	}
+
+	code := codeAt(t, enrollment.Secret, now)
+	if _, ok := Validate(enrollment.Secret, " "+code+"\n", now); !ok {
+		t.Errorf("a code with spaces around it was rejected")
+	}
+	for _, code := range []string{"", "12345", "1234567", "abcdef"} {
+		if _, ok := Validate(enrollment.Secret, code, now); ok {
+			t.Errorf("Validate accepted %q", code)
+		}
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, hashes, err := RecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != recoveryCodes || len(hashes) != recoveryCodes {
+		t.Fatalf("got %d codes and %d hashes, want %d", len(codes), len(hashes), recoveryCodes)
+	}
+
+	seen := map[string]bool{}
+	for i, code := range codes {
+		if len(code) != recoveryCodeLength+1 || code[recoveryCodeLength/2] != '-' {
+			t.Errorf("code %q isn't two halves around a dash", code)
+		}
+		if strings.Trim(strings.Replace(code, "-", "", 1), recoveryAlphabet) != "" {
+			t.Errorf("code %q has characters outside the alphabet", code)
+		}
+		if hashes[i] != HashRecoveryCode(code) || strings.Contains(hashes[i], code) {
+			t.Errorf("hash %q isn't the hash of %q", hashes[i], code)
+		}
+		if seen[code] {
+			t.Errorf("code %q was issued twice", code)
+		}
+		seen[code] = true
+	}
+}
+
+func TestHashRecoveryCodeIgnoresFormatting(t *testing.T) {
+	want := HashRecoveryCode("abcde-fghjk")
+	for _, typed := range []string{"abcdefghjk", "ABCDE-FGHJK", "abcde fghjk", " abcde-fghjk "} {
+		if got := HashRecoveryCode(typed); got != want {
+			t.Errorf("%q hashes differently from abcde-fghjk", typed)
+		}
+	}
+	if HashRecoveryCode("abcde-fghjm") == want {
+		t.Errorf("another code has the same hash")
+	}
+}
@@ -0,0 +1,235 @@
+// Package validation checks models against the rules in their validate struct tags
+// and the rules that need the database, such as unique habit names
+package validation
+
+import (
+	"context"
+	"fmt"
+	"goplay/config"
+	"goplay/database"
+	"goplay/model"
+	"reflect"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/go-playground/validator/v10"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Errors lists every invalid field of a model
+type Errors []model.FieldError
+
+func (e Errors) Error() string {
+	fields := make([]string, len(e))
+	for i, field := range e {
+		fields[i] = field.Field + " " + field.Message
+	}
+	return "invalid " + strings.Join(fields, ", ")
+}
+
+// Extensions adds the invalid fields to the error in GraphQL responses
+func (e Errors) Extensions() map[string]interface{} {
+	return map[string]interface{}{"fields": []model.FieldError(e)}
+}
+
+var usernamePattern = regexp.MustCompile(`^[a-zA-Z0-9._-]+$`)
+
+var validate = newValidator()
+
+func newValidator() *validator.Validate {
+	v := validator.New()
+
+	// Report fields under the names clients send them as
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+		if name == "-" || len(name) == 0 {
+			return field.Name
+		}
+		return name
+	})
+
+	v.RegisterValidation("notblank", func(fl validator.FieldLevel) bool {
+		return len(strings.TrimSpace(fl.Field().String())) > 0
+	})
+	v.RegisterValidation("username", func(fl validator.FieldLevel) bool {
+		return usernamePattern.MatchString(fl.Field().String())
+	})
+	return v
+}
+
+// Struct checks the validate tags of v
+func Struct(v interface{}) error {
+	err := validate.Struct(v)
+	if err == nil {
+		return nil
+	}
+
+	invalid, ok := err.(validator.ValidationErrors)
+	if !ok {
+		return err
+	}
+
+	errs := make(Errors, len(invalid))
+	for i, fe := range invalid {
+		// Drop the struct name, e.g. Log.habits[0] becomes habits[0]
+		field := fe.Namespace()
+		if dot := strings.Index(field, "."); dot >= 0 {
+			field = field[dot+1:]
+		}
+		errs[i] = model.FieldError{Field: field, Message: message(fe)}
+	}
+	return errs
+}
+
+func message(fe validator.FieldError) string {
+	unit := "characters"
+	if fe.Kind() == reflect.Slice {
+		unit = "items"
+	}
+
+	switch fe.Tag() {
+	case "required", "notblank":
+		return "is required"
+	case "min":
+		return "must have at least " + fe.Param() + " " + unit
+	case "max":
+		return "must have at most " + fe.Param() + " " + unit
+	case "username":
+		return "may only contain letters, digits, '.', '_' and '-'"
+	case "email":
+		return "must be an email address"
+	case "timezone":
+		return "must be an IANA time zone like Europe/Paris"
+	case "oneof":
+		return "must be one of " + strings.Join(strings.Fields(fe.Param()), ", ")
+	}
+	return "is invalid"
+}
+
+// maxPasswordBytes is as much as bcrypt looks at, whichever algorithm is configured
+const maxPasswordBytes = 72
+
+// Password checks a new password against the policy
+func Password(policy config.PasswordPolicy, password string, username string) error {
+	var errs Errors
+	problem := func(message string) {
+		errs = append(errs, model.FieldError{Field: "password", Message: message})
+	}
+
+	if utf8.RuneCountInString(password) < policy.MinLength {
+		problem(fmt.Sprintf("must have at least %d characters", policy.MinLength))
+	}
+	if len(password) > maxPasswordBytes {
+		problem(fmt.Sprintf("must have at most %d bytes", maxPasswordBytes))
+	}
+	if policy.RequireUpper && !strings.ContainsFunc(password, unicode.IsUpper) {
+		problem("must contain an uppercase letter")
+	}
+	if policy.RequireLower && !strings.ContainsFunc(password, unicode.IsLower) {
+		problem("must contain a lowercase letter")
+	}
+	if policy.RequireDigit && !strings.ContainsFunc(password, unicode.IsDigit) {
+		problem("must contain a digit")
+	}
+	if policy.RequireSymbol && !strings.ContainsFunc(password, isSymbol) {
+		problem("must contain a symbol")
+	}
+	if policy.RejectUsername && len(username) > 0 && strings.Contains(strings.ToLower(password), strings.ToLower(username)) {
+		problem("must not contain the username")
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func isSymbol(r rune) bool {
+	return unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r)
+}
+
+// User checks a user before registration
+func User(user model.User, policy config.PasswordPolicy) error {
+	err := Struct(user)
+	if err != nil {
+		if _, ok := err.(Errors); !ok {
+			return err
+		}
+	}
+	if len(user.Password) == 0 {
+		return err
+	}
+
+	passwordErr := Password(policy, user.Password, user.Username)
+	if passwordErr == nil {
+		return err
+	}
+	if err == nil {
+		return passwordErr
+	}
+	return append(err.(Errors), passwordErr.(Errors)...)
+}
+
+// Log checks a log before it is created or updated
+func Log(logEntry model.Log) error {
+	return Struct(logEntry)
+}
+
+// Identity checks an identity before it is created or updated
+func Identity(identity model.Identity) error {
+	return Struct(identity)
+}
+
+// EmailTaken is the error of an email address another account already uses
+var EmailTaken = Errors{{Field: "email", Message: "is already used by another account"}}
+
+// AccountUpdate checks the changes to an account. Reset links go to the email
+// address, so it can't be taken from another account.
+func AccountUpdate(ctx context.Context, update model.AccountUpdate, owner primitive.ObjectID) error {
+	if err := Struct(update); err != nil {
+		return err
+	}
+	if update.Email == nil || len(*update.Email) == 0 {
+		return nil
+	}
+
+	user, err := database.GetUserByEmail(ctx, *update.Email)
+	if err == database.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if user.OID != owner {
+		return EmailTaken
+	}
+	return nil
+}
+
+// APIKey checks an API key before it is created
+func APIKey(key model.APIKey) error {
+	return Struct(key)
+}
+
+// Habit checks a habit of owner before it is created, or updated when id is set.
+// Logs refer to habits by name, so names are unique per user. The check is only for a
+// friendly answer, the unique index of the database is what keeps concurrent writes apart.
+func Habit(ctx context.Context, habit model.Habit, owner primitive.ObjectID, id *primitive.ObjectID) error {
+	if err := Struct(habit); err != nil {
+		return err
+	}
+
+	taken, err := database.HabitNameTaken(ctx, owner, habit.Name, id)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return HabitNameTaken
+	}
+	return nil
+}
+
+// HabitNameTaken is the error of a habit name another habit of the owner already uses
+var HabitNameTaken = Errors{{Field: "name", Message: "is already used by another habit"}}
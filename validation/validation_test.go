@@ -0,0 +1,74 @@
+package validation
+
+import (
+	"goplay/config"
+	"goplay/model"
+	"strings"
+	"testing"
+)
+
+// messages lists the field errors of err as "field message", "" when it is nil
+func messages(t *testing.T, err error) string {
+	t.Helper()
+	if err == nil {
+		return ""
+	}
+	errs, ok := err.(Errors)
+	if !ok {
+		t.Fatalf("got %T, want Errors: %v", err, err)
+	}
+	var fields []string
+	for _, field := range errs {
+		fields = append(fields, field.Field+" "+field.Message)
+	}
+	return strings.Join(fields, "; ")
+}
+
+func TestPassword(t *testing.T) {
+	strict := config.PasswordPolicy{MinLength: 8, RequireUpper: true, RequireLower: true, RequireDigit: true, RequireSymbol: true, RejectUsername: true}
+
+	for _, tt := range []struct {
+		name     string
+		policy   config.PasswordPolicy
+		password string
+		want     string
+	}{
+		{"long enough", config.PasswordPolicy{MinLength: 8}, "abcdefgh", ""},
+		{"too short", config.PasswordPolicy{MinLength: 8}, "abcdefg", "password must have at least 8 characters"},
+		{"characters, not bytes", config.PasswordPolicy{MinLength: 8}, "ééééééé", "password must have at least 8 characters"},
+		{"72 bytes", config.PasswordPolicy{MinLength: 8}, strings.Repeat("a", 72), ""},
+		{"73 bytes", config.PasswordPolicy{MinLength: 8}, strings.Repeat("a", 73), "password must have at most 72 bytes"},
+		// 37 characters, but 74 bytes bcrypt would cut
+		{"multibyte over 72 bytes", config.PasswordPolicy{MinLength: 8}, strings.Repeat("é", 37), "password must have at most 72 bytes"},
+		{"every class", strict, "Correct-h0rse", ""},
+		{"no uppercase", strict, "correct-h0rse", "password must contain an uppercase letter"},
+		{"no lowercase", strict, "CORRECT-H0RSE", "password must contain a lowercase letter"},
+		{"no digit", strict, "Correct-horse", "password must contain a digit"},
+		{"no symbol", strict, "Correcth0rse", "password must contain a symbol"},
+		{"space as symbol", strict, "Correct h0rse", ""},
+		{"username in any case", strict, "My-SAM-passw0rd", "password must not contain the username"},
+		{"username allowed", config.PasswordPolicy{MinLength: 8}, "my-sam-password", ""},
+		{"every problem", strict, "sam", "password must have at least 8 characters; password must contain an uppercase letter; " +
+			"password must contain a digit; password must contain a symbol; password must not contain the username"},
+	} {
+		if got := messages(t, Password(tt.policy, tt.password, "sam")); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestUserChecksPasswordPolicy(t *testing.T) {
+	policy := config.PasswordPolicy{MinLength: 8}
+
+	if got := messages(t, User(model.User{Username: "sam", Password: "correct horse"}, policy)); got != "" {
+		t.Errorf("a valid user: %s", got)
+	}
+	if got, want := messages(t, User(model.User{Username: "s", Password: "short"}, policy)),
+		"username must have at least 3 characters; password must have at least 8 characters"; got != want {
+		t.Errorf("an invalid user and password: got %q, want %q", got, want)
+	}
+	// A missing password is reported once, by the struct rules
+	if got := messages(t, User(model.User{Username: "sam"}, policy)); strings.Count(got, "password") != 1 {
+		t.Errorf("a missing password: %s", got)
+	}
+}
@@ -0,0 +1,59 @@
+// Package version reports what build of the server is running
+package version
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// Commit and BuildTime are set at build time:
+//
+//	go build -ldflags "-X goplay/version.Commit=$(git rev-parse HEAD) -X goplay/version.BuildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// When left empty they fall back to the version control info the go tool embeds.
+var (
+	Commit    string
+	BuildTime string
+)
+
+// Info describes the running binary
+type Info struct {
+	Commit    string `json:"commit"`
+	BuildTime string `json:"build_time"`
+	GoVersion string `json:"go_version"`
+	Modified  bool   `json:"modified,omitempty"`
+}
+
+// Get returns the build info, preferring the values set with ldflags
+func Get() Info {
+	info := Info{
+		Commit:    Commit,
+		BuildTime: BuildTime,
+		GoVersion: runtime.Version(),
+	}
+
+	if build, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range build.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				if len(info.Commit) == 0 {
+					info.Commit = setting.Value
+				}
+			case "vcs.time":
+				if len(info.BuildTime) == 0 {
+					info.BuildTime = setting.Value
+				}
+			case "vcs.modified":
+				info.Modified = setting.Value == "true"
+			}
+		}
+	}
+
+	if len(info.Commit) == 0 {
+		info.Commit = "unknown"
+	}
+	if len(info.BuildTime) == 0 {
+		info.BuildTime = "unknown"
+	}
+	return info
+}
@@ -0,0 +1,95 @@
+// Package worker runs the background goroutines of the server so they can be
+// stopped before the database is disconnected
+package worker
+
+import (
+	"context"
+	"log"
+	"sync"
+)
+
+// Group runs named workers until it is stopped
+type Group struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu     sync.Mutex
+	status map[string]string
+}
+
+// NewGroup creates an empty group
+func NewGroup() *Group {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Group{
+		ctx:    ctx,
+		cancel: cancel,
+		status: map[string]string{},
+	}
+}
+
+// Go runs fn in a goroutine. fn must return once ctx is done.
+// A worker returning an error before that is reported as failed by Status.
+func (g *Group) Go(name string, fn func(ctx context.Context) error) {
+	g.setStatus(name, "running")
+	g.wg.Add(1)
+
+	go func() {
+		defer g.wg.Done()
+
+		err := fn(g.ctx)
+		if err != nil && g.ctx.Err() == nil {
+			// Only logged, the status is shown to anyone asking /readyz
+			log.Println("Worker", name, "failed:", err)
+			g.setStatus(name, "failed")
+			return
+		}
+		g.setStatus(name, "stopped")
+	}()
+}
+
+func (g *Group) setStatus(name string, status string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.status[name] = status
+}
+
+// Status returns the state of every worker started so far
+func (g *Group) Status() map[string]string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	status := make(map[string]string, len(g.status))
+	for name, s := range g.status {
+		status[name] = s
+	}
+	return status
+}
+
+// Healthy reports whether every worker is still running
+func (g *Group) Healthy() bool {
+	for _, s := range g.Status() {
+		if s != "running" {
+			return false
+		}
+	}
+	return true
+}
+
+// Stop cancels the workers and waits for them to return or for ctx to be done
+func (g *Group) Stop(ctx context.Context) error {
+	g.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		g.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}